@@ -0,0 +1,256 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// BusBinding is the name of the Bus binding in the context of a contained
+// component.
+const BusBinding = "contextual.bus"
+
+// Message is a message published on a bus.
+type Message struct {
+	Topic   string
+	Payload interface{}
+}
+
+// Bus is the publish/subscribe bus of a container, shared by its
+// components. Topics are hierarchical, with levels separated by "/": a
+// subscription to a topic receives the messages of the topic and of its
+// subtopics, and a "*" level of a subscription matches any level (e.g.
+// "orders/*/created").
+//
+// The subscriptions of a component are cancelled when it is stopped or
+// removed from the container, so components should subscribe when started.
+type Bus interface {
+	// Publish delivers the message to the subscribers of the topic.
+	// Synchronous subscribers are called before Publish returns, and
+	// messages are queued for asynchronous subscribers.
+	// Errors:
+	//  IllegalArgumentError <= topic is not valid
+	//  BusError <= the buffers of asynchronous subscribers are full; the
+	//   message is dropped for those subscribers, and delivered to the rest
+	Publish(topic string, payload interface{}) error
+
+	// Subscribe subscribes the handler to the topic, and returns the func
+	// that cancels the subscription.
+	// Errors:
+	//  IllegalArgumentError <= topic is not valid, or handler is nil
+	Subscribe(topic string, handler func(Message), opts ...SubscribeOption) (cancel func(), e error)
+}
+
+// SubscribeOption configures a subscription.
+type SubscribeOption func(*subscription)
+
+// Async delivers the messages of the subscription on a goroutine of its own,
+// buffering up to n messages; the default is synchronous delivery.
+func Async(n int) SubscribeOption {
+	return func(s *subscription) {
+		if n < 1 {
+			n = 1
+		}
+		s.queue = make(chan Message, n)
+	}
+}
+
+// LookupBus returns the bus bound in the context.
+//
+// Errors:
+//
+//	NoSuchBindingError <= no bus is bound in the context
+func LookupBus(ctx Context) (Bus, error) {
+	v, e := ctx.Lookup(BusBinding)
+	if e != nil {
+		return nil, e
+	}
+	bus, ok := v.(Bus)
+	if !ok {
+		return nil, NoSuchBindingError(BusBinding)
+	}
+	return bus, nil
+}
+
+// ----------------------------------------------------------------------------
+// container bus
+// ----------------------------------------------------------------------------
+
+// the bus of a container. Subscriptions are copied on write.
+type bus struct {
+	sync.RWMutex
+	subscriptions []*subscription
+}
+
+type subscription struct {
+	levels  []string
+	handler func(Message)
+	queue   chan Message  // asynchronous delivery; nil for synchronous
+	done    chan struct{} // closed when cancelled
+	once    sync.Once
+}
+
+// the bus of an entry; tracks the subscriptions of its component.
+type entryBus struct {
+	sync.Mutex
+	bus           *bus
+	subscriptions map[*subscription]struct{}
+}
+
+// returns the levels of the topic. Only subscriptions may use wildcards.
+func topicLevels(topic string, wildcards bool) ([]string, error) {
+	if topic == "" {
+		return nil, IllegalArgumentError("topic is empty")
+	}
+	levels := strings.Split(topic, "/")
+	for _, level := range levels {
+		if level == "" || (!wildcards && level == "*") {
+			return nil, IllegalArgumentError(fmt.Sprintf("topic %q is not valid", topic))
+		}
+	}
+	return levels, nil
+}
+
+func (s *subscription) matches(levels []string) bool {
+	if len(s.levels) > len(levels) {
+		return false
+	}
+	for i, level := range s.levels {
+		if level != "*" && level != levels[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *subscription) cancelled() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *subscription) deliver() {
+	for {
+		select {
+		case msg := <-s.queue:
+			if !s.cancelled() {
+				s.handler(msg)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (b *bus) Publish(topic string, payload interface{}) error {
+	levels, e := topicLevels(topic, false)
+	if e != nil {
+		return e
+	}
+	b.RLock()
+	subscriptions := b.subscriptions
+	b.RUnlock()
+
+	msg := Message{topic, payload}
+	dropped := 0
+	for _, s := range subscriptions {
+		if !s.matches(levels) || s.cancelled() {
+			continue
+		}
+		if s.queue == nil {
+			s.handler(msg)
+			continue
+		}
+		select {
+		case s.queue <- msg:
+		default:
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		return BusError(fmt.Sprintf("%s: dropped for %d backlogged subscriber(s)", topic, dropped))
+	}
+	return nil
+}
+
+func (b *bus) Subscribe(topic string, handler func(Message), opts ...SubscribeOption) (func(), error) {
+	s, e := b.subscribe(topic, handler, opts)
+	if e != nil {
+		return nil, e
+	}
+	return func() { b.cancel(s) }, nil
+}
+
+func (b *bus) subscribe(topic string, handler func(Message), opts []SubscribeOption) (*subscription, error) {
+	levels, e := topicLevels(topic, true)
+	if e != nil {
+		return nil, e
+	}
+	if handler == nil {
+		return nil, IllegalArgumentError("handler is nil")
+	}
+	s := &subscription{levels: levels, handler: handler, done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.queue != nil {
+		go s.deliver()
+	}
+
+	b.Lock()
+	b.subscriptions = append(b.subscriptions[:len(b.subscriptions):len(b.subscriptions)], s)
+	b.Unlock()
+	return s, nil
+}
+
+func (b *bus) cancel(s *subscription) {
+	s.once.Do(func() {
+		close(s.done)
+		b.Lock()
+		defer b.Unlock()
+		for i, s0 := range b.subscriptions {
+			if s0 == s {
+				b.subscriptions = append(b.subscriptions[:i:i], b.subscriptions[i+1:]...)
+				break
+			}
+		}
+	})
+}
+
+func (eb *entryBus) Publish(topic string, payload interface{}) error {
+	return eb.bus.Publish(topic, payload)
+}
+
+func (eb *entryBus) Subscribe(topic string, handler func(Message), opts ...SubscribeOption) (func(), error) {
+	s, e := eb.bus.subscribe(topic, handler, opts)
+	if e != nil {
+		return nil, e
+	}
+	eb.Lock()
+	eb.subscriptions[s] = struct{}{}
+	eb.Unlock()
+	return func() {
+		eb.Lock()
+		delete(eb.subscriptions, s)
+		eb.Unlock()
+		eb.bus.cancel(s)
+	}, nil
+}
+
+// cancels the subscriptions of the component.
+func (eb *entryBus) cancelAll() {
+	eb.Lock()
+	subscriptions := eb.subscriptions
+	eb.subscriptions = make(map[*subscription]struct{})
+	eb.Unlock()
+	for s := range subscriptions {
+		eb.bus.cancel(s)
+	}
+}
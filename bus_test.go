@@ -0,0 +1,152 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"goerror"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// testing: container bus
+// ============================================================================
+
+func TestBusTopics(t *testing.T) {
+	c, _ := newTestContainer(t)
+	a := &testComponent{name: "a"}
+	c.Add(a)
+	bus, e := LookupBus(a.context)
+	if e != nil {
+		t.Fatalf("LookupBus - unexpected error: %s", e)
+	}
+
+	received := make(map[string][]string)
+	for _, topic := range []string{"orders", "orders/created", "orders/*/paid", "*", "users"} {
+		topic := topic
+		if _, e := bus.Subscribe(topic, func(m Message) { received[topic] = append(received[topic], m.Topic) }); e != nil {
+			t.Fatalf("Subscribe(%s) - unexpected error: %s", topic, e)
+		}
+	}
+	for _, topic := range []string{"orders", "orders/created", "orders/eu/paid", "invoices"} {
+		if e := bus.Publish(topic, nil); e != nil {
+			t.Fatalf("Publish(%s) - unexpected error: %s", topic, e)
+		}
+	}
+	expected := map[string][]string{
+		"orders":         {"orders", "orders/created", "orders/eu/paid"},
+		"orders/created": {"orders/created"},
+		"orders/*/paid":  {"orders/eu/paid"},
+		"*":              {"orders", "orders/created", "orders/eu/paid", "invoices"},
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Fatalf("Publish - expected:%v got:%v", expected, received)
+	}
+
+	for _, topic := range []string{"", "/", "a//b", "a/"} {
+		assertError(t, "Subscribe("+topic+")", func() error { _, e := bus.Subscribe(topic, func(Message) {}); return e }(), IllegalArgumentError)
+	}
+	assertError(t, "Subscribe(nil handler)", func() error { _, e := bus.Subscribe("a", nil); return e }(), IllegalArgumentError)
+	assertError(t, "Publish(wildcard)", bus.Publish("orders/*", nil), IllegalArgumentError)
+}
+
+func TestBusAsync(t *testing.T) {
+	c, _ := newTestContainer(t)
+	a, b := &testComponent{name: "a"}, &testComponent{name: "b"}
+	c.Add(a)
+	c.Add(b)
+	publisher, _ := LookupBus(a.context)
+	subscriber, _ := LookupBus(b.context)
+
+	release := make(chan struct{})
+	received := make(chan interface{}, 8)
+	cancel, _ := subscriber.Subscribe("jobs", func(m Message) {
+		<-release
+		received <- m.Payload
+	}, Async(2))
+
+	// one message is delivered (and blocks), two are buffered
+	for i := 0; i < 3; i++ {
+		if e := publisher.Publish("jobs", i); e != nil {
+			t.Fatalf("Publish(%d) - unexpected error: %s", i, e)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	e := publisher.Publish("jobs", 3)
+	if e == nil || !goerror.TypeOf(e).Is(BusError) {
+		t.Fatalf("Publish(backlogged) - expected error: %s got: %v", BusError(), e)
+	}
+	close(release)
+	for i := 0; i < 3; i++ {
+		select {
+		case v := <-received:
+			if v != i {
+				t.Fatalf("Async - expected:%d got:%v", i, v)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Async - message %d is not delivered", i)
+		}
+	}
+
+	cancel()
+	cancel()
+	publisher.Publish("jobs", 4)
+	select {
+	case v := <-received:
+		t.Fatalf("Async - cancelled subscription received %v", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// helper - a component that subscribes when started
+type subscribingComponent struct {
+	*lifecycleComponent
+	received []interface{}
+}
+
+func (c *subscribingComponent) Start() error {
+	bus, e := LookupBus(c.context)
+	if e != nil {
+		return e
+	}
+	if _, e := bus.Subscribe("events", func(m Message) { c.received = append(c.received, m.Payload) }); e != nil {
+		return e
+	}
+	return c.lifecycleComponent.Start()
+}
+
+func TestBusUnsubscribe(t *testing.T) {
+	c, _ := newTestContainer(t)
+	log := &callLog{}
+	sub := &subscribingComponent{lifecycleComponent: newLifecycleComponent("sub", log)}
+	pub := newLifecycleComponent("pub", log)
+	c.Add(sub)
+	c.Add(pub)
+	bus, _ := LookupBus(pub.context)
+
+	c.Start()
+	bus.Publish("events", 1)
+	c.Transition("sub", StateStopped)
+	bus.Publish("events", 2)
+	c.Transition("sub", StateStarted)
+	bus.Publish("events", 3)
+	c.Transition("sub", StateStopped)
+	c.Remove(sub)
+	bus.Publish("events", 4)
+	if !reflect.DeepEqual(sub.received, []interface{}{1, 3}) {
+		t.Fatalf("Bus - expected:%v got:%v", []interface{}{1, 3}, sub.received)
+	}
+
+	// containers are buses of their own
+	nested := NewContainer()
+	c.AddNamed("nested", nested)
+	inner := &testComponent{name: "inner"}
+	nested.Add(inner)
+	innerBus, _ := LookupBus(inner.context)
+	if innerBus == bus || innerBus.(*entryBus).bus == bus.(*entryBus).bus {
+		t.Fatalf("Bus - nested container shares the bus of its container")
+	}
+}
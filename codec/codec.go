@@ -0,0 +1,182 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+// Package codec provides a registry of value codecs for Context
+// implementations that need to encode (untyped) binding values, e.g. for
+// storage or transport.
+//
+// A codec is registered for a Go type under a tag. Encode selects the codec
+// by the dynamic type of the value and returns the tag along with the
+// encoded bytes, and Decode uses the tag to select the codec for decoding.
+// Tags must be stable across processes and releases.
+//
+// Codecs for string, bool, []byte, the sized and unsized integer types,
+// float32, float64, time.Duration and time.Time are pre-registered.
+package codec
+
+import (
+	"encoding/json"
+	"goerror"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	IllegalArgumentError   = goerror.Define("illegal argument")
+	AlreadyRegisteredError = goerror.Define("codec already registered")
+	NoSuchCodecError       = goerror.Define("no such codec")
+	EncodeError            = goerror.Define("encode error")
+	DecodeError            = goerror.Define("decode error")
+)
+
+// Codec encodes and decodes values of a specific Go type.
+type Codec interface {
+	// Encode returns the encoding of v. v is always of the type the
+	// codec was registered for.
+	Encode(v interface{}) ([]byte, error)
+	// Decode returns the value of the encoding b.
+	Decode(b []byte) (interface{}, error)
+}
+
+type registry struct {
+	sync.RWMutex
+	bytag  map[string]Codec
+	bytype map[reflect.Type]string
+}
+
+var codecs = &registry{
+	bytag:  make(map[string]Codec),
+	bytype: make(map[reflect.Type]string),
+}
+
+func init() {
+	for tag, sample := range map[string]interface{}{
+		"string":   "",
+		"bool":     false,
+		"bytes":    []byte(nil),
+		"int":      int(0),
+		"int8":     int8(0),
+		"int16":    int16(0),
+		"int32":    int32(0),
+		"int64":    int64(0),
+		"uint":     uint(0),
+		"uint8":    uint8(0),
+		"uint16":   uint16(0),
+		"uint32":   uint32(0),
+		"uint64":   uint64(0),
+		"float32":  float32(0),
+		"float64":  float64(0),
+		"duration": time.Duration(0),
+		"time":     time.Time{},
+	} {
+		if e := Register(tag, sample, JSON(sample)); e != nil {
+			panic(e)
+		}
+	}
+}
+
+// Register associates the codec with the dynamic type of sample, under
+// the given tag.
+//
+// Errors:
+//
+//	IllegalArgumentError <= zero-value tag, nil sample or nil codec
+//	AlreadyRegisteredError <= tag or type already has a registered codec
+func Register(tag string, sample interface{}, c Codec) error {
+	if tag == "" || sample == nil || c == nil {
+		return IllegalArgumentError("tag, sample, and codec are required")
+	}
+	typ := reflect.TypeOf(sample)
+
+	codecs.Lock()
+	defer codecs.Unlock()
+
+	if _, ok := codecs.bytag[tag]; ok {
+		return AlreadyRegisteredError("tag:", tag)
+	}
+	if t, ok := codecs.bytype[typ]; ok {
+		return AlreadyRegisteredError("type:", typ.String(), "tag:", t)
+	}
+	codecs.bytag[tag] = c
+	codecs.bytype[typ] = tag
+	return nil
+}
+
+// Encode encodes v with the codec registered for its dynamic type.
+//
+// Errors:
+//
+//	NoSuchCodecError <= no codec is registered for the type of v
+//	EncodeError <= codec failed
+func Encode(v interface{}) (tag string, b []byte, e error) {
+	typ := reflect.TypeOf(v)
+
+	codecs.RLock()
+	tag, ok := codecs.bytype[typ]
+	c := codecs.bytag[tag]
+	codecs.RUnlock()
+
+	if !ok {
+		return "", nil, NoSuchCodecError("type:", typeName(typ))
+	}
+	if b, e = c.Encode(v); e != nil {
+		return "", nil, EncodeError(tag).WithCause(e)
+	}
+	return tag, b, nil
+}
+
+// Decode decodes b with the codec registered under tag.
+//
+// Errors:
+//
+//	NoSuchCodecError <= no codec is registered for the tag
+//	DecodeError <= codec failed
+func Decode(tag string, b []byte) (interface{}, error) {
+	codecs.RLock()
+	c, ok := codecs.bytag[tag]
+	codecs.RUnlock()
+
+	if !ok {
+		return nil, NoSuchCodecError("tag:", tag)
+	}
+	v, e := c.Decode(b)
+	if e != nil {
+		return nil, DecodeError(tag).WithCause(e)
+	}
+	return v, nil
+}
+
+func typeName(typ reflect.Type) string {
+	if typ == nil {
+		return "<nil>"
+	}
+	return typ.String()
+}
+
+// ----------------------------------------------------------------------------
+// JSON codec
+// ----------------------------------------------------------------------------
+
+type jsonCodec struct {
+	typ reflect.Type
+}
+
+// JSON returns a codec that uses encoding/json for values of the dynamic
+// type of sample.
+func JSON(sample interface{}) Codec {
+	return jsonCodec{reflect.TypeOf(sample)}
+}
+
+func (c jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (c jsonCodec) Decode(b []byte) (interface{}, error) {
+	pv := reflect.New(c.typ)
+	if e := json.Unmarshal(b, pv.Interface()); e != nil {
+		return nil, e
+	}
+	return pv.Elem().Interface(), nil
+}
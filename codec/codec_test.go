@@ -0,0 +1,76 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package codec
+
+import (
+	"goerror"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuiltinRoundTrip(t *testing.T) {
+	values := []interface{}{
+		"Salaam!", true, []byte("bytes"), int(-1), int8(2), int16(3), int32(4),
+		int64(1 << 62), uint(5), uint8(6), uint16(7), uint32(8), uint64(1 << 63),
+		float32(1.5), 3.14159, time.Second,
+		time.Date(2016, 4, 8, 12, 0, 0, 0, time.UTC),
+	}
+	for _, v := range values {
+		tag, b, e := Encode(v)
+		if e != nil {
+			t.Fatalf("Encode(%v) - unexpected error: %s", v, e)
+		}
+		v0, e := Decode(tag, b)
+		if e != nil {
+			t.Fatalf("Decode(%s) - unexpected error: %s", tag, e)
+		}
+		if !reflect.DeepEqual(v, v0) {
+			t.Fatalf("round trip - expected:%#v got:%#v", v, v0)
+		}
+	}
+}
+
+type point struct {
+	X, Y int
+}
+
+func TestRegister(t *testing.T) {
+	if e := Register("codec.point", point{}, JSON(point{})); e != nil {
+		t.Fatalf("Register - unexpected error: %s", e)
+	}
+	if e := Register("codec.point", 0, JSON(0)); e == nil || !goerror.TypeOf(e).Is(AlreadyRegisteredError) {
+		t.Fatalf("Register(dup tag) - expected error: %s got: %v", AlreadyRegisteredError(), e)
+	}
+	if e := Register("codec.point.2", point{}, JSON(point{})); e == nil || !goerror.TypeOf(e).Is(AlreadyRegisteredError) {
+		t.Fatalf("Register(dup type) - expected error: %s got: %v", AlreadyRegisteredError(), e)
+	}
+	if e := Register("", point{}, nil); e == nil || !goerror.TypeOf(e).Is(IllegalArgumentError) {
+		t.Fatalf("Register - expected error: %s got: %v", IllegalArgumentError(), e)
+	}
+
+	tag, b, e := Encode(point{1, 2})
+	if e != nil {
+		t.Fatalf("Encode - unexpected error: %s", e)
+	}
+	if v, e := Decode(tag, b); e != nil || v != (point{1, 2}) {
+		t.Fatalf("Decode - expected:%v got:%v (error: %v)", point{1, 2}, v, e)
+	}
+}
+
+func TestNoSuchCodec(t *testing.T) {
+	if _, _, e := Encode(&point{}); e == nil || !goerror.TypeOf(e).Is(NoSuchCodecError) {
+		t.Fatalf("Encode - expected error: %s got: %v", NoSuchCodecError(), e)
+	}
+	if _, _, e := Encode(nil); e == nil || !goerror.TypeOf(e).Is(NoSuchCodecError) {
+		t.Fatalf("Encode(nil) - expected error: %s got: %v", NoSuchCodecError(), e)
+	}
+	if _, e := Decode("no-such-tag", nil); e == nil || !goerror.TypeOf(e).Is(NoSuchCodecError) {
+		t.Fatalf("Decode - expected error: %s got: %v", NoSuchCodecError(), e)
+	}
+	if _, e := Decode("int", []byte("not a number")); e == nil || !goerror.TypeOf(e).Is(DecodeError) {
+		t.Fatalf("Decode - expected error: %s got: %v", DecodeError(), e)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual_test
+
+import (
+	"testing"
+
+	"github.com/alphazero/contextual"
+	"github.com/alphazero/contextual/contextualtest"
+)
+
+func childOf(t *testing.T, p contextual.Context) contextual.Context {
+	c, e := contextual.ChildContext(p)
+	if e != nil {
+		t.Fatalf("ChildContext: %s", e)
+	}
+	return c
+}
+
+func TestContextConformance(t *testing.T) {
+	contextualtest.RunConformance(t, func(t *testing.T, p contextual.Context) contextual.Context {
+		if p == nil {
+			return contextual.NewContext()
+		}
+		return childOf(t, p)
+	})
+}
+
+// foreign is a Context implementation outside of package contextual.
+type foreign struct {
+	contextual.Context
+}
+
+// in-memory children of a foreign root context.
+func TestMixedHierarchyConformance(t *testing.T) {
+	contextualtest.RunConformance(t, func(t *testing.T, p contextual.Context) contextual.Context {
+		if p == nil {
+			return foreign{contextual.NewContext()}
+		}
+		return childOf(t, p)
+	})
+}
@@ -4,4 +4,267 @@
 
 package contextual
 
-type container struct{}
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// a container is a component that contains components. Each contained
+// component is an entry of the container.
+type container struct {
+	component
+	sync.Mutex
+	entries map[string]*entry
+	order   []string // entry names, in order of addition
+	seq     int      // for generated names
+
+	stopTimeout time.Duration // per component; 0 for none
+
+	added func(*entry) error // prepares the context of added entries, if set
+
+	healthInterval time.Duration // of periodic health checks; 0 for none
+	health         *HealthReport // latest periodic report
+	healthStop     chan struct{} // closed to stop periodic health checks
+
+	reconfigurationError func(name string, err error) // if set
+
+	events      sync.Mutex // guards subscribers
+	subscribers []*subscriber
+
+	bus *bus // shared by the entries
+}
+
+// ContainerOption configures a container.
+type ContainerOption func(*container)
+
+// StopTimeout sets the time that a component is given to stop, after which
+// the container stops waiting on it and reports a LifecycleError, which is
+// also emitted as an EventFailed. The component is left to stop, and the
+// outcome is emitted once it does (see Subscribe). The default is to wait
+// indefinitely.
+func StopTimeout(d time.Duration) ContainerOption {
+	return func(c *container) { c.stopTimeout = d }
+}
+
+// an entry of a container
+type entry struct {
+	name      string
+	component Component
+	context   Context    // child context of the container's context
+	state     State      // guarded by the container lock
+	lifecycle sync.Mutex // serializes state transitions
+	ports     map[string]*port
+	published []string // names published in the container's context
+	starts    int      // number of times started; guarded by the container lock
+
+	unwatch       func() // cancels the watch of the context, if watched
+	reconfiguring bool   // guarded by the container lock
+	forced        bool   // destroyed by force; guarded by the container lock
+	unsubscribe   func() // cancels the subscription to a nested container's events
+	bus           *entryBus
+}
+
+// NewContainer returns a new and empty container. The container's context
+// must be set before components can be added. The container's Bus is bound
+// in the context of each component (see LookupBus).
+func NewContainer(opts ...ContainerOption) Container {
+	c := &container{
+		entries: make(map[string]*entry),
+		bus:     &bus{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *container) SetContext(ctx Context) {
+	c.Lock()
+	defer c.Unlock()
+	c.context = ctx
+}
+
+func (c *container) Add(comp Component) error {
+	var name string
+	if named, ok := comp.(Named); ok {
+		name = named.Name()
+	} else {
+		c.Lock()
+		c.seq++
+		name = fmt.Sprintf("component-%d", c.seq)
+		c.Unlock()
+	}
+	return c.AddNamed(name, comp)
+}
+
+func (c *container) AddNamed(name string, comp Component) error {
+	return c.addWith(name, comp, nil)
+}
+
+// adds the component per AddNamed. If set, prepare populates the context of
+// the component before it is set on the component, and before the component
+// is registered (and so watched); the container is not locked.
+func (c *container) addWith(name string, comp Component, prepare func(ctx Context)) error {
+	if err := c.addNamed(name, comp, prepare); err != nil {
+		return err
+	}
+	c.emit(Event{Kind: EventAdded, Path: "/" + name, Component: comp, From: StateNew, To: StateNew})
+	return nil
+}
+
+func (c *container) addNamed(name string, comp Component, prepare func(ctx Context)) error {
+	if name == "" {
+		return NilNameError()
+	}
+	if comp == nil {
+		return IllegalArgumentError("component is nil")
+	}
+	if comp == Component(c) {
+		return IllegalArgumentError("container can not contain itself")
+	}
+	if !reflect.TypeOf(comp).Comparable() {
+		return IllegalArgumentError(fmt.Sprintf("component is %T; expected a comparable (e.g. pointer) type", comp))
+	}
+
+	c.Lock()
+	parent := c.context
+	err := c.available(name, comp)
+	c.Unlock()
+	if parent == nil {
+		return IllegalStateError("container context is not set")
+	}
+	if err != nil {
+		return err
+	}
+
+	// the component is prepared, and its context set, with c unlocked: the
+	// component may well call back into the container.
+	ctx, err := ChildContext(parent)
+	if err != nil {
+		return err
+	}
+	e := &entry{name: name, component: comp, context: ctx}
+	e.bus = &entryBus{bus: c.bus, subscriptions: make(map[*subscription]struct{})}
+	err = ctx.Bind(BusBinding, e.bus)
+	if err == nil {
+		err = declarePorts(e)
+	}
+	if err == nil && c.added != nil {
+		err = c.added(e)
+	}
+	if err != nil {
+		closeContext(ctx)
+		return err
+	}
+	if prepare != nil {
+		prepare(ctx)
+	}
+	comp.SetContext(ctx)
+
+	// the name, or the component, may have been added meanwhile
+	c.Lock()
+	if err := c.available(name, comp); err != nil {
+		c.Unlock()
+		comp.SetContext(nil)
+		closeContext(ctx)
+		return err
+	}
+	c.entries[name] = e
+	c.order = append(c.order, name)
+	c.watch(e)
+	if nested := asContainer(comp); nested != nil {
+		e.unsubscribe = nested.Subscribe(func(ev Event) {
+			ev.Path = "/" + e.name + ev.Path
+			c.emit(ev)
+		})
+	}
+	c.Unlock()
+	return nil
+}
+
+// returns an error if the name or the component is in use.
+// REVU: c must be locked.
+func (c *container) available(name string, comp Component) error {
+	if _, ok := c.entries[name]; ok {
+		return AlreadyBoundError(name)
+	}
+	if e := c.find(comp); e != nil {
+		return AlreadyBoundError(fmt.Sprintf("component is added as %s", e.name))
+	}
+	return nil
+}
+
+// closes the context, if closeable.
+func closeContext(ctx Context) {
+	if closer, ok := ctx.(interface{ Close() error }); ok {
+		closer.Close()
+	}
+}
+
+func (c *container) Remove(comp Component) error {
+	if comp == nil {
+		return IllegalArgumentError("component is nil")
+	}
+
+	c.Lock()
+	e := c.find(comp)
+	if e == nil {
+		c.Unlock()
+		return NoSuchBindingError("component is not in the container")
+	}
+	if e.state == StateStarted {
+		c.Unlock()
+		return IllegalStateError(fmt.Sprintf("%s: component is started", e.name))
+	}
+	state := e.state
+	c.remove(e)
+	c.Unlock()
+	detach(e)
+	c.emit(Event{Kind: EventRemoved, Path: "/" + e.name, Component: comp, From: state, To: state})
+	return nil
+}
+
+// removes the entry, unwires its ports, and cancels its bus subscriptions.
+// The entry is to be detached once c is unlocked (see detach).
+// REVU: c must be locked.
+func (c *container) remove(e *entry) {
+	if e.unwatch != nil {
+		e.unwatch()
+	}
+	if e.unsubscribe != nil {
+		e.unsubscribe()
+	}
+	unwirePorts(e)
+	e.bus.cancelAll()
+	delete(c.entries, e.name)
+	for i, name := range c.order {
+		if name == e.name {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// detaches the component of the removed entry, and closes its context.
+// REVU: the container must not be locked.
+func detach(e *entry) {
+	e.component.SetContext(nil)
+	closeContext(e.context)
+}
+
+// returns the entry of the component, or nil. Components of types that are
+// not comparable, for which == panics, are never added, and so not found.
+// REVU: c must be locked.
+func (c *container) find(comp Component) *entry {
+	if !reflect.TypeOf(comp).Comparable() {
+		return nil
+	}
+	for _, e := range c.entries {
+		if e.component == comp {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"goerror"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// testing: contextual.container
+// ============================================================================
+
+// helper - a named component
+type testComponent struct {
+	name    string
+	context Context
+}
+
+func (c *testComponent) Name() string           { return c.name }
+func (c *testComponent) SetContext(ctx Context) { c.context = ctx }
+
+// helper
+func newTestContainer(t *testing.T) (Container, Context) {
+	ctx := NewContext()
+	c := NewContainer()
+	c.SetContext(ctx)
+	return c, ctx
+}
+
+// helper
+func assertError(t *testing.T, op string, e error, category func(...string) *goerror.Error) {
+	if !IsError(e, category) {
+		t.Fatalf("%s - expected error: %s got: %v", op, category(), e)
+	}
+}
+
+func TestContainerAdd(t *testing.T) {
+	c, ctx := newTestContainer(t)
+	ctx.Bind("container.name", "root")
+
+	comp := &testComponent{name: "db"}
+	if e := c.Add(comp); e != nil {
+		t.Fatalf("Add - unexpected error: %s", e)
+	}
+	if comp.context == nil {
+		t.Fatalf("Add - component context is not set")
+	}
+	if comp.context == ctx || comp.context.Depth() != ctx.Depth()+1 {
+		t.Fatalf("Add - component context is not a child of the container's context")
+	}
+	if v, _ := comp.context.Lookup("container.name"); v != "root" {
+		t.Fatalf("Lookup via component context - expected:%v got:%v", "root", v)
+	}
+
+	// components get distinct contexts
+	other := &testComponent{name: "cache"}
+	c.Add(other)
+	comp.context.Bind("local", 1)
+	if v, _ := other.context.Lookup("local"); v != nil {
+		t.Fatalf("sibling component context binding is visible")
+	}
+
+	// unnamed components
+	unnamed := NewComponent()
+	if e := c.Add(unnamed); e != nil {
+		t.Fatalf("Add(unnamed) - unexpected error: %s", e)
+	}
+	if e := c.AddNamed("explicit", NewComponent()); e != nil {
+		t.Fatalf("AddNamed - unexpected error: %s", e)
+	}
+}
+
+func TestContainerAddErrors(t *testing.T) {
+	c := NewContainer()
+	assertError(t, "Add(no context)", c.Add(&testComponent{name: "a"}), IllegalStateError)
+
+	c.SetContext(NewContext())
+	comp := &testComponent{name: "a"}
+	c.Add(comp)
+	assertError(t, "Add(dup name)", c.Add(&testComponent{name: "a"}), AlreadyBoundError)
+	assertError(t, "AddNamed(dup component)", c.AddNamed("b", comp), AlreadyBoundError)
+	assertError(t, "Add(nil)", c.Add(nil), IllegalArgumentError)
+	assertError(t, "Add(self)", c.Add(c), IllegalArgumentError)
+	assertError(t, "AddNamed(\"\")", c.AddNamed("", NewComponent()), NilNameError)
+}
+
+func TestContainerRemove(t *testing.T) {
+	c, _ := newTestContainer(t)
+	comp := &testComponent{name: "db"}
+	c.Add(comp)
+	ctx := comp.context
+
+	if e := c.Remove(comp); e != nil {
+		t.Fatalf("Remove - unexpected error: %s", e)
+	}
+	if comp.context != nil {
+		t.Fatalf("Remove - component context is not reset")
+	}
+	assertError(t, "Bind(removed component context)", ctx.Bind("a", 1), IllegalStateError)
+	assertError(t, "Remove(removed)", c.Remove(comp), NoSuchBindingError)
+	assertError(t, "Remove(nil)", c.Remove(nil), IllegalArgumentError)
+
+	// name is available after removal
+	if e := c.Add(&testComponent{name: "db"}); e != nil {
+		t.Fatalf("Add - unexpected error: %s", e)
+	}
+}
+
+// helper - a component of a type that is not comparable
+type valueComponent struct {
+	tags []string
+}
+
+func (c valueComponent) SetContext(ctx Context) {}
+
+func TestContainerValueComponents(t *testing.T) {
+	c, _ := newTestContainer(t)
+	comp := valueComponent{tags: []string{"a"}}
+	assertError(t, "AddNamed(not comparable)", c.AddNamed("a", comp), IllegalArgumentError)
+	assertError(t, "Remove(not comparable)", c.Remove(comp), NoSuchBindingError)
+}
+
+// helper - a component that calls back into its container when its context
+// is set
+type callbackComponent struct {
+	container Container
+	seen      []int
+}
+
+func (c *callbackComponent) SetContext(ctx Context) {
+	c.seen = append(c.seen, len(c.container.Components()))
+}
+
+func TestContainerCallbacks(t *testing.T) {
+	c, _ := newTestContainer(t)
+	comp := &callbackComponent{container: c}
+	done := make(chan struct{})
+	go func() {
+		c.AddNamed("a", comp)
+		c.Remove(comp)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Add - deadlock on callback")
+	}
+	if !reflect.DeepEqual(comp.seen, []int{0, 0}) {
+		t.Fatalf("SetContext - unexpected components: %v", comp.seen)
+	}
+}
+
+func TestNestedContainers(t *testing.T) {
+	root, ctx := newTestContainer(t)
+	ctx.Bind("env", "test")
+
+	nested := NewContainer()
+	if e := root.AddNamed("frontend", nested); e != nil {
+		t.Fatalf("AddNamed - unexpected error: %s", e)
+	}
+	comp := &testComponent{name: "http"}
+	if e := nested.Add(comp); e != nil {
+		t.Fatalf("Add - unexpected error: %s", e)
+	}
+	if d := comp.context.Depth(); d != 2 {
+		t.Fatalf("Depth - expected:2 got:%d", d)
+	}
+	if v, _ := comp.context.Lookup("env"); v != "test" {
+		t.Fatalf("Lookup - expected:%v got:%v", "test", v)
+	}
+}
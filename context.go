@@ -6,31 +6,85 @@ package contextual
 
 import (
 	"fmt"
+	"sync"
+	"time"
 )
 
+// context is the in-memory Context. The parent of a context may be any
+// Context implementation. Contexts are safe for concurrent use; the lock of
+// a context is not held while its parent is accessed.
 type context struct {
-	parent   *context
+	parent Context
+	sync.RWMutex
 	bindings map[string]interface{}
+	closed   bool
+
+	watchers []*watcher
+	unwatch  func() // cancels the watch of the parent, if watched
+
+	clock     Clock
+	deadlines map[string]time.Time // of expiring bindings
+	wheel     wheel
+	onExpire  func(name string, value interface{})
+	meta      map[string]*bindingMeta
+	callers   bool // record the callers of binds
+	policy    policy
+	schema    *Schema // enforced on bind, if set
+	shadowing ShadowingPolicy
+	warn      func(ctx Context, name string, shadowed interface{})
+	masks     map[string]bool // names of the parent hidden from the context
+}
+
+type watcher struct {
+	fn func(changes []Change)
 }
 
 func newContext() *context {
-	return &context{nil, make(map[string]interface{})}
+	c := &context{bindings: make(map[string]interface{}), clock: SystemClock}
+	c.wheel.tick = 100 * time.Millisecond
+	return c
 }
 
-// NewContext makes and initializes a new root context
-func NewContext() *context {
-	return newContext()
+// NewContext makes and initializes a new root context, configured by the
+// options (see ContextOption).
+func NewContext(opts ...ContextOption) Context {
+	c := newContext()
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-// REVU: hmm .. c.newChild() or this?  (concern is security)
-func ChildContext(p *context) (c *context, e error) {
+// ChildContext makes and initializes a new (in-memory) child context of
+// the parent context p. The parent may be any Context implementation.
+// In-memory contexts are Watchable, Expiring, Describable, FinalBinders and
+// Maskable.
+//
+// Errors:
+//
+//  NilParentError <= p is nil
+//  IllegalStateError <= the child is deeper than the MaxDepth of its policy
+func ChildContext(p Context, opts ...ContextOption) (Context, error) {
 	if p == nil {
 		return nil, NilParentError()
 	}
 
-	c = newContext()
+	c := newContext()
+	if parent, ok := p.(*context); ok {
+		parent.RLock()
+		c.clock, c.policy, c.schema = parent.clock, parent.policy, parent.schema
+		c.shadowing, c.warn = parent.shadowing, parent.warn
+		c.callers = parent.callers
+		parent.RUnlock()
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if max := c.policy.maxDepth; max > 0 && p.Depth() >= max {
+		return nil, IllegalStateError(fmt.Sprintf("depth is limited to %d", max))
+	}
 	c.parent = p
-	return
+	return c, nil
 }
 
 func (c *context) IsRoot() bool {
@@ -41,9 +95,18 @@ func (c *context) IsRoot() bool {
 // is contextual/relative from the perspective of a child context. (A sibling
 // context may get distinct results.)
 func (c *context) IsEmpty() bool {
-	if len(c.bindings) > 0 {
+	c.RLock()
+	closed, n, masks := c.closed, c.size(), len(c.masks)
+	c.RUnlock()
+	if closed {
+		return true
+	}
+	if n > 0 {
 		return false
 	}
+	if masks > 0 {
+		return c.Size() == 0
+	}
 	if c.parent != nil {
 		return c.parent.IsEmpty()
 	}
@@ -51,11 +114,17 @@ func (c *context) IsEmpty() bool {
 }
 
 func (c *context) Size() int {
+	c.RLock()
+	closed, n := c.closed, c.size()
+	c.RUnlock()
+	if closed {
+		return 0
+	}
 	var c0 int
 	if c.parent != nil {
-		c0 = c.parent.Size()
+		c0 = c.parent.Size() - c.masked()
 	}
-	return len(c.bindings) + c0
+	return n + c0
 }
 
 // Returns a non-negative value of the nesting order (depth) of the context.
@@ -74,20 +143,28 @@ func (c *context) Depth() int {
 //
 // errors:
 //
-//  IllegalArgumentError if name is nil
+//  NilNameError <= zero-value names are not allowed
+//  NoSuchBindingError <= the name is masked (see Maskable)
+//  IllegalStateError <= the context is closed
 func (c *context) Lookup(name string) (value interface{}, e error) {
-	fmt.Printf("debug - Lookup(%s)\n", name)
-	if name == "" {
-		return nil, IllegalArgumentError("name is nil")
+	key, e := c.checkName(name, false)
+	if e != nil {
+		return nil, e
+	}
+	c.RLock()
+	closed, masked := c.closed, c.masks[key]
+	value, ok := c.get(key)
+	c.RUnlock()
+	if closed {
+		return nil, IllegalStateError("context is closed")
+	}
+	if !ok && masked {
+		return nil, NoSuchBindingError(fmt.Sprintf("%s is masked", key))
 	}
 
-	if value = c.bindings[name]; value == nil {
-		fmt.Printf("debug - Lookup(%s) = %v\n", name, value)
-		for n, v := range c.bindings {
-			fmt.Printf("\tdebug [%s] => %v\n", n, v)
-		}
+	if !ok {
 		if c.parent != nil {
-			return c.parent.Lookup(name)
+			return c.parent.Lookup(key)
 		}
 	}
 	return
@@ -101,20 +178,33 @@ func (c *context) Lookup(name string) (value interface{}, e error) {
 //
 // errors:
 //
-//  IllegalArgumentError if name is nil
-//  IllegalArgumentError if n < 0
+//  NilNameError <= zero-value names are not allowed
+//  NegativeNArgError <= n is negative
+//  NoSuchBindingError <= the name is masked (see Maskable)
+//  IllegalStateError <= the context is closed
 func (c *context) LookupN(name string, n int) (value interface{}, e error) {
-	if name == "" {
-		return nil, IllegalArgumentError("name is nil")
+	key, e := c.checkName(name, false)
+	if e != nil {
+		return nil, e
 	}
 	if n < 0 {
-		return nil, IllegalArgumentError("n < 0")
+		return nil, NegativeNArgError()
+	}
+	c.RLock()
+	closed, masked := c.closed, c.masks[key]
+	value, ok := c.get(key)
+	c.RUnlock()
+	if closed {
+		return nil, IllegalStateError("context is closed")
+	}
+	if !ok && masked {
+		return nil, NoSuchBindingError(fmt.Sprintf("%s is masked", key))
 	}
 
-	if value = c.bindings[name]; value == nil {
+	if !ok {
 		n--
 		if c.parent != nil && n >= 0 {
-			return c.parent.LookupN(name, n)
+			return c.parent.LookupN(key, n)
 		}
 	}
 	return
@@ -125,22 +215,69 @@ func (c *context) LookupN(name string, n int) (value interface{}, e error) {
 //
 // errors:
 //
-//  IllegalArgumentError if name is nil
-//  IllegalArgumentError if value is nil
-//  AlreadyBounderror <= a value is already bound to the name
+//  NilNameError <= zero-value names are not allowed
+//  NilValueError <= nil values are not allowed
+//  AlreadyBoundError <= a value is already bound to the name
+//  IllegalArgumentError <= the name is not valid per the policy
+//  IllegalStateError <= the context has MaxBindings bindings
 func (c *context) Bind(name string, value interface{}) error {
-	if name == "" {
-		return IllegalArgumentError("name is nil")
+	return c.bindWith(name, value, nil)
+}
+
+// binds the value, calls then (if set) with c locked, and notifies the
+// watchers.
+func (c *context) bindWith(name string, value interface{}, then func(name string)) error {
+	name, e := c.checkName(name, true)
+	if e != nil {
+		return e
+	}
+	if e := c.checkValue(value); e != nil {
+		return e
 	}
-	if value == nil {
-		return IllegalArgumentError("value is nil")
+	// REVU: best-effort; a final binding of an ancestor that lands before
+	// the lock is taken is not seen (see FinalBinder).
+	warn, e := c.mayShadow(name)
+	if e != nil {
+		return e
 	}
 
-	if v := c.bindings[name]; v != nil {
+	c.Lock()
+	old, _ := c.get(name)
+	e = c.bind(name, value)
+	if e == nil && then != nil {
+		then(name)
+	}
+	c.Unlock()
+	if e == nil {
+		c.notify([]Change{{c, name, old, value, ChangeBinding}})
+		if warn != nil {
+			warn()
+		}
+	}
+	return e
+}
+
+// REVU: c must be locked.
+func (c *context) bind(name string, value interface{}) error {
+	if c.closed {
+		return IllegalStateError("context is closed")
+	}
+	v, bound := c.get(name)
+	if bound && !c.policy.overwrite {
 		return AlreadyBoundError(fmt.Sprintf("%s => %v", name, v))
 	}
+	if !reserved(name) {
+		if max := c.policy.maxBindings; max > 0 && !bound && c.size()-c.reservedBindings() >= max {
+			return IllegalStateError(fmt.Sprintf("context is limited to %d bindings", max))
+		}
+		if e := c.schema.check(name, value); e != nil {
+			return e
+		}
+	}
 
 	c.bindings[name] = value
+	delete(c.deadlines, name)
+	c.bound(name)
 	return nil
 }
 
@@ -152,18 +289,35 @@ func (c *context) Bind(name string, value interface{}) error {
 //
 // errors:
 //
-//  IllegalArgumentError if name is nil
-//  NilNameerror <= zero-value names are not allowed
-//  NoSuchBindingerror <= no values are bound to the name
+//  NilNameError <= zero-value names are not allowed
+//  NoSuchBindingError <= no values are bound to the name
 func (c *context) Unbind(name string) (value interface{}, e error) {
-	if name == "" {
-		return nil, IllegalArgumentError("name is nil")
+	if name, e = c.checkName(name, false); e != nil {
+		return nil, e
+	}
+
+	c.Lock()
+	value, e = c.unbind(name)
+	c.Unlock()
+	if e == nil {
+		c.notify([]Change{{c, name, value, nil, ChangeBinding}})
 	}
-	if value = c.bindings[name]; value == nil {
+	return
+}
+
+// REVU: c must be locked.
+func (c *context) unbind(name string) (value interface{}, e error) {
+	if c.closed {
+		return nil, IllegalStateError("context is closed")
+	}
+	value, ok := c.get(name)
+	if !ok {
 		return nil, NoSuchBindingError(name)
 	}
 
 	delete(c.bindings, name)
+	delete(c.deadlines, name)
+	delete(c.meta, name)
 	return
 }
 
@@ -175,14 +329,116 @@ func (c *context) Unbind(name string) (value interface{}, e error) {
 // errors:
 //
 //  NoSuchBinding <= no values were bound to the name
-//  NilNameerror <= zero-value names are not allowed
-//  NilValueerror <= nil values are not allowed
+//  NilNameError <= zero-value names are not allowed
+//  NilValueError <= nil values are not allowed
+//  IllegalArgumentError <= the name is not valid per the policy
 func (c *context) Rebind(name string, value interface{}) (unboundValue interface{}, e error) {
-
-	if unboundValue, e = c.Unbind(name); e != nil {
-		return
+	// check value before the unbind so a faulted rebind leaves the binding as is
+	if name, e = c.checkRebind(name, value); e != nil {
+		return nil, e
 	}
-	e = c.Bind(name, value)
 
+	c.Lock()
+	unboundValue, e = c.rebind(name, value)
+	c.Unlock()
+	if e == nil {
+		c.notify([]Change{{c, name, unboundValue, value, ChangeBinding}})
+	}
 	return
 }
+
+// Close clears the bindings of the context. A closed context is empty and
+// all (other) ops of the context return IllegalStateError.
+//
+// errors:
+//
+//  IllegalStateError <= context is already closed
+func (c *context) Close() error {
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return IllegalStateError("context is closed")
+	}
+	c.closed = true
+	c.bindings = make(map[string]interface{})
+	c.deadlines = nil
+	c.meta = nil
+	c.masks = nil
+	if c.wheel.stop != nil {
+		c.wheel.stop()
+		c.wheel.stop = nil
+	}
+	c.watchers = nil
+	unwatch := c.unwatch
+	c.unwatch = nil
+	c.Unlock()
+	if unwatch != nil {
+		unwatch()
+	}
+	return nil
+}
+
+// Watch registers the watcher of the changes of the bindings visible from
+// the context. The context watches its parent, if Watchable, while it has
+// watchers.
+func (c *context) Watch(fn func(changes []Change)) (cancel func()) {
+	w := &watcher{fn}
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return func() {}
+	}
+	c.watchers = append(c.watchers, w)
+	if p, ok := c.parent.(Watchable); ok && c.unwatch == nil {
+		c.unwatch = p.Watch(c.forward)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { c.cancel(w) })
+	}
+}
+
+func (c *context) cancel(w *watcher) {
+	c.Lock()
+	for i, w0 := range c.watchers {
+		if w0 == w {
+			c.watchers = append(c.watchers[:i:i], c.watchers[i+1:]...)
+			break
+		}
+	}
+	var unwatch func()
+	if len(c.watchers) == 0 {
+		unwatch, c.unwatch = c.unwatch, nil
+	}
+	c.Unlock()
+	if unwatch != nil {
+		unwatch()
+	}
+}
+
+// notifies the watchers of the changes. c must not be locked.
+func (c *context) notify(changes []Change) {
+	c.RLock()
+	watchers := c.watchers
+	c.RUnlock()
+	for _, w := range watchers {
+		w.fn(changes)
+	}
+}
+
+// notifies the watchers of the changes of the parent that are not shadowed
+// or masked by the context.
+func (c *context) forward(changes []Change) {
+	c.RLock()
+	var visible []Change
+	for _, ch := range changes {
+		if _, ok := c.get(ch.Name); !ok && !c.masks[ch.Name] {
+			visible = append(visible, ch)
+		}
+	}
+	c.RUnlock()
+	if len(visible) > 0 {
+		c.notify(visible)
+	}
+}
@@ -180,7 +180,7 @@ func TestContextSpecdError(t *testing.T) {
 	// test specified errors for Lookup
 	// IllegalArgumentError - ""/nil name
 	if _, e := ctx.Lookup(""); e == nil || !goerror.TypeOf(e).Is(IllegalArgumentError) {
-		t.Fatalf("Lookup(nil) expected error: %s", IllegalArgumentError)
+		t.Fatalf("Lookup(nil) expected error: %s", IllegalArgumentError())
 	}
 	v, e := ctx.Lookup("no-such-binding")
 	if e != nil {
@@ -196,10 +196,10 @@ func TestContextSpecdError(t *testing.T) {
 	// IllegalArgumentError - ""/nil name
 	// IllegalArgumentError - n < 0
 	if _, e := ctx.LookupN("", 0); e == nil || !goerror.TypeOf(e).Is(IllegalArgumentError) {
-		t.Fatalf("LookupN(\"\", 0) expected error: %s", IllegalArgumentError)
+		t.Fatalf("LookupN(\"\", 0) expected error: %s", IllegalArgumentError())
 	}
 	if v, e = ctx.LookupN("no-such-binding", -1); e == nil || !goerror.TypeOf(e).Is(IllegalArgumentError) {
-		t.Fatalf("LookupN(\"\", 0) expected error: %s", IllegalArgumentError)
+		t.Fatalf("LookupN(\"\", 0) expected error: %s", IllegalArgumentError())
 	}
 
 	// Bind()
@@ -209,10 +209,10 @@ func TestContextSpecdError(t *testing.T) {
 	//  NilValueError <= nil values are not allowed
 	//  AlreadyBoundError <= a value is already bound to the name
 	if e := ctx.Bind("", "some value"); e == nil || !goerror.TypeOf(e).Is(IllegalArgumentError) {
-		t.Fatalf("Bind(\"\") expected error: %s", IllegalArgumentError)
+		t.Fatalf("Bind(\"\") expected error: %s", IllegalArgumentError())
 	}
 	if e := ctx.Bind("some key", nil); e == nil || !goerror.TypeOf(e).Is(IllegalArgumentError) {
-		t.Fatalf("Bind(\"\") expected error: %s", IllegalArgumentError)
+		t.Fatalf("Bind(\"\") expected error: %s", IllegalArgumentError())
 	}
 
 	// Unbind()
@@ -222,14 +222,14 @@ func TestContextSpecdError(t *testing.T) {
 	//  NoSuchBindingError <= no values are bound to the name
 	wat, e := ctx.Unbind("")
 	if e == nil {
-		t.Fatalf("Unbind(\"\") expected error: %s", NilNameError)
+		t.Fatalf("Unbind(\"\") expected error: %s", NilNameError())
 	}
 	if wat != nil {
 		t.Fatalf("Unexpected value on faulted return: %s", wat)
 	}
 	wat, e = ctx.Unbind("some key")
 	if e == nil {
-		t.Fatalf("Unbind(\"\") expected error: %s", NoSuchBindingError)
+		t.Fatalf("Unbind(\"\") expected error: %s", NoSuchBindingError())
 	}
 	if wat != nil {
 		t.Fatalf("Unexpected value on faulted return: %s", wat)
@@ -243,20 +243,20 @@ func TestContextSpecdError(t *testing.T) {
 	//  NilValueError <= nil values are not allowed
 	wat, e = ctx.Rebind("", "some value")
 	if e == nil {
-		t.Fatalf("Rebind(\"\", v) expected error: %s", NilNameError)
+		t.Fatalf("Rebind(\"\", v) expected error: %s", NilNameError())
 	}
 	if wat != nil {
 		t.Fatalf("Unexpected value on faulted return: %s", wat)
 	}
 	wat, e = ctx.Rebind("some key", "some value")
 	if e == nil {
-		t.Fatalf("Rebind(\"\", v) expected error: %s", NoSuchBindingError)
+		t.Fatalf("Rebind(\"\", v) expected error: %s", NoSuchBindingError())
 	}
 	if wat != nil {
 		t.Fatalf("Unexpected value on faulted return: %s", wat)
 	}
 	if wat, e = ctx.Rebind("doesn't matter", nil); e == nil {
-		t.Fatalf("Rebind (nil) expected error: %s", NilValueError)
+		t.Fatalf("Rebind (nil) expected error: %s", NilValueError())
 	}
 }
 
@@ -291,13 +291,13 @@ func TestSingleContext(t *testing.T) {
 	// IsEmpty()
 
 	if b := ctx.IsEmpty(); b {
-		t.Fatalf("IsEmpty() - expected:%s got:%s", false, b)
+		t.Fatalf("IsEmpty() - expected:%t got:%t", false, b)
 	}
 
 	// Size()
 
 	if n := ctx.Size(); n != len(names) {
-		t.Fatalf("Size() - expected:%s got:%s", len(names), n)
+		t.Fatalf("Size() - expected:%d got:%d", len(names), n)
 	}
 
 	// Unbind()
@@ -403,13 +403,13 @@ func TestContextHierarchy(t *testing.T) {
 			t.Fatalf("Unexpected error: %s", e)
 		}
 		if v != values[0] {
-			t.Fatalf("for children[%d] - Lookup(%s) - expected:%v got:%v", i, names[0], v)
+			t.Fatalf("for children[%d] - Lookup(%s) - expected:%v got:%v", i, names[0], values[0], v)
 		}
 		if ctx.IsEmpty() {
-			t.Fatalf("for children[%d] - IsEmpty(%s) - expected:false", i)
+			t.Fatalf("for children[%d] - IsEmpty() - expected:false", i)
 		}
 		if s := ctx.Size(); s != 1 {
-			t.Fatalf("for children[%d] - Size(%s) - expected:%d, got:%d", i, 1, s)
+			t.Fatalf("for children[%d] - Size() - expected:%d, got:%d", i, 1, s)
 		}
 	}
 
@@ -427,12 +427,12 @@ func TestContextHierarchy(t *testing.T) {
 				t.Fatalf("Unexpected error: %s", e)
 			}
 			if v != values[0] {
-				t.Fatalf("l[%d] i[%d] n[%d]- Lookup(%s) - expected:%v got:%v", l, i, n, names[0], v)
+				t.Fatalf("l[%d] i[%d] n[%d]- Lookup(%s) - expected:%v got:%v", l, i, n, names[0], values[0], v)
 			}
 			// none should see
 			v, _ = ctx.LookupN(names[0], l)
 			if v != nil {
-				t.Fatalf("l[%d] i[%d] n[%d]- Lookup(%s) - expected:%v got:%v", l, i, n, nil, v)
+				t.Fatalf("l[%d] i[%d] n[%d]- Lookup(%s) - expected:%v got:%v", l, i, n, names[0], nil, v)
 			}
 		}
 	}
@@ -9,22 +9,87 @@ package contextual
 
 import (
 	"goerror"
+	"strings"
+	"time"
 )
 
+// Note that the argument errors, e.g. NilNameError, are sub-categories of
+// IllegalArgumentError by their message prefix, i.e.
+// goerror.TypeOf(e).Is(IllegalArgumentError) holds for a NilNameError.
 var (
 	/* - general errors - */
 	IllegalArgumentError = goerror.Define("illegal argument")
 	IllegalStateError    = goerror.Define("illegal state")
-	NilParentError       = goerror.Define("parent is nil")
-	NilNameError         = goerror.Define("name is nil/zero-value")
-	NegativeNArgError    = goerror.Define("hierchy walk steps 'n' is negative")
+	NilParentError       = goerror.Define("illegal argument - parent is nil")
+	NilNameError         = goerror.Define("illegal argument - name is nil/zero-value")
+	NegativeNArgError    = goerror.Define("illegal argument - hierchy walk steps 'n' is negative")
+
+	/* - component errors - */
+	LifecycleError = goerror.Define("lifecycle error")
+	ShutdownError  = goerror.Define("shutdown error")
+	ManifestError  = goerror.Define("manifest error")
+	InjectionError = goerror.Define("injection error")
+	SchemaError    = goerror.Define("schema error")
+
+	/* - dependency graph errors - */
+	DependencyError      = goerror.Define("dependency error")
+	DependencyCycleError = goerror.Define("dependency cycle")
+
+	/* - supervision errors - */
+	SupervisionError = goerror.Define("supervision error")
+
+	/* - reconfiguration errors - */
+	ReconfigurationError = goerror.Define("reconfiguration error")
+
+	/* - messaging errors - */
+	BusError = goerror.Define("bus error")
 
 	/* - binding op errors - */
-	NilValueError      = goerror.Define("nil values are not allowed")
+	NilValueError      = goerror.Define("illegal argument - nil values are not allowed")
 	AlreadyBoundError  = goerror.Define("already bound error")
 	NoSuchBindingError = goerror.Define("no such binding")
+
+	ShadowingForbiddenError = goerror.Define("shadowing forbidden")
 )
 
+// the sub-categories of error categories; see IsError.
+var subcategories = []struct {
+	sub, of func(...string) *goerror.Error
+}{
+	{DependencyCycleError, DependencyError},
+	{ShadowingForbiddenError, AlreadyBoundError},
+}
+
+// IsError returns true if the error is of the category, per goerror, or of
+// one of its sub-categories, e.g. a DependencyCycleError is a
+// DependencyError.
+func IsError(e error, category func(...string) *goerror.Error) bool {
+	if e == nil {
+		return false
+	}
+	if goerror.TypeOf(e).Is(category) {
+		return true
+	}
+	for _, c := range subcategories {
+		if c.of().Error() == category().Error() && IsError(e, c.sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorList is an error that aggregates errors, for operations that report
+// all errors at once. It is typically the cause of a categorical error.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	s := make([]string, len(l))
+	for i, e := range l {
+		s[i] = e.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
 // ----------------------------------------------------------------------------
 // Contextual API
 // ----------------------------------------------------------------------------
@@ -55,6 +120,7 @@ type Context interface {
 	// Errors:
 	//
 	//  NilNameError <= zero-value names are not allowed
+	//  NoSuchBindingError <= the name is masked (see Maskable)
 	Lookup(name string) (value interface{}, e error)
 
 	// LookupN is a constrained variant of Lookup.  (See Lookup() for general details)
@@ -66,6 +132,7 @@ type Context interface {
 	//
 	//  NilNameError <= zero-value names are not allowed
 	//  NegativeNArgError <= n is negative
+	//  NoSuchBindingError <= the name is masked within n steps (see Maskable)
 	LookupN(name string, n int) (interface{}, error)
 
 	// Bind will bind the given value to the name in the receiver.
@@ -75,6 +142,8 @@ type Context interface {
 	//  NilNameError <= zero-value names are not allowed
 	//  NilValueError <= nil values are not allowed
 	//  AlreadyBoundError <= a value is already bound to the name
+	//  ShadowingForbiddenError <= the binding may not shadow that of an
+	//   ancestor (see FinalBinder and WithShadowing)
 	Bind(name string, value interface{}) error
 
 	// Unbind will delete a value binding to the provided name.
@@ -100,21 +169,339 @@ type Context interface {
 	Rebind(name string, value interface{}) (unboundValue interface{}, e error)
 }
 
+// Change is a change of a binding: Old is nil for a Bind or an Unmask, and
+// New is nil for an Unbind, an expiry, or a Mask.
+type Change struct {
+	Context  Context // where the binding changed
+	Name     string
+	Old, New interface{}
+	Kind     ChangeKind
+}
+
+// ChangeKind is the cause of a Change.
+type ChangeKind int
+
+const (
+	// ChangeBinding is a Bind, Rebind, or Unbind.
+	ChangeBinding ChangeKind = iota
+	// ChangeExpired is the expiry of a binding (see Expiring).
+	ChangeExpired
+	// ChangeMasked is the Mask of a binding (see Maskable).
+	ChangeMasked
+	// ChangeUnmasked is the Unmask of a binding (see Maskable).
+	ChangeUnmasked
+)
+
+// Watchable contexts notify watchers of the changes of the bindings that
+// are visible from the context, i.e. of the bindings of the context, and of
+// those of its ancestors that are not shadowed by the context. Watchers are
+// called synchronously, after the change, by the goroutine that made it.
+type Watchable interface {
+	// Watch registers the watcher, and returns the func that cancels it.
+	Watch(watcher func(changes []Change)) (cancel func())
+}
+
+// Expiring contexts bind values that expire. An expired binding is no longer
+// visible, e.g. to Lookup, Size and IsEmpty, and is removed from the context
+// (and its watchers notified) in due course.
+type Expiring interface {
+	// BindTTL binds the value as Bind does, for the time to live.
+	//
+	// Errors:
+	//
+	//  IllegalArgumentError <= ttl is not positive
+	//  (and those of Bind)
+	BindTTL(name string, value interface{}, ttl time.Duration) error
+
+	// RebindTTL rebinds the value as Rebind does, for the time to live.
+	//
+	// Errors:
+	//
+	//  IllegalArgumentError <= ttl is not positive
+	//  (and those of Rebind)
+	RebindTTL(name string, value interface{}, ttl time.Duration) (unboundValue interface{}, e error)
+}
+
+// BindingInfo is the metadata of a binding.
+type BindingInfo struct {
+	Name        string
+	Value       interface{}
+	Context     Context   // where bound; nil if not known
+	Bound       time.Time // when bound
+	Rebound     time.Time // when last rebound; zero if not rebound
+	Expires     time.Time // zero if not expiring
+	Caller      string    // source location ("file:line") of the bind; see RecordCallers
+	Source      string    // label of the origin of the value, e.g. "env"
+	Final       bool      // see FinalBinder
+	Annotations map[string]string
+}
+
+// Describable contexts track the metadata of their bindings.
+type Describable interface {
+	// Describe returns the metadata of the binding visible from the context.
+	// Only the name and value of bindings of (ancestor) contexts that are not
+	// Describable are known.
+	//
+	// Errors:
+	//
+	//  NilNameError <= zero-value names are not allowed
+	//  NoSuchBindingError <= no value is bound to the name
+	Describe(name string) (BindingInfo, error)
+
+	// Bindings returns the metadata of the bindings visible from the
+	// context, by name. Bindings of ancestors that are not Describable are
+	// not included.
+	Bindings() []BindingInfo
+
+	// SetSource sets the source label of the binding of the receiver.
+	//
+	// Errors:
+	//
+	//  NilNameError <= zero-value names are not allowed
+	//  NoSuchBindingError <= no value is bound to the name in the receiver
+	SetSource(name, source string) error
+
+	// Annotate sets the annotation of the binding of the receiver; an empty
+	// value removes the annotation.
+	//
+	// Errors:
+	//
+	//  NilNameError <= zero-value names are not allowed
+	//  NoSuchBindingError <= no value is bound to the name in the receiver
+	Annotate(name, key, value string) error
+}
+
+// FinalBinder contexts bind final values, which the descendants of the
+// context may not shadow. Finality is only known to descendants through
+// ancestors that are FinalBinders.
+//
+// Finality is checked when a descendant binds the name, before it is
+// locked. Bindings of descendants that precede a final binding are not
+// affected by it, and a final binding that races with the binding of a
+// descendant may be ordered after it, i.e. the check is best-effort under
+// concurrent binds.
+type FinalBinder interface {
+	// BindFinal binds the value as Bind does, as a final binding.
+	//
+	// Errors:
+	//
+	//  (those of Bind)
+	BindFinal(name string, value interface{}) error
+
+	// IsFinal returns true if a binding of the name in the context, or in
+	// any of its ancestors, is final.
+	IsFinal(name string) bool
+}
+
+// Maskable contexts mask the bindings of their ancestors: a masked name is
+// not visible from the context and its descendants, i.e. Lookup of the name
+// fails with NoSuchBindingError, even though an ancestor binds it. A binding
+// of the name in the context itself (or a descendant) is not masked.
+type Maskable interface {
+	// Mask masks the bindings of the name of the ancestors of the context.
+	// Masking a masked name has no effect.
+	//
+	// Errors:
+	//
+	//  NilNameError <= zero-value names are not allowed
+	//  ShadowingForbiddenError <= the binding of an ancestor is final
+	Mask(name string) error
+
+	// Unmask removes the mask of the name.
+	//
+	// Errors:
+	//
+	//  NilNameError <= zero-value names are not allowed
+	//  NoSuchBindingError <= the name is not masked in the context
+	Unmask(name string) error
+
+	// IsMasked returns true if the name is masked in the context.
+	IsMasked(name string) bool
+}
+
+// Clock is the source of time of contexts (see WithClock).
+type Clock interface {
+	Now() time.Time
+
+	// AfterFunc calls f after the duration, unless stopped before then.
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
 // General baseline interface of a 'contextual' object
 type Contextual interface {
 	SetContext(ctx Context)
 }
 
 // A component is contextual
-// TOOD: ports {in, out}
+//
+// Components may declare typed ports (see Ported), which are wired by their
+// container.
 type Component interface {
 	Contextual
 }
 
+// Optional lifecycle interfaces of components. The lifecycle of components
+// is managed by their container, per the State machine of components.
+
+// Initializer components are initialized on transition to StateInitialized.
+// The tagged fields of struct components are injected before Init (see
+// Inject).
+type Initializer interface {
+	Init() error
+}
+
+// Starter components are started on transition to StateStarted.
+type Starter interface {
+	Start() error
+}
+
+// Stopper components are stopped on transition to StateStopped.
+type Stopper interface {
+	Stop() error
+}
+
+// Destroyer components are destroyed on transition to StateDestroyed.
+type Destroyer interface {
+	Destroy() error
+}
+
+// Lifecycle is the complete lifecycle interface.
+type Lifecycle interface {
+	Initializer
+	Starter
+	Stopper
+	Destroyer
+}
+
+// Dependent components declare the names of the bindings that they require,
+// and of those that they provide. Containers start components in dependency
+// order, and publish the provided bindings of a started component in the
+// container's context; the bindings are unbound when the component stops.
+type Dependent interface {
+	Requires() []string
+	Provides() []string
+}
+
+// Reconfigurable components are reconfigured, while started, when bindings
+// of their configuration change in the (Watchable) context of the
+// component. The container quiesces the component (see Quiescent), calls
+// Reconfigure with the changed names, and resumes it. If Reconfigure returns
+// an error, the changes are rolled back. Changes made while the component
+// is in a lifecycle transition do not reconfigure it.
+type Reconfigurable interface {
+	// Configuration returns the names of the bindings that configure the
+	// component.
+	Configuration() []string
+	Reconfigure(changed []string) error
+}
+
+// Quiescent components can suspend their work while they are reconfigured.
+type Quiescent interface {
+	Quiesce() error
+	Resume() error
+}
+
+// A named object. Containers register Named components under their name.
+type Named interface {
+	Name() string
+}
+
 // A component that is a containment context
+//
+// Each component of a container is given a child context of the container's
+// context, and is registered in the container under a unique name.
+//
+// A container manages the lifecycle of its components. The Lifecycle methods
+// of the container apply to all of its components, in order of addition for
+// Init, in dependency order for Start (see Dependent), and in reverse order
+// for Stop and Destroy.
 type Container interface {
 	Component
+	Lifecycle
 
+	// Add adds the component. If c is Named, it is registered under its name,
+	// otherwise under a name generated by the container.
+	// Errors: see AddNamed
 	Add(c Component) error
+
+	// AddNamed adds the component under the given name. The component's
+	// context is set to a new child context of the container's context.
+	// Components are identified by reference, and so must be of comparable
+	// types, e.g. pointers.
+	//
+	// Errors:
+	//
+	//  NilNameError <= zero-value names are not allowed
+	//  IllegalArgumentError <= c is nil, is the container, or is not comparable
+	//  IllegalStateError <= the container's context is not set
+	//  AlreadyBoundError <= name is in use, or c is already added
+	AddNamed(name string, c Component) error
+
+	// Remove removes the component. The component's context is set to nil,
+	// and the child context given to it on add is closed.
+	//
+	// Errors:
+	//
+	//  IllegalArgumentError <= c is nil
+	//  NoSuchBindingError <= c is not in the container
+	//  IllegalStateError <= c is started
 	Remove(c Component) error
+
+	// State returns the lifecycle state of the named component.
+	//
+	// Errors:
+	//
+	//  NoSuchBindingError <= no such component
+	State(name string) (State, error)
+
+	// Transition transitions the named component to the given state, calling
+	// the associated lifecycle method of the component, if any. The state of
+	// the component is unchanged if the lifecycle method returns an error.
+	//
+	// Errors:
+	//
+	//  NoSuchBindingError <= no such component
+	//  IllegalStateError <= transition is not valid
+	//  LifecycleError <= lifecycle method of the component failed
+	Transition(name string, to State) error
+
+	// Wire wires the output port of component 'from' to the input port of
+	// component 'to'. An output port may be wired to any number of input
+	// ports, and an input port to at most one output port. The element type
+	// of the output port must be assignable to that of the input port.
+	//
+	// Errors:
+	//
+	//  NoSuchBindingError <= no such component or port
+	//  IllegalArgumentError <= port direction or element type mismatch
+	//  AlreadyBoundError <= input port is already wired
+	//  IllegalStateError <= either component is started
+	Wire(from, out, to, in string) error
+
+	// Containers check the health of their components; see HealthChecker.
+	HealthChecker
+
+	// Health returns the report of the latest periodic health check of the
+	// container (see HealthInterval), or of an on-demand check if periodic
+	// checks are not enabled or have not yet run.
+	Health() HealthReport
+
+	// Components returns the components of the container, in order of
+	// addition.
+	Components() []ComponentInfo
+
+	// Lookup returns the component at the path. The path is the name of a
+	// component of the container, or a path of names across nested
+	// containers, e.g. "/frontend/http/router".
+	//
+	// Errors:
+	//
+	//  NoSuchBindingError <= no component at the path
+	Lookup(path string) (ComponentInfo, error)
+
+	// Subscribe registers the subscriber of the events of the container,
+	// including those of nested containers, and returns the func that cancels
+	// the subscription. Subscribers are called synchronously, in the order of
+	// the events of a component, by the goroutine that caused the event.
+	Subscribe(subscriber func(Event)) (cancel func())
 }
@@ -0,0 +1,77 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextualtest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeClock is a contextual.Clock whose time only moves when advanced, for
+// deterministic tests of expiring bindings.
+type FakeClock struct {
+	sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	f  func()
+}
+
+// NewFakeClock returns a clock set to the time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) (stop func() bool) {
+	c.Lock()
+	defer c.Unlock()
+	t := &fakeTimer{c.now.Add(d), f}
+	c.timers = append(c.timers, t)
+	return func() bool {
+		c.Lock()
+		defer c.Unlock()
+		for i, t0 := range c.timers {
+			if t0 == t {
+				c.timers = append(c.timers[:i], c.timers[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Advance moves the time of the clock forward by d, and calls the funcs of
+// the timers that are due, in order of their time, before it returns. The
+// time of the clock is the time of the timer while its func is called.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Lock()
+	to := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+		if len(c.timers) == 0 || c.timers[0].at.After(to) {
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		c.Unlock()
+		t.f()
+		c.Lock()
+	}
+	c.now = to
+	c.Unlock()
+}
@@ -0,0 +1,500 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+// Package contextualtest provides a conformance suite for implementations
+// of contextual.Context.
+//
+// Each documented behavior of the Context interface is checked by an
+// independent subtest, i.e. subtests do not depend on the order in which
+// they are run and may be run selectively with 'go test -run'.
+//
+// Typical usage, in the _test.go file of the implementing package:
+//
+//	func TestConformance(t *testing.T) {
+//	    contextualtest.RunConformance(t, func(t *testing.T, p contextual.Context) contextual.Context {
+//	        if p == nil {
+//	            return mypkg.NewContext()
+//	        }
+//	        c, e := mypkg.ChildContext(p)
+//	        if e != nil {
+//	            t.Fatalf("ChildContext: %s", e)
+//	        }
+//	        return c
+//	    })
+//	}
+package contextualtest
+
+import (
+	"fmt"
+	"goerror"
+	"testing"
+
+	"github.com/alphazero/contextual"
+)
+
+// Factory returns a new and empty context. If parent is nil the returned
+// context must be a root context, otherwise a child of parent.  Parent is
+// always a context previously returned by the same factory.
+//
+// A factory that can not create the context should fail the test via t.
+type Factory func(t *testing.T, parent contextual.Context) contextual.Context
+
+// RunConformance runs the conformance suite against contexts created by
+// the factory. Each check is run as a subtest of t.
+func RunConformance(t *testing.T, factory Factory) {
+	if factory == nil {
+		t.Fatalf("RunConformance: nil factory")
+	}
+	for _, check := range checks {
+		check := check
+		t.Run(check.name, func(t *testing.T) {
+			check.fn(t, factory)
+		})
+	}
+}
+
+type check struct {
+	name string
+	fn   func(t *testing.T, factory Factory)
+}
+
+var checks = []check{
+	{"RootInit", checkRootInit},
+	{"ChildInit", checkChildInit},
+	{"Depth", checkDepth},
+	{"LookupErrors", checkLookupErrors},
+	{"LookupNErrors", checkLookupNErrors},
+	{"BindErrors", checkBindErrors},
+	{"UnbindErrors", checkUnbindErrors},
+	{"RebindErrors", checkRebindErrors},
+	{"LookupUnbound", checkLookupUnbound},
+	{"BindLookup", checkBindLookup},
+	{"SizeIsEmpty", checkSizeIsEmpty},
+	{"Unbind", checkUnbind},
+	{"Rebind", checkRebind},
+	{"ChildVisibility", checkChildVisibility},
+	{"ChildIsolation", checkChildIsolation},
+	{"SiblingIsolation", checkSiblingIsolation},
+	{"Shadowing", checkShadowing},
+	{"UnbindReceiverOnly", checkUnbindReceiverOnly},
+	{"RebindReceiverOnly", checkRebindReceiverOnly},
+	{"LookupNSteps", checkLookupNSteps},
+	{"SizeIsEmptyFromChildren", checkSizeIsEmptyFromChildren},
+}
+
+// ----------------------------------------------------------------------------
+// helpers
+// ----------------------------------------------------------------------------
+
+// values used by the checks. These are restricted to (comparable) basic
+// types so that persistent or remote implementations can encode them.
+func testValues() []interface{} {
+	return []interface{}{"Salaam!", 10, int64(-1), 3.14, true, "", uint64(1 << 40)}
+}
+
+func testNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("value[%d]", i)
+	}
+	return names
+}
+
+// returns a hierarchy of n+1 contexts; chain[0] is root and chain[i] is the
+// child of chain[i-1].
+func newChain(t *testing.T, factory Factory, n int) []contextual.Context {
+	chain := []contextual.Context{newRoot(t, factory)}
+	for i := 0; i < n; i++ {
+		chain = append(chain, newChild(t, factory, chain[i]))
+	}
+	return chain
+}
+
+func newRoot(t *testing.T, factory Factory) contextual.Context {
+	ctx := factory(t, nil)
+	if ctx == nil {
+		t.Fatalf("factory returned nil root context")
+	}
+	return ctx
+}
+
+func newChild(t *testing.T, factory Factory, parent contextual.Context) contextual.Context {
+	ctx := factory(t, parent)
+	if ctx == nil {
+		t.Fatalf("factory returned nil child context")
+	}
+	return ctx
+}
+
+func mustBind(t *testing.T, ctx contextual.Context, name string, value interface{}) {
+	if e := ctx.Bind(name, value); e != nil {
+		t.Fatalf("Bind(%q, %v) - unexpected error: %s", name, value, e)
+	}
+}
+
+func assertLookup(t *testing.T, ctx contextual.Context, name string, expected interface{}) {
+	v, e := ctx.Lookup(name)
+	if e != nil {
+		t.Fatalf("Lookup(%q) - unexpected error: %s", name, e)
+	}
+	if v != expected {
+		t.Fatalf("Lookup(%q) - expected:%v got:%v", name, expected, v)
+	}
+}
+
+func assertLookupN(t *testing.T, ctx contextual.Context, name string, n int, expected interface{}) {
+	v, e := ctx.LookupN(name, n)
+	if e != nil {
+		t.Fatalf("LookupN(%q, %d) - unexpected error: %s", name, n, e)
+	}
+	if v != expected {
+		t.Fatalf("LookupN(%q, %d) - expected:%v got:%v", name, n, expected, v)
+	}
+}
+
+func assertSize(t *testing.T, ctx contextual.Context, expected int) {
+	if n := ctx.Size(); n != expected {
+		t.Fatalf("Size() - expected:%d got:%d", expected, n)
+	}
+	if b := ctx.IsEmpty(); b != (expected == 0) {
+		t.Fatalf("IsEmpty() - expected:%t got:%t", expected == 0, b)
+	}
+}
+
+// asserts that e is of the error category and v is nil
+func assertFault(t *testing.T, op string, v interface{}, e error, category func(...string) *goerror.Error, catname string) {
+	if e == nil {
+		t.Fatalf("%s - expected error: %s", op, catname)
+	}
+	if !goerror.TypeOf(e).Is(category) {
+		t.Fatalf("%s - expected error: %s got: %s", op, catname, e)
+	}
+	if v != nil {
+		t.Fatalf("%s - unexpected value on faulted return: %v", op, v)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// checks
+// ----------------------------------------------------------------------------
+
+func checkRootInit(t *testing.T, factory Factory) {
+	ctx := newRoot(t, factory)
+	if !ctx.IsRoot() {
+		t.Fatalf("IsRoot() for a root context must return true")
+	}
+	if d := ctx.Depth(); d != 0 {
+		t.Fatalf("Depth() for a root context - expected:0 got:%d", d)
+	}
+	assertSize(t, ctx, 0)
+}
+
+func checkChildInit(t *testing.T, factory Factory) {
+	root := newRoot(t, factory)
+	ctx := newChild(t, factory, root)
+	if ctx.IsRoot() {
+		t.Fatalf("IsRoot() for a child context must return false")
+	}
+	assertSize(t, ctx, 0)
+}
+
+func checkDepth(t *testing.T, factory Factory) {
+	chain := newChain(t, factory, 3)
+	for i, ctx := range chain {
+		if d := ctx.Depth(); d != i {
+			t.Fatalf("chain[%d] - Depth() - expected:%d got:%d", i, i, d)
+		}
+	}
+}
+
+func checkLookupErrors(t *testing.T, factory Factory) {
+	chain := newChain(t, factory, 1)
+	for _, ctx := range chain {
+		v, e := ctx.Lookup("")
+		assertFault(t, `Lookup("")`, v, e, contextual.NilNameError, "NilNameError")
+	}
+}
+
+func checkLookupNErrors(t *testing.T, factory Factory) {
+	chain := newChain(t, factory, 1)
+	for _, ctx := range chain {
+		v, e := ctx.LookupN("", 0)
+		assertFault(t, `LookupN("", 0)`, v, e, contextual.NilNameError, "NilNameError")
+		v, e = ctx.LookupN("name", -1)
+		assertFault(t, `LookupN("name", -1)`, v, e, contextual.NegativeNArgError, "NegativeNArgError")
+	}
+}
+
+func checkBindErrors(t *testing.T, factory Factory) {
+	ctx := newRoot(t, factory)
+
+	e := ctx.Bind("", "value")
+	assertFault(t, `Bind("", v)`, nil, e, contextual.NilNameError, "NilNameError")
+	e = ctx.Bind("name", nil)
+	assertFault(t, `Bind("name", nil)`, nil, e, contextual.NilValueError, "NilValueError")
+
+	mustBind(t, ctx, "name", "value")
+	e = ctx.Bind("name", "another value")
+	assertFault(t, `Bind("name", v) [bound]`, nil, e, contextual.AlreadyBoundError, "AlreadyBoundError")
+
+	// faulted binds do not modify the context
+	assertLookup(t, ctx, "name", "value")
+	assertSize(t, ctx, 1)
+}
+
+func checkUnbindErrors(t *testing.T, factory Factory) {
+	ctx := newRoot(t, factory)
+
+	v, e := ctx.Unbind("")
+	assertFault(t, `Unbind("")`, v, e, contextual.NilNameError, "NilNameError")
+	v, e = ctx.Unbind("name")
+	assertFault(t, `Unbind("name")`, v, e, contextual.NoSuchBindingError, "NoSuchBindingError")
+}
+
+func checkRebindErrors(t *testing.T, factory Factory) {
+	ctx := newRoot(t, factory)
+
+	v, e := ctx.Rebind("", "value")
+	assertFault(t, `Rebind("", v)`, v, e, contextual.NilNameError, "NilNameError")
+	v, e = ctx.Rebind("name", "value")
+	assertFault(t, `Rebind("name", v)`, v, e, contextual.NoSuchBindingError, "NoSuchBindingError")
+
+	// a faulted rebind of a bound name must leave the binding as is
+	mustBind(t, ctx, "name", "value")
+	v, e = ctx.Rebind("name", nil)
+	assertFault(t, `Rebind("name", nil)`, v, e, contextual.NilValueError, "NilValueError")
+	assertLookup(t, ctx, "name", "value")
+	assertSize(t, ctx, 1)
+}
+
+func checkLookupUnbound(t *testing.T, factory Factory) {
+	chain := newChain(t, factory, 1)
+	for i, ctx := range chain {
+		v, e := ctx.Lookup("no-such-binding")
+		if e != nil {
+			t.Fatalf("chain[%d] - Lookup - unexpected error: %s", i, e)
+		}
+		if v != nil {
+			t.Fatalf("chain[%d] - Lookup - expected:nil got:%v", i, v)
+		}
+		assertLookupN(t, ctx, "no-such-binding", 0, nil)
+		assertLookupN(t, ctx, "no-such-binding", 10, nil)
+	}
+}
+
+func checkBindLookup(t *testing.T, factory Factory) {
+	ctx := newRoot(t, factory)
+	values := testValues()
+	names := testNames(len(values))
+
+	for i, name := range names {
+		mustBind(t, ctx, name, values[i])
+	}
+	for i, name := range names {
+		assertLookup(t, ctx, name, values[i])
+		assertLookupN(t, ctx, name, 0, values[i])
+	}
+}
+
+func checkSizeIsEmpty(t *testing.T, factory Factory) {
+	ctx := newRoot(t, factory)
+	values := testValues()
+	names := testNames(len(values))
+
+	for i, name := range names {
+		assertSize(t, ctx, i)
+		mustBind(t, ctx, name, values[i])
+	}
+	assertSize(t, ctx, len(names))
+}
+
+func checkUnbind(t *testing.T, factory Factory) {
+	ctx := newRoot(t, factory)
+	values := testValues()
+	names := testNames(len(values))
+
+	for i, name := range names {
+		mustBind(t, ctx, name, values[i])
+	}
+	for i, name := range names {
+		v, e := ctx.Unbind(name)
+		if e != nil {
+			t.Fatalf("Unbind(%q) - unexpected error: %s", name, e)
+		}
+		if v != values[i] {
+			t.Fatalf("Unbind(%q) - expected:%v got:%v", name, values[i], v)
+		}
+		assertLookup(t, ctx, name, nil)
+		assertSize(t, ctx, len(names)-i-1)
+	}
+
+	// an unbound name can be bound again
+	mustBind(t, ctx, names[0], values[0])
+	assertLookup(t, ctx, names[0], values[0])
+}
+
+func checkRebind(t *testing.T, factory Factory) {
+	ctx := newRoot(t, factory)
+
+	mustBind(t, ctx, "name", "value")
+	v, e := ctx.Rebind("name", "on the rebound")
+	if e != nil {
+		t.Fatalf("Rebind - unexpected error: %s", e)
+	}
+	if v != "value" {
+		t.Fatalf("Rebind - old value - expected:%v got:%v", "value", v)
+	}
+	assertLookup(t, ctx, "name", "on the rebound")
+	assertSize(t, ctx, 1)
+
+	// value type may change on rebind
+	if v, e = ctx.Rebind("name", 42); e != nil {
+		t.Fatalf("Rebind - unexpected error: %s", e)
+	}
+	if v != "on the rebound" {
+		t.Fatalf("Rebind - old value - expected:%v got:%v", "on the rebound", v)
+	}
+	assertLookup(t, ctx, "name", 42)
+}
+
+func checkChildVisibility(t *testing.T, factory Factory) {
+	chain := newChain(t, factory, 3)
+	mustBind(t, chain[0], "name", "value")
+	for i, ctx := range chain {
+		assertLookup(t, ctx, "name", "value")
+		if i > 0 {
+			mustBind(t, chain[i], fmt.Sprintf("name-%d", i), i)
+		}
+	}
+	// bindings in the middle of the hierarchy are visible to descendants only
+	leaf := chain[len(chain)-1]
+	for i := 1; i < len(chain); i++ {
+		name := fmt.Sprintf("name-%d", i)
+		assertLookup(t, leaf, name, i)
+		for _, ancestor := range chain[:i] {
+			assertLookup(t, ancestor, name, nil)
+		}
+	}
+}
+
+func checkChildIsolation(t *testing.T, factory Factory) {
+	root := newRoot(t, factory)
+	child := newChild(t, factory, root)
+
+	mustBind(t, child, "name", "value")
+	assertLookup(t, child, "name", "value")
+	assertLookup(t, root, "name", nil)
+	assertSize(t, root, 0)
+
+	// parent can bind the same name without conflict
+	mustBind(t, root, "name", "root value")
+	assertLookup(t, child, "name", "value")
+	assertLookup(t, root, "name", "root value")
+}
+
+func checkSiblingIsolation(t *testing.T, factory Factory) {
+	root := newRoot(t, factory)
+	c1 := newChild(t, factory, root)
+	c2 := newChild(t, factory, root)
+
+	mustBind(t, c1, "name", "value")
+	assertLookup(t, c2, "name", nil)
+	assertSize(t, c2, 0)
+	mustBind(t, c2, "name", "sibling value")
+	assertLookup(t, c1, "name", "value")
+	assertLookup(t, c2, "name", "sibling value")
+}
+
+// Size is not checked: the spec counts the visible bindings but does not
+// settle whether a shadowed binding is one (in-memory contexts count the
+// bindings of every context of the path), so implementations may differ.
+func checkShadowing(t *testing.T, factory Factory) {
+	chain := newChain(t, factory, 2)
+	root, mid, leaf := chain[0], chain[1], chain[2]
+
+	mustBind(t, root, "name", "root")
+	mustBind(t, mid, "name", "mid")
+
+	assertLookup(t, root, "name", "root")
+	assertLookup(t, mid, "name", "mid")
+	assertLookup(t, leaf, "name", "mid")
+
+	// nearest binding is found within any permitted walk
+	assertLookupN(t, leaf, "name", 1, "mid")
+	assertLookupN(t, leaf, "name", 2, "mid")
+
+	// removing the shadow reveals the shadowed binding
+	if _, e := mid.Unbind("name"); e != nil {
+		t.Fatalf("Unbind - unexpected error: %s", e)
+	}
+	assertLookup(t, mid, "name", "root")
+	assertLookup(t, leaf, "name", "root")
+}
+
+func checkUnbindReceiverOnly(t *testing.T, factory Factory) {
+	root := newRoot(t, factory)
+	child := newChild(t, factory, root)
+	mustBind(t, root, "name", "value")
+
+	v, e := child.Unbind("name")
+	assertFault(t, `child.Unbind("name")`, v, e, contextual.NoSuchBindingError, "NoSuchBindingError")
+	assertLookup(t, root, "name", "value")
+	assertLookup(t, child, "name", "value")
+}
+
+func checkRebindReceiverOnly(t *testing.T, factory Factory) {
+	root := newRoot(t, factory)
+	child := newChild(t, factory, root)
+	mustBind(t, root, "name", "value")
+
+	v, e := child.Rebind("name", "new value")
+	assertFault(t, `child.Rebind("name", v)`, v, e, contextual.NoSuchBindingError, "NoSuchBindingError")
+	assertLookup(t, root, "name", "value")
+	assertLookup(t, child, "name", "value")
+	assertLookupN(t, child, "name", 0, nil)
+}
+
+func checkLookupNSteps(t *testing.T, factory Factory) {
+	chain := newChain(t, factory, 3)
+	mustBind(t, chain[0], "name", "value")
+
+	for depth, ctx := range chain {
+		// n < depth: root is out of reach
+		for n := 0; n < depth; n++ {
+			assertLookupN(t, ctx, "name", n, nil)
+		}
+		// n >= depth: root is reachable, including walks past root
+		for n := depth; n <= depth+2; n++ {
+			assertLookupN(t, ctx, "name", n, "value")
+		}
+	}
+}
+
+func checkSizeIsEmptyFromChildren(t *testing.T, factory Factory) {
+	chain := newChain(t, factory, 2)
+	root, mid, leaf := chain[0], chain[1], chain[2]
+	sibling := newChild(t, factory, root)
+
+	mustBind(t, root, "a", 1)
+	for _, ctx := range chain {
+		assertSize(t, ctx, 1)
+	}
+	mustBind(t, mid, "b", 2)
+	assertSize(t, root, 1)
+	assertSize(t, mid, 2)
+	assertSize(t, leaf, 2)
+	assertSize(t, sibling, 1)
+
+	mustBind(t, leaf, "c", 3)
+	assertSize(t, leaf, 3)
+	assertSize(t, mid, 2)
+
+	if _, e := root.Unbind("a"); e != nil {
+		t.Fatalf("Unbind - unexpected error: %s", e)
+	}
+	assertSize(t, root, 0)
+	assertSize(t, sibling, 0)
+	assertSize(t, mid, 1)
+	assertSize(t, leaf, 2)
+}
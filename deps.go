@@ -0,0 +1,275 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// container dependencies
+// ----------------------------------------------------------------------------
+
+func requires(e *entry) []string {
+	if d, ok := e.component.(Dependent); ok {
+		return d.Requires()
+	}
+	return nil
+}
+
+func provides(e *entry) []string {
+	if d, ok := e.component.(Dependent); ok {
+		return d.Provides()
+	}
+	return nil
+}
+
+// returns the errors of the requirements of the entries that are neither
+// provided by a component of the container, nor bound in its context.
+func (c *container) unsatisfied(entries []*entry) ErrorList {
+	c.Lock()
+	provided := make(map[string]bool)
+	for _, e := range c.entries {
+		for _, name := range provides(e) {
+			provided[name] = true
+		}
+	}
+	ctx := c.context
+	c.Unlock()
+
+	var errors ErrorList
+	for _, e := range entries {
+		for _, name := range requires(e) {
+			if provided[name] {
+				continue
+			}
+			if v, _ := ctx.Lookup(name); v == nil {
+				errors = append(errors, NoSuchBindingError(fmt.Sprintf("%s requires %s", e.name, name)))
+			}
+		}
+	}
+	return errors
+}
+
+// partitions the entries into levels, such that the entries of a level only
+// depend on entries of preceding levels. An entry depends on the entries that
+// provide what it requires; entries without dependencies, e.g. components
+// that are not Dependent, are of the first level. Entries of a level keep
+// their order.
+//
+// Errors:
+//
+//	DependencyCycleError <= entries depend on each other
+func levels(entries []*entry) ([][]*entry, error) {
+	providers := make(map[string][]*entry)
+	for _, e := range entries {
+		for _, name := range provides(e) {
+			providers[name] = append(providers[name], e)
+		}
+	}
+	deps := make(map[*entry][]*entry)
+	for _, e := range entries {
+		for _, name := range requires(e) {
+			for _, p := range providers[name] {
+				if p != e {
+					deps[e] = append(deps[e], p)
+				}
+			}
+		}
+	}
+
+	var result [][]*entry
+	done := make(map[*entry]bool)
+	for len(done) < len(entries) {
+		var level []*entry
+	next:
+		for _, e := range entries {
+			if done[e] {
+				continue
+			}
+			for _, d := range deps[e] {
+				if !done[d] {
+					continue next
+				}
+			}
+			level = append(level, e)
+		}
+		if len(level) == 0 {
+			return nil, DependencyCycleError(cycle(entries, deps, done))
+		}
+		for _, e := range level {
+			done[e] = true
+		}
+		result = append(result, level)
+	}
+	return result, nil
+}
+
+// returns the path of a dependency cycle among the entries that are not done.
+func cycle(entries []*entry, deps map[*entry][]*entry, done map[*entry]bool) string {
+	var path []*entry
+	onPath := make(map[*entry]int)
+	e := entries[0]
+	for _, e0 := range entries {
+		if !done[e0] {
+			e = e0
+			break
+		}
+	}
+	// every remaining entry has a remaining dependency: walk them until an
+	// entry repeats.
+	for {
+		if i, ok := onPath[e]; ok {
+			path = append(path[i:], e)
+			break
+		}
+		onPath[e] = len(path)
+		path = append(path, e)
+		for _, d := range deps[e] {
+			if !done[d] {
+				e = d
+				break
+			}
+		}
+	}
+	names := make([]string, len(path))
+	for i, e := range path {
+		names[i] = e.name
+	}
+	return strings.Join(names, " -> ")
+}
+
+// returns the levels of the started entries, for stopping in reverse. The
+// order of addition is used if the entries depend on each other.
+func (c *container) startedLevels() [][]*entry {
+	entries := c.entriesIn(StateStarted)
+	lv, e := levels(entries)
+	if e != nil {
+		lv = nil
+		for _, e := range entries {
+			lv = append(lv, []*entry{e})
+		}
+	}
+	return lv
+}
+
+// transitions the entries of a level. Components that are not Dependent are
+// transitioned one at a time, in level order, and so keep the order of
+// addition; they are started before, and stopped after, the Dependent
+// components of the level, which are transitioned concurrently. A failed
+// start ends the transition of the level. Returns the entries that
+// transitioned, in order, and the errors. See transitionAll for the timeout.
+func (c *container) transitionLevel(level []*entry, to State, timeout time.Duration) ([]*entry, ErrorList) {
+	var ordered, concurrent []*entry
+	for _, e := range level {
+		if _, ok := e.component.(Dependent); ok {
+			concurrent = append(concurrent, e)
+		} else {
+			ordered = append(ordered, e)
+		}
+	}
+
+	var done []*entry
+	var errors ErrorList
+	inOrder := func() {
+		for _, e := range ordered {
+			transitioned, errs := c.transitionAll([]*entry{e}, to, timeout)
+			done, errors = append(done, transitioned...), append(errors, errs...)
+			if len(errs) > 0 && to == StateStarted {
+				return
+			}
+		}
+	}
+	together := func() {
+		transitioned, errs := c.transitionAll(concurrent, to, timeout)
+		done, errors = append(done, transitioned...), append(errors, errs...)
+	}
+	if to == StateStarted {
+		if inOrder(); len(errors) == 0 {
+			together()
+		}
+	} else {
+		together()
+		inOrder()
+	}
+	return done, errors
+}
+
+// transitions the entries concurrently. If timeout is positive, a transition
+// that takes longer is reported as failed, and is not waited on: the
+// timeout is emitted as an EventFailed, and the transition emits its outcome
+// once it completes. Returns the entries that transitioned, and the errors,
+// in order.
+func (c *container) transitionAll(entries []*entry, to State, timeout time.Duration) ([]*entry, ErrorList) {
+	results := make([]chan error, len(entries))
+	for i, e := range entries {
+		results[i] = make(chan error, 1)
+		go func(e *entry, result chan<- error) {
+			result <- c.transition(e, to)
+		}(e, results[i])
+	}
+
+	var done []*entry
+	var errors ErrorList
+	deadline := time.Now().Add(timeout)
+	for i, e := range entries {
+		var expired <-chan time.Time
+		if timeout > 0 {
+			expired = time.After(time.Until(deadline))
+		}
+		select {
+		case err := <-results[i]:
+			if err != nil {
+				errors = append(errors, err)
+				continue
+			}
+			done = append(done, e)
+		case <-expired:
+			err := LifecycleError(fmt.Sprintf("%s: %s timed out after %s", e.name, to, timeout))
+			c.Lock()
+			from := e.state
+			c.Unlock()
+			c.failed(e, from, to, err)
+			errors = append(errors, err)
+		}
+	}
+	return done, errors
+}
+
+// binds the bindings provided by the started entry in the container's
+// context.
+func (c *container) publish(e *entry) error {
+	c.Lock()
+	ctx := c.context
+	c.Unlock()
+	for _, name := range provides(e) {
+		v, err := e.context.Lookup(name)
+		if err == nil && v == nil {
+			err = NoSuchBindingError(name)
+		}
+		if err == nil {
+			err = ctx.Bind(name, v)
+		}
+		if err != nil {
+			c.unpublish(e)
+			return DependencyError(fmt.Sprintf("%s provides %s", e.name, name)).WithCause(err)
+		}
+		e.published = append(e.published, name)
+	}
+	return nil
+}
+
+// unbinds the bindings published for the entry.
+func (c *container) unpublish(e *entry) {
+	c.Lock()
+	ctx := c.context
+	c.Unlock()
+	for _, name := range e.published {
+		ctx.Unbind(name)
+	}
+	e.published = nil
+}
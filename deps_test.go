@@ -0,0 +1,243 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"goerror"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// testing: container dependencies
+// ============================================================================
+
+// helper - a lifecycle component with dependencies; binds what it provides
+// on start, and runs the hook (if any) on start and stop.
+type dependentComponent struct {
+	*lifecycleComponent
+	requires, provides []string
+	onStart, onStop    func()
+}
+
+func newDependentComponent(name string, log *callLog, requires, provides []string) *dependentComponent {
+	return &dependentComponent{lifecycleComponent: newLifecycleComponent(name, log), requires: requires, provides: provides}
+}
+
+func (c *dependentComponent) Requires() []string { return c.requires }
+func (c *dependentComponent) Provides() []string { return c.provides }
+
+func (c *dependentComponent) Start() error {
+	for _, name := range c.requires {
+		if v, _ := c.context.Lookup(name); v == nil {
+			return NoSuchBindingError(name)
+		}
+	}
+	for _, name := range c.provides {
+		c.context.Bind(name, c.name)
+	}
+	if c.onStart != nil {
+		c.onStart()
+	}
+	return c.lifecycleComponent.Start()
+}
+
+func (c *dependentComponent) Stop() error {
+	if c.onStop != nil {
+		c.onStop()
+	}
+	return c.lifecycleComponent.Stop()
+}
+
+func TestDependencyOrder(t *testing.T) {
+	c, ctx := newTestContainer(t)
+	ctx.Bind("config", "test")
+	log := &callLog{}
+	// added in reverse dependency order
+	c.Add(newDependentComponent("handler", log, []string{"db.pool", "config"}, nil))
+	c.Add(newDependentComponent("store", log, []string{"db.conn"}, []string{"db.pool"}))
+	c.Add(newDependentComponent("db", log, nil, []string{"db.conn"}))
+
+	if e := c.Start(); e != nil {
+		t.Fatalf("Start - unexpected error: %s", e)
+	}
+	assertCalls(t, log, "db.init", "db.start", "store.init", "store.start", "handler.init", "handler.start")
+	if v, _ := ctx.Lookup("db.pool"); v != "store" {
+		t.Fatalf("Lookup(db.pool) - provided binding is not published: %v", v)
+	}
+
+	log.calls = nil
+	if e := c.Stop(); e != nil {
+		t.Fatalf("Stop - unexpected error: %s", e)
+	}
+	assertCalls(t, log, "handler.stop", "store.stop", "db.stop")
+	if v, _ := ctx.Lookup("db.pool"); v != nil {
+		t.Fatalf("Lookup(db.pool) - published binding is not unbound on stop: %v", v)
+	}
+
+	// restart
+	if e := c.Start(); e != nil {
+		t.Fatalf("Start - unexpected error: %s", e)
+	}
+	c.Stop()
+}
+
+func TestDependencyParallelStart(t *testing.T) {
+	c, _ := newTestContainer(t)
+	log := &callLog{}
+	// a and b are independent, and only start if started concurrently
+	var barrier sync.WaitGroup
+	barrier.Add(2)
+	for _, name := range []string{"a", "b"} {
+		comp := newDependentComponent(name, log, nil, []string{name})
+		comp.onStart = func() {
+			barrier.Done()
+			barrier.Wait()
+		}
+		c.Add(comp)
+	}
+	c.Add(newDependentComponent("ab", log, []string{"a", "b"}, nil))
+
+	done := make(chan error, 1)
+	go func() { done <- c.Start() }()
+	select {
+	case e := <-done:
+		if e != nil {
+			t.Fatalf("Start - unexpected error: %s", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Start - independent components are not started concurrently")
+	}
+	if calls := log.get(); calls[len(calls)-1] != "ab.start" {
+		t.Fatalf("Start - ab is not started last: %v", calls)
+	}
+	c.Stop()
+}
+
+func TestOrderedStart(t *testing.T) {
+	c, _ := newTestContainer(t)
+	log := &callLog{}
+	// components that are not Dependent keep the order of addition, and are
+	// started before the Dependent components of their level
+	c.Add(newDependentComponent("db", log, nil, []string{"db.conn"}))
+	for _, name := range []string{"a", "b", "c"} {
+		c.Add(newLifecycleComponent(name, log))
+	}
+	if e := c.Start(); e != nil {
+		t.Fatalf("Start - unexpected error: %s", e)
+	}
+	assertCalls(t, log, "db.init", "a.init", "b.init", "c.init", "a.start", "b.start", "c.start", "db.start")
+
+	log.calls = nil
+	c.Stop()
+	assertCalls(t, log, "db.stop", "c.stop", "b.stop", "a.stop")
+}
+
+// helper - a dependent component with an injected field
+type poolConsumer struct {
+	*dependentComponent
+	Pool string `contextual:"db.pool"`
+}
+
+func TestDependencyInjection(t *testing.T) {
+	c, _ := newTestContainer(t)
+	log := &callLog{}
+	consumer := &poolConsumer{dependentComponent: newDependentComponent("consumer", log, []string{"db.pool"}, nil)}
+	c.Add(consumer)
+	c.Add(newDependentComponent("db", log, nil, []string{"db.pool"}))
+
+	// the consumer is injected once the provider has started
+	if e := c.Start(); e != nil {
+		t.Fatalf("Start - unexpected error: %s", e)
+	}
+	if consumer.Pool != "db" {
+		t.Fatalf("Start - expected injected db.pool:db got:%q", consumer.Pool)
+	}
+	assertCalls(t, log, "db.init", "db.start", "consumer.init", "consumer.start")
+	c.Stop()
+}
+
+func TestDependencyErrors(t *testing.T) {
+	c, _ := newTestContainer(t)
+	log := &callLog{}
+	c.Add(newDependentComponent("a", log, []string{"x", "y"}, nil))
+
+	e := c.Start()
+	if e == nil || !goerror.TypeOf(e).Is(DependencyError) {
+		t.Fatalf("Start - expected error: %s got: %v", DependencyError(), e)
+	}
+	if list := goerror.TypeOf(e).Cause().(ErrorList); len(list) != 2 {
+		t.Fatalf("Start - expected 2 unsatisfied requirements got: %s", list)
+	}
+	assertState(t, c, "a", StateNew)
+
+	c, _ = newTestContainer(t)
+	c.Add(newDependentComponent("a", log, []string{"c.out"}, []string{"a.out"}))
+	c.Add(newDependentComponent("b", log, []string{"a.out"}, []string{"b.out"}))
+	c.Add(newDependentComponent("c", log, []string{"b.out"}, []string{"c.out"}))
+	e = c.Start()
+	if e == nil || !goerror.TypeOf(e).Is(DependencyCycleError) {
+		t.Fatalf("Start - expected error: %s got: %v", DependencyCycleError(), e)
+	}
+	if !strings.Contains(e.Error(), "a -> c -> b -> a") {
+		t.Fatalf("Start - expected cycle path got: %s", e)
+	}
+
+	// a component that does not bind what it provides fails to start
+	c, _ = newTestContainer(t)
+	comp := newDependentComponent("a", log, nil, []string{"a.out"})
+	comp.onStart = func() { comp.context.Unbind("a.out") }
+	c.Add(comp)
+	assertError(t, "Start", c.Start(), DependencyError)
+	assertState(t, c, "a", StateInitialized)
+}
+
+func TestStopTimeout(t *testing.T) {
+	ctx := NewContext()
+	c := NewContainer(StopTimeout(50 * time.Millisecond))
+	c.SetContext(ctx)
+	log := &callLog{}
+
+	release := make(chan struct{})
+	defer close(release)
+	stuck := newDependentComponent("stuck", log, nil, nil)
+	stuck.onStop = func() { <-release }
+	c.Add(stuck)
+	c.Add(newLifecycleComponent("other", log))
+	c.Start()
+	events := make(chan Event, 4)
+	c.Subscribe(func(ev Event) {
+		if ev.Path == "/stuck" {
+			events <- ev
+		}
+	})
+
+	start := time.Now()
+	e := c.Stop()
+	if e == nil || !goerror.TypeOf(e).Is(LifecycleError) || !strings.Contains(e.Error(), "timed out") {
+		t.Fatalf("Stop - expected timeout error got: %v", e)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Stop - timeout is not enforced: %s", d)
+	}
+	assertState(t, c, "other", StateStopped)
+
+	// the timeout is emitted, and so is the stop once it completes
+	if ev := <-events; ev.Kind != EventFailed || ev.Err == nil || !strings.Contains(ev.Err.Error(), "timed out") {
+		t.Fatalf("Stop - expected timeout event got: %v", ev)
+	}
+	release <- struct{}{}
+	select {
+	case ev := <-events:
+		if ev.Kind != EventStateChanged || ev.To != StateStopped {
+			t.Fatalf("Stop - expected stopped event got: %v", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Stop - timed out stop is not emitted once it completes")
+	}
+	assertState(t, c, "stuck", StateStopped)
+}
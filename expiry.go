@@ -0,0 +1,239 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"time"
+)
+
+// SystemClock is the Clock of the system time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// ContextOption configures an in-memory context. Options of a child context
+// override those that it inherits from an in-memory parent.
+type ContextOption func(*context)
+
+// WithClock sets the clock of the context. The default is the clock of the
+// parent, if an in-memory context, and otherwise SystemClock.
+func WithClock(clock Clock) ContextOption {
+	return func(c *context) { c.clock = clock }
+}
+
+// OnExpire sets the func that is called when an expired binding is removed
+// from the context.
+func OnExpire(fn func(name string, value interface{})) ContextOption {
+	return func(c *context) { c.onExpire = fn }
+}
+
+// ExpiryResolution sets the interval at which expired bindings are removed
+// from the context. The default is 100ms.
+func ExpiryResolution(d time.Duration) ContextOption {
+	return func(c *context) {
+		if d > 0 {
+			c.wheel.tick = d
+		}
+	}
+}
+
+// the expiry wheel of a context. Expiring names are hashed to slots by the
+// tick of their deadline (rounded up), and the slots of the elapsed ticks are
+// swept for expired bindings. A name may be in several slots if it was
+// rebound, and stays in a slot until its deadline, which may be rounds away.
+// The sweep timer is set for the tick of the earliest deadline, and so the
+// wheel is idle until a binding expires.
+type wheel struct {
+	tick    time.Duration
+	slots   [64][]string
+	entries int         // in all slots
+	swept   int64       // the last swept tick
+	stop    func() bool // stops the sweep timer; nil if not scheduled
+	due     int64       // the tick of the sweep timer, if scheduled
+	timer   int         // identifies the sweep timer, to ignore stopped ones
+}
+
+func (w *wheel) tickOf(t time.Time) int64 {
+	return t.UnixNano() / int64(w.tick)
+}
+
+// BindTTL binds the value for the time to live. See Expiring.
+func (c *context) BindTTL(name string, value interface{}, ttl time.Duration) error {
+	if ttl <= 0 {
+		return IllegalArgumentError(fmt.Sprintf("ttl is %s", ttl))
+	}
+	return c.bindWith(name, value, func(name string) { c.expire(name, ttl) })
+}
+
+// RebindTTL rebinds the value for the time to live. See Expiring.
+func (c *context) RebindTTL(name string, value interface{}, ttl time.Duration) (unboundValue interface{}, e error) {
+	if name, e = c.checkRebind(name, value); e != nil {
+		return nil, e
+	}
+	if ttl <= 0 {
+		return nil, IllegalArgumentError(fmt.Sprintf("ttl is %s", ttl))
+	}
+
+	c.Lock()
+	if unboundValue, e = c.rebind(name, value); e == nil {
+		c.expire(name, ttl)
+	}
+	c.Unlock()
+	if e == nil {
+		c.notify([]Change{{c, name, unboundValue, value, ChangeBinding}})
+	}
+	return
+}
+
+// sets the deadline of the binding, and schedules its removal.
+// REVU: c must be locked.
+func (c *context) expire(name string, ttl time.Duration) {
+	now := c.clock.Now()
+	deadline := now.Add(ttl)
+	if c.deadlines == nil {
+		c.deadlines = make(map[string]time.Time)
+	}
+	c.deadlines[name] = deadline
+
+	w := &c.wheel
+	if w.entries == 0 {
+		w.swept = w.tickOf(now) - 1
+	}
+	tick := w.tickOf(deadline.Add(w.tick - 1))
+	slot := &w.slots[tick%int64(len(w.slots))]
+	*slot = append(*slot, name)
+	w.entries++
+	c.schedule(now, tick)
+}
+
+// schedules the sweep of the tick, unless scheduled for an earlier one.
+// REVU: c must be locked.
+func (c *context) schedule(now time.Time, tick int64) {
+	w := &c.wheel
+	if w.stop != nil {
+		if w.due <= tick {
+			return
+		}
+		w.stop()
+	}
+	w.due = tick
+	w.timer++
+	timer := w.timer
+	at := time.Unix(0, tick*int64(w.tick))
+	w.stop = c.clock.AfterFunc(at.Sub(now), func() { c.sweep(timer) })
+}
+
+// schedules the sweep of the tick of the earliest deadline, if any.
+// REVU: c must be locked.
+func (c *context) scheduleNext(now time.Time) {
+	var next time.Time
+	for _, deadline := range c.deadlines {
+		if next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	if !next.IsZero() {
+		w := &c.wheel
+		c.schedule(now, w.tickOf(next.Add(w.tick-1)))
+	}
+}
+
+// removes the expired bindings of the slots of the elapsed ticks, and
+// notifies the watchers and the OnExpire func.
+func (c *context) sweep(timer int) {
+	c.Lock()
+	w := &c.wheel
+	if c.closed || w.stop == nil || w.timer != timer {
+		c.Unlock()
+		return
+	}
+	w.stop = nil
+	now := c.clock.Now()
+	to := w.tickOf(now)
+	from := w.swept + 1
+	if n := int64(len(w.slots)); to-from >= n {
+		from = to - n + 1
+	}
+
+	var expired []Change
+	for tick := from; tick <= to; tick++ {
+		slot := &w.slots[tick%int64(len(w.slots))]
+		kept := (*slot)[:0]
+		for _, name := range *slot {
+			deadline, ok := c.deadlines[name]
+			switch {
+			case !ok:
+				w.entries--
+			case now.Before(deadline):
+				kept = append(kept, name)
+			default:
+				w.entries--
+				expired = append(expired, Change{c, name, c.bindings[name], nil, ChangeExpired})
+				delete(c.bindings, name)
+				delete(c.deadlines, name)
+				delete(c.meta, name)
+			}
+		}
+		*slot = kept
+	}
+	w.swept = to
+	c.scheduleNext(now)
+	onExpire := c.onExpire
+	c.Unlock()
+
+	if len(expired) > 0 {
+		c.notify(expired)
+	}
+	if onExpire != nil {
+		for _, ch := range expired {
+			onExpire(ch.Name, ch.Old)
+		}
+	}
+}
+
+// returns the value bound to the name in the context, and whether bound
+// and not expired.
+// REVU: c must be (read) locked.
+func (c *context) get(name string) (interface{}, bool) {
+	value, ok := c.bindings[name]
+	if !ok || c.isExpired(name, time.Time{}) {
+		return nil, false
+	}
+	return value, true
+}
+
+// returns the number of the bindings of the context that are not expired.
+// REVU: c must be (read) locked.
+func (c *context) size() int {
+	n := len(c.bindings)
+	if len(c.deadlines) > 0 {
+		now := c.clock.Now()
+		for name := range c.deadlines {
+			if c.isExpired(name, now) {
+				n--
+			}
+		}
+	}
+	return n
+}
+
+// REVU: c must be (read) locked.
+func (c *context) isExpired(name string, now time.Time) bool {
+	deadline, ok := c.deadlines[name]
+	if !ok {
+		return false
+	}
+	if now.IsZero() {
+		now = c.clock.Now()
+	}
+	return !now.Before(deadline)
+}
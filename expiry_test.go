@@ -0,0 +1,208 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual_test
+
+import (
+	"goerror"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alphazero/contextual"
+	"github.com/alphazero/contextual/contextualtest"
+)
+
+// ============================================================================
+// testing: expiring bindings
+// ============================================================================
+
+func TestBindTTL(t *testing.T) {
+	clock := contextualtest.NewFakeClock(time.Unix(1000, 0))
+	root := contextual.NewContext(contextual.WithClock(clock))
+	child, _ := contextual.ChildContext(root)
+	ctx := child.(contextual.Expiring)
+
+	if e := ctx.BindTTL("k", 1, 0); e == nil || !goerror.TypeOf(e).Is(contextual.IllegalArgumentError) {
+		t.Fatalf("BindTTL(0) - expected error: %s got: %v", contextual.IllegalArgumentError(), e)
+	}
+	root.Bind("token", "root")
+	ctx.BindTTL("token", "child", time.Second)
+	ctx.BindTTL("session", 42, 3*time.Second)
+	if e := ctx.BindTTL("token", "x", time.Second); e == nil {
+		t.Fatalf("BindTTL(bound) - expected error: %s", contextual.AlreadyBoundError())
+	}
+	if v, _ := child.Lookup("token"); v != "child" || child.Size() != 3 {
+		t.Fatalf("BindTTL - unexpected: %v, size %d", v, child.Size())
+	}
+
+	// expired bindings are invisible, and no longer shadow the parent
+	clock.Advance(time.Second)
+	if v, _ := child.Lookup("token"); v != "root" {
+		t.Fatalf("Lookup(expired) - expected root binding, got: %v", v)
+	}
+	if v, _ := child.LookupN("token", 0); v != nil {
+		t.Fatalf("LookupN(expired) - unexpected: %v", v)
+	}
+	if child.Size() != 2 {
+		t.Fatalf("Size - expired binding is counted: %d", child.Size())
+	}
+	if _, e := child.Unbind("token"); e == nil {
+		t.Fatalf("Unbind(expired) - expected error: %s", contextual.NoSuchBindingError())
+	}
+	if e := child.Bind("token", "again"); e != nil {
+		t.Fatalf("Bind(expired) - unexpected error: %s", e)
+	}
+
+	// rebinding extends, and plain rebinding clears, the ttl
+	clock.Advance(2 * time.Second)
+	if _, e := ctx.RebindTTL("session", 43, time.Second); e == nil {
+		t.Fatalf("RebindTTL(expired) - expected error: %s", contextual.NoSuchBindingError())
+	}
+	ctx.BindTTL("session", 44, time.Second)
+	ctx.RebindTTL("session", 45, 2*time.Second)
+	clock.Advance(time.Second)
+	if v, _ := child.Lookup("session"); v != 45 {
+		t.Fatalf("RebindTTL - unexpected: %v", v)
+	}
+	child.Rebind("session", 46)
+	clock.Advance(time.Hour)
+	if v, _ := child.Lookup("session"); v != 46 {
+		t.Fatalf("Rebind - ttl is not cleared: %v", v)
+	}
+
+	root.Unbind("token")
+	child.Unbind("token")
+	ctx.BindTTL("k", 1, time.Second)
+	clock.Advance(time.Second)
+	child.Unbind("session")
+	if !child.IsEmpty() {
+		t.Fatalf("IsEmpty - expired binding is counted")
+	}
+}
+
+func TestExpiryNotification(t *testing.T) {
+	clock := contextualtest.NewFakeClock(time.Unix(1000, 0))
+	var expired []string
+	ctx := contextual.NewContext(
+		contextual.WithClock(clock),
+		contextual.ExpiryResolution(time.Second),
+		contextual.OnExpire(func(name string, value interface{}) { expired = append(expired, name) }),
+	)
+	var changes []contextual.Change
+	ctx.(contextual.Watchable).Watch(func(ch []contextual.Change) {
+		for _, c := range ch {
+			if c.New == nil {
+				changes = append(changes, c)
+			}
+		}
+	})
+
+	exp := ctx.(contextual.Expiring)
+	exp.BindTTL("a", 1, 1500*time.Millisecond)
+	exp.BindTTL("b", 2, 10*time.Minute) // rounds of the wheel away
+	exp.BindTTL("c", 3, time.Second)
+	exp.RebindTTL("c", 4, 5*time.Second)
+
+	clock.Advance(time.Second)
+	if len(expired) != 0 {
+		t.Fatalf("OnExpire - premature: %v", expired)
+	}
+	clock.Advance(time.Second)
+	if !reflect.DeepEqual(expired, []string{"a"}) {
+		t.Fatalf("OnExpire - expected:[a] got:%v", expired)
+	}
+	clock.Advance(4 * time.Second)
+	clock.Advance(10 * time.Minute)
+	if !reflect.DeepEqual(expired, []string{"a", "c", "b"}) {
+		t.Fatalf("OnExpire - expected:[a c b] got:%v", expired)
+	}
+	expected := []contextual.Change{{ctx, "a", 1, nil, contextual.ChangeExpired}, {ctx, "c", 4, nil, contextual.ChangeExpired}, {ctx, "b", 2, nil, contextual.ChangeExpired}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Watch - expected:%v got:%v", expected, changes)
+	}
+	if !ctx.IsEmpty() {
+		t.Fatalf("IsEmpty - expired bindings are not removed")
+	}
+
+	// the wheel is idle when no bindings expire
+	clock.Advance(time.Hour)
+	if len(expired) != 3 {
+		t.Fatalf("OnExpire - unexpected: %v", expired)
+	}
+}
+
+// helper - a component that fails to reconfigure
+type brittleComponent struct {
+	ctx contextual.Context
+}
+
+func (c *brittleComponent) SetContext(ctx contextual.Context) { c.ctx = ctx }
+func (c *brittleComponent) Configuration() []string           { return []string{"token"} }
+func (c *brittleComponent) Reconfigure(changed []string) error {
+	return contextual.IllegalStateError("not reconfigurable")
+}
+
+func TestExpiryRollback(t *testing.T) {
+	clock := contextualtest.NewFakeClock(time.Unix(1000, 0))
+	ctx := contextual.NewContext(contextual.WithClock(clock))
+	var failures []error
+	c := contextual.NewContainer(contextual.OnReconfigurationError(func(name string, e error) { failures = append(failures, e) }))
+	c.SetContext(ctx)
+	c.Add(&brittleComponent{})
+	ctx.(contextual.Expiring).BindTTL("token", "t", time.Second)
+	if e := c.Start(); e != nil {
+		t.Fatalf("Start - unexpected error: %s", e)
+	}
+	defer c.Stop()
+
+	// the expiry is not rolled back by the failed reconfiguration
+	clock.Advance(time.Second)
+	if len(failures) != 1 {
+		t.Fatalf("Reconfigure - expected 1 error got: %v", failures)
+	}
+	if v, _ := ctx.Lookup("token"); v != nil {
+		t.Fatalf("Lookup(expired) - rolled back: %v", v)
+	}
+}
+
+// countingClock counts the timers set on the clock.
+type countingClock struct {
+	*contextualtest.FakeClock
+	timers int
+}
+
+func (c *countingClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.timers++
+	return c.FakeClock.AfterFunc(d, f)
+}
+
+func TestExpiryTimer(t *testing.T) {
+	clock := &countingClock{FakeClock: contextualtest.NewFakeClock(time.Unix(1000, 0))}
+	ctx := contextual.NewContext(contextual.WithClock(clock))
+	x := ctx.(contextual.Expiring)
+
+	// the timer is set for the earliest deadline, and not for each tick
+	x.BindTTL("session", "s", time.Hour)
+	x.BindTTL("token", "t", time.Minute)
+	x.BindTTL("key", "k", 2*time.Hour)
+	clock.Advance(59 * time.Second)
+	if clock.timers != 2 {
+		t.Fatalf("BindTTL - expected 2 timers got: %d", clock.timers)
+	}
+	clock.Advance(time.Second)
+	if v, _ := ctx.Lookup("token"); v != nil {
+		t.Fatalf("Lookup(expired) - unexpected value: %v", v)
+	}
+	if clock.timers != 3 {
+		t.Fatalf("sweep - expected 3 timers got: %d", clock.timers)
+	}
+	clock.Advance(time.Hour)
+	if v, _ := ctx.Lookup("session"); v != nil {
+		t.Fatalf("Lookup(expired) - unexpected value: %v", v)
+	}
+	if v, _ := ctx.Lookup("key"); v != "k" {
+		t.Fatalf("Lookup - expected k got: %v", v)
+	}
+}
@@ -0,0 +1,555 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+// Package filecontext provides a persistent root contextual.Context backed
+// by a local directory.
+//
+// Bind, Unbind, and Rebind ops are appended, with checksums, to a journal
+// file before they are applied. The journal is periodically compacted into
+// a snapshot file. On Open, the snapshot and then the journal are replayed;
+// a torn or corrupt record at the tail of the journal (e.g. due to a crash
+// mid-write) ends the replay and is truncated.
+//
+// Values are encoded with the codec registered for their type (see package
+// codec). Binding a value of a type without a registered codec fails.
+//
+// A file context is a root context. Ordinary in-memory children can be
+// created with contextual.ChildContext.
+package filecontext
+
+import (
+	"fmt"
+	"goerror"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alphazero/contextual"
+	"github.com/alphazero/contextual/codec"
+)
+
+var (
+	JournalError  = goerror.Define("journal error")
+	SnapshotError = goerror.Define("snapshot error")
+)
+
+const (
+	journalFile     = "journal"
+	snapshotFile    = "snapshot"
+	snapshotTmpFile = "snapshot.tmp"
+)
+
+// SyncPolicy determines when journal writes are flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// fsync after every record. A successful op survives a crash.
+	SyncAlways SyncPolicy = iota
+	// fsync periodically per Options.SyncInterval. Ops since the last sync
+	// may be lost on a crash.
+	SyncPeriodic
+	// never fsync; flushing is left to the OS.
+	SyncNever
+)
+
+const (
+	DefaultSyncInterval = time.Second
+	DefaultCompactAfter = 1024
+)
+
+// Options for Open. The zero-value is valid.
+type Options struct {
+	// Sync policy for journal writes. Default is SyncAlways.
+	Sync SyncPolicy
+	// Interval of syncs for SyncPeriodic. Default is DefaultSyncInterval.
+	SyncInterval time.Duration
+	// Number of journal records that triggers compaction. Default is
+	// DefaultCompactAfter. A negative value disables automatic compaction.
+	CompactAfter int
+}
+
+// Context is a persistent contextual.Context.
+type Context interface {
+	contextual.Context
+
+	// Compact writes a snapshot of the context and truncates the journal.
+	Compact() error
+
+	// Sync flushes the journal to stable storage.
+	Sync() error
+
+	// Close syncs and closes the context files. All subsequent ops of the
+	// context return IllegalStateError; Size() is 0.
+	Close() error
+}
+
+// the journal file; a (test) seam for write faults.
+type logFile interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+type fileContext struct {
+	sync.Mutex
+	dir      string
+	opts     Options
+	journal  logFile
+	offset   int64 // of the end of the last record written to the journal
+	torn     bool  // the journal has bytes past offset
+	records  int   // in journal
+	bindings map[string]interface{}
+	closed   bool
+	done     chan struct{}
+}
+
+// Open opens, or creates, the file context in directory dir. opts may be nil.
+//
+// Errors:
+//
+//	IllegalArgumentError <= zero-value dir
+//	SnapshotError <= the snapshot could not be read
+//	JournalError <= the journal could not be read or opened
+func Open(dir string, opts *Options) (Context, error) {
+	if dir == "" {
+		return nil, contextual.IllegalArgumentError("dir is zero-value")
+	}
+	c := &fileContext{
+		dir:      dir,
+		bindings: make(map[string]interface{}),
+		done:     make(chan struct{}),
+	}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.SyncInterval <= 0 {
+		c.opts.SyncInterval = DefaultSyncInterval
+	}
+	if c.opts.CompactAfter == 0 {
+		c.opts.CompactAfter = DefaultCompactAfter
+	}
+
+	if e := os.MkdirAll(dir, 0755); e != nil {
+		return nil, JournalError(dir).WithCause(e)
+	}
+	if e := c.replaySnapshot(); e != nil {
+		return nil, e
+	}
+	if e := c.replayJournal(); e != nil {
+		return nil, e
+	}
+
+	if c.opts.Sync == SyncPeriodic {
+		go c.syncLoop()
+	}
+	return c, nil
+}
+
+func (c *fileContext) path(name string) string {
+	return filepath.Join(c.dir, name)
+}
+
+func (c *fileContext) replaySnapshot() error {
+	f, e := os.Open(c.path(snapshotFile))
+	if os.IsNotExist(e) {
+		return nil
+	} else if e != nil {
+		return SnapshotError().WithCause(e)
+	}
+	defer f.Close()
+
+	// snapshots are written atomically; any fault is a corruption
+	rr := newRecordReader(f)
+	for {
+		r, e := rr.next()
+		if e == io.EOF {
+			return nil
+		} else if e != nil {
+			return SnapshotError(fmt.Sprintf("corrupt record at offset %d", rr.offset))
+		}
+		if e := c.apply(r); e != nil {
+			return SnapshotError().WithCause(e)
+		}
+	}
+}
+
+func (c *fileContext) replayJournal() error {
+	f, e := os.OpenFile(c.path(journalFile), os.O_RDWR|os.O_CREATE, 0644)
+	if e != nil {
+		return JournalError().WithCause(e)
+	}
+
+	rr := newRecordReader(f)
+	for {
+		r, e := rr.next()
+		if e == io.EOF {
+			break
+		} else if e != nil {
+			// torn tail - discard it
+			if e := f.Truncate(rr.offset); e != nil {
+				f.Close()
+				return JournalError("truncate").WithCause(e)
+			}
+			break
+		}
+		if e := c.apply(r); e != nil {
+			f.Close()
+			return JournalError(fmt.Sprintf("record at offset %d", rr.offset)).WithCause(e)
+		}
+		c.records++
+	}
+	if _, e := f.Seek(rr.offset, io.SeekStart); e != nil {
+		f.Close()
+		return JournalError().WithCause(e)
+	}
+	c.journal, c.offset = f, rr.offset
+	return nil
+}
+
+// apply sets the state per the record. Replay of records is idempotent, so
+// the journal may overlap with the snapshot (e.g. on a crash in Compact).
+func (c *fileContext) apply(r *record) error {
+	if r.op == opUnbind {
+		delete(c.bindings, r.name)
+		return nil
+	}
+	v, e := codec.Decode(r.tag, r.value)
+	if e != nil {
+		return e
+	}
+	c.bindings[r.name] = v
+	return nil
+}
+
+// returns the record of the binding, and the value as replay decodes it.
+// The decoded value is bound, so that the binding does not change when the
+// context is reopened, and does not alias the caller's value.
+//
+// Errors:
+//
+//	IllegalArgumentError <= value can not be encoded (e.g. NaN) or decoded
+func encode(o op, name string, value interface{}) (*record, interface{}, error) {
+	tag, b, e := codec.Encode(value)
+	if e != nil {
+		return nil, nil, contextual.IllegalArgumentError(fmt.Sprintf("%s: can not encode value %v", name, value)).WithCause(e)
+	}
+	v, e := codec.Decode(tag, b)
+	if e != nil {
+		return nil, nil, contextual.IllegalArgumentError(fmt.Sprintf("%s: can not decode value %v", name, value)).WithCause(e)
+	}
+	return &record{o, name, tag, b}, v, nil
+}
+
+func (c *fileContext) syncLoop() {
+	ticker := time.NewTicker(c.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Sync()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// appends the record to the journal, and syncs per policy. A failed write
+// is truncated from the journal, so that subsequent records follow the last
+// good record and are replayed.
+// REVU: c must be locked.
+func (c *fileContext) write(r *record) error {
+	if c.torn {
+		if e := c.rewind(); e != nil {
+			return e
+		}
+	}
+	b := r.marshal()
+	if _, e := c.journal.Write(b); e != nil {
+		c.rewind()
+		return JournalError("write").WithCause(e)
+	}
+	if c.opts.Sync == SyncAlways {
+		if e := c.journal.Sync(); e != nil {
+			c.rewind()
+			return JournalError("sync").WithCause(e)
+		}
+	}
+	c.offset += int64(len(b))
+	c.records++
+	return nil
+}
+
+// truncates the journal to the end of the last good record. The journal is
+// torn until a rewind succeeds.
+// REVU: c must be locked.
+func (c *fileContext) rewind() error {
+	c.torn = true
+	if e := c.journal.Truncate(c.offset); e != nil {
+		return JournalError("truncate").WithCause(e)
+	}
+	if _, e := c.journal.Seek(c.offset, io.SeekStart); e != nil {
+		return JournalError().WithCause(e)
+	}
+	c.torn = false
+	return nil
+}
+
+// REVU: c must be locked.
+func (c *fileContext) maybeCompact() {
+	if c.opts.CompactAfter < 0 || c.records < c.opts.CompactAfter {
+		return
+	}
+	// a failed compaction leaves the journal intact and is retried on
+	// the next write.
+	c.compact()
+}
+
+// ----------------------------------------------------------------------------
+// Context API
+// ----------------------------------------------------------------------------
+
+func (c *fileContext) IsRoot() bool {
+	return true
+}
+
+func (c *fileContext) IsEmpty() bool {
+	return c.Size() == 0
+}
+
+func (c *fileContext) Size() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.bindings)
+}
+
+func (c *fileContext) Depth() int {
+	return 0
+}
+
+func (c *fileContext) Lookup(name string) (interface{}, error) {
+	return c.LookupN(name, 0)
+}
+
+func (c *fileContext) LookupN(name string, n int) (interface{}, error) {
+	if name == "" {
+		return nil, contextual.NilNameError()
+	}
+	if n < 0 {
+		return nil, contextual.NegativeNArgError()
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, contextual.IllegalStateError("closed")
+	}
+	return c.bindings[name], nil
+}
+
+// Bind per contextual.Context#Bind. The value that is bound is the value as
+// decoded from its encoding, which is what a reopened context binds.
+//
+// Additional errors:
+//
+//	IllegalArgumentError <= value can not be encoded (e.g. NaN) or decoded
+//	IllegalStateError <= context is closed
+//	JournalError <= the op could not be written to the journal
+func (c *fileContext) Bind(name string, value interface{}) error {
+	if name == "" {
+		return contextual.NilNameError()
+	}
+	if value == nil {
+		return contextual.NilValueError()
+	}
+	r, value, e := encode(opBind, name, value)
+	if e != nil {
+		return e
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return contextual.IllegalStateError("closed")
+	}
+	if v := c.bindings[name]; v != nil {
+		return contextual.AlreadyBoundError(fmt.Sprintf("%s => %v", name, v))
+	}
+	if e := c.write(r); e != nil {
+		return e
+	}
+	c.bindings[name] = value
+	c.maybeCompact()
+	return nil
+}
+
+// Unbind per contextual.Context#Unbind.
+//
+// Additional errors:
+//
+//	IllegalStateError <= context is closed
+//	JournalError <= the op could not be written to the journal
+func (c *fileContext) Unbind(name string) (interface{}, error) {
+	if name == "" {
+		return nil, contextual.NilNameError()
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, contextual.IllegalStateError("closed")
+	}
+	v := c.bindings[name]
+	if v == nil {
+		return nil, contextual.NoSuchBindingError(name)
+	}
+	if e := c.write(&record{op: opUnbind, name: name}); e != nil {
+		return nil, e
+	}
+	delete(c.bindings, name)
+	c.maybeCompact()
+	return v, nil
+}
+
+// Rebind per contextual.Context#Rebind. Rebind is journaled as a single
+// record and is atomic. As with Bind, the decoded value is bound.
+//
+// Additional errors:
+//
+//	IllegalArgumentError <= value can not be encoded (e.g. NaN) or decoded
+//	IllegalStateError <= context is closed
+//	JournalError <= the op could not be written to the journal
+func (c *fileContext) Rebind(name string, value interface{}) (interface{}, error) {
+	if name == "" {
+		return nil, contextual.NilNameError()
+	}
+	if value == nil {
+		return nil, contextual.NilValueError()
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, contextual.IllegalStateError("closed")
+	}
+	v := c.bindings[name]
+	if v == nil {
+		return nil, contextual.NoSuchBindingError(name)
+	}
+	r, value, e := encode(opRebind, name, value)
+	if e != nil {
+		return nil, e
+	}
+	if e := c.write(r); e != nil {
+		return nil, e
+	}
+	c.bindings[name] = value
+	c.maybeCompact()
+	return v, nil
+}
+
+// ----------------------------------------------------------------------------
+// persistence API
+// ----------------------------------------------------------------------------
+
+func (c *fileContext) Compact() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return contextual.IllegalStateError("closed")
+	}
+	return c.compact()
+}
+
+// writes the snapshot to a temp file, atomically replaces the snapshot, and
+// only then truncates the journal.
+// REVU: c must be locked.
+func (c *fileContext) compact() error {
+	tmp := c.path(snapshotTmpFile)
+	f, e := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if e != nil {
+		return SnapshotError().WithCause(e)
+	}
+	for name, v := range c.bindings {
+		tag, b, e := codec.Encode(v)
+		if e == nil {
+			_, e = f.Write((&record{opBind, name, tag, b}).marshal())
+		}
+		if e != nil {
+			f.Close()
+			os.Remove(tmp)
+			return SnapshotError(name).WithCause(e)
+		}
+	}
+	if e := f.Sync(); e != nil {
+		f.Close()
+		os.Remove(tmp)
+		return SnapshotError("sync").WithCause(e)
+	}
+	if e := f.Close(); e != nil {
+		os.Remove(tmp)
+		return SnapshotError().WithCause(e)
+	}
+	if e := os.Rename(tmp, c.path(snapshotFile)); e != nil {
+		os.Remove(tmp)
+		return SnapshotError("rename").WithCause(e)
+	}
+	if e := syncDir(c.dir); e != nil {
+		return SnapshotError("sync dir").WithCause(e)
+	}
+
+	c.offset = 0
+	if e := c.rewind(); e != nil {
+		return e
+	}
+	if e := c.journal.Sync(); e != nil {
+		return JournalError("sync").WithCause(e)
+	}
+	c.records = 0
+	return nil
+}
+
+func (c *fileContext) Sync() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return contextual.IllegalStateError("closed")
+	}
+	if e := c.journal.Sync(); e != nil {
+		return JournalError("sync").WithCause(e)
+	}
+	return nil
+}
+
+func (c *fileContext) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return contextual.IllegalStateError("closed")
+	}
+	c.closed = true
+	close(c.done)
+	c.bindings = make(map[string]interface{})
+
+	e0 := c.journal.Sync()
+	if e := c.journal.Close(); e0 == nil {
+		e0 = e
+	}
+	if e0 != nil {
+		return JournalError("close").WithCause(e0)
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, e := os.Open(dir)
+	if e != nil {
+		return e
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,286 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package filecontext
+
+import (
+	"errors"
+	"goerror"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alphazero/contextual"
+	"github.com/alphazero/contextual/contextualtest"
+)
+
+func open(t *testing.T, dir string, opts *Options) Context {
+	c, e := Open(dir, opts)
+	if e != nil {
+		t.Fatalf("Open - unexpected error: %s", e)
+	}
+	return c
+}
+
+func assertLookup(t *testing.T, c contextual.Context, name string, expected interface{}) {
+	v, e := c.Lookup(name)
+	if e != nil {
+		t.Fatalf("Lookup(%q) - unexpected error: %s", name, e)
+	}
+	if v != expected {
+		t.Fatalf("Lookup(%q) - expected:%v got:%v", name, expected, v)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	contextualtest.RunConformance(t, func(t *testing.T, p contextual.Context) contextual.Context {
+		if p == nil {
+			c := open(t, t.TempDir(), nil)
+			t.Cleanup(func() { c.Close() })
+			return c
+		}
+		c, e := contextual.ChildContext(p)
+		if e != nil {
+			t.Fatalf("ChildContext: %s", e)
+		}
+		return c
+	})
+}
+
+func TestReplay(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncPeriodic, SyncNever} {
+		dir := t.TempDir()
+		c := open(t, dir, &Options{Sync: policy})
+		c.Bind("a", "alpha")
+		c.Bind("b", 2)
+		c.Bind("c", 3.0)
+		c.Unbind("b")
+		c.Rebind("c", int64(4))
+		if e := c.Close(); e != nil {
+			t.Fatalf("Close - unexpected error: %s", e)
+		}
+
+		c = open(t, dir, nil)
+		assertLookup(t, c, "a", "alpha")
+		assertLookup(t, c, "b", nil)
+		assertLookup(t, c, "c", int64(4))
+		if n := c.Size(); n != 2 {
+			t.Fatalf("Size() - expected:2 got:%d", n)
+		}
+		c.Close()
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	dir := t.TempDir()
+	c := open(t, dir, &Options{CompactAfter: 4})
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		if e := c.Bind(name, name); e != nil {
+			t.Fatalf("Bind - unexpected error: %s", e)
+		}
+	}
+	if _, e := os.Stat(filepath.Join(dir, snapshotFile)); e != nil {
+		t.Fatalf("expected snapshot after compaction: %s", e)
+	}
+	fi, _ := os.Stat(filepath.Join(dir, journalFile))
+	if fi.Size() == 0 {
+		t.Fatalf("expected record after compaction in journal")
+	}
+	c.Unbind("a")
+	c.Close()
+
+	c = open(t, dir, nil)
+	defer c.Close()
+	assertLookup(t, c, "a", nil)
+	for _, name := range []string{"b", "c", "d", "e"} {
+		assertLookup(t, c, name, name)
+	}
+	if e := c.Compact(); e != nil {
+		t.Fatalf("Compact - unexpected error: %s", e)
+	}
+	if fi, _ := os.Stat(filepath.Join(dir, journalFile)); fi.Size() != 0 {
+		t.Fatalf("expected empty journal after Compact")
+	}
+}
+
+// a journal overlapping the snapshot replays to the same state.
+func TestReplayOverlap(t *testing.T) {
+	dir := t.TempDir()
+	c := open(t, dir, &Options{CompactAfter: -1})
+	c.Bind("a", "alpha")
+	c.Rebind("a", "beta")
+	c.Bind("b", "bravo")
+	c.Unbind("b")
+	journal, _ := os.ReadFile(filepath.Join(dir, journalFile))
+	c.Compact()
+	c.Close()
+
+	// crash between snapshot rename and journal truncate
+	os.WriteFile(filepath.Join(dir, journalFile), journal, 0644)
+	c = open(t, dir, nil)
+	defer c.Close()
+	assertLookup(t, c, "a", "beta")
+	assertLookup(t, c, "b", nil)
+}
+
+func TestTornJournal(t *testing.T) {
+	dir := t.TempDir()
+	c := open(t, dir, nil)
+	c.Bind("a", "alpha")
+	c.Bind("b", "bravo")
+	c.Close()
+
+	path := filepath.Join(dir, journalFile)
+	journal, _ := os.ReadFile(path)
+	good := len(journal)
+
+	// torn write: partial record at the tail
+	torn := (&record{opBind, "c", "string", []byte(`"charlie"`)}).marshal()
+	os.WriteFile(path, append(journal, torn[:len(torn)-3]...), 0644)
+	c = open(t, dir, nil)
+	assertLookup(t, c, "a", "alpha")
+	assertLookup(t, c, "b", "bravo")
+	assertLookup(t, c, "c", nil)
+	if fi, _ := os.Stat(path); fi.Size() != int64(good) {
+		t.Fatalf("journal size - expected:%d got:%d", good, fi.Size())
+	}
+	// journal is appendable after truncation
+	c.Bind("c", "charlie")
+	c.Close()
+
+	// corrupt checksum of the last record
+	journal, _ = os.ReadFile(path)
+	journal[len(journal)-1] ^= 0xff
+	os.WriteFile(path, journal, 0644)
+	c = open(t, dir, nil)
+	defer c.Close()
+	assertLookup(t, c, "b", "bravo")
+	assertLookup(t, c, "c", nil)
+}
+
+// faultyFile writes half of the bytes of a write, and fails, while faulty;
+// or fails syncs, while failSync.
+type faultyFile struct {
+	*os.File
+	faulty, failSync bool
+}
+
+func (f *faultyFile) Write(b []byte) (int, error) {
+	if f.faulty {
+		n, _ := f.File.Write(b[:len(b)/2])
+		return n, errors.New("disk full")
+	}
+	return f.File.Write(b)
+}
+
+func (f *faultyFile) Sync() error {
+	if f.failSync {
+		return errors.New("i/o error")
+	}
+	return f.File.Sync()
+}
+
+func TestFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	c := open(t, dir, nil)
+	c.Bind("a", "alpha")
+	f := &faultyFile{File: c.(*fileContext).journal.(*os.File)}
+	c.(*fileContext).journal = f
+
+	f.faulty = true
+	if e := c.Bind("b", "bravo"); e == nil || !goerror.TypeOf(e).Is(JournalError) {
+		t.Fatalf("Bind(write fault) - expected error: %s got: %v", JournalError(), e)
+	}
+	f.faulty, f.failSync = false, true
+	if _, e := c.Unbind("a"); e == nil || !goerror.TypeOf(e).Is(JournalError) {
+		t.Fatalf("Unbind(sync fault) - expected error: %s got: %v", JournalError(), e)
+	}
+	f.failSync = false
+	if e := c.Bind("c", "charlie"); e != nil {
+		t.Fatalf("Bind - unexpected error: %s", e)
+	}
+	assertLookup(t, c, "a", "alpha")
+	assertLookup(t, c, "b", nil)
+	c.Close()
+
+	// writes that follow a failed write are replayed
+	c = open(t, dir, nil)
+	defer c.Close()
+	assertLookup(t, c, "a", "alpha")
+	assertLookup(t, c, "b", nil)
+	assertLookup(t, c, "c", "charlie")
+}
+
+func TestDecodedValues(t *testing.T) {
+	dir := t.TempDir()
+	c := open(t, dir, nil)
+
+	// the bound values are those that a reopened context binds
+	b := []byte("alpha")
+	now := time.Now()
+	c.Bind("b", b)
+	c.Bind("t", now)
+	b[0] = 'A'
+	for i := 0; i < 2; i++ {
+		if v, _ := c.Lookup("b"); !reflect.DeepEqual(v, []byte("alpha")) {
+			t.Fatalf("Lookup(b) - expected alpha got: %q", v)
+		}
+		// without the monotonic reading, which is not encoded
+		if v, _ := c.Lookup("t"); v == nil || !v.(time.Time).Equal(now) || v != v.(time.Time).Round(0) {
+			t.Fatalf("Lookup(t) - expected %v got: %v", now.Round(0), v)
+		}
+		c.Close()
+		c = open(t, dir, nil)
+	}
+	c.Close()
+}
+
+func TestErrors(t *testing.T) {
+	if _, e := Open("", nil); e == nil || !goerror.TypeOf(e).Is(contextual.IllegalArgumentError) {
+		t.Fatalf("Open(\"\") - expected error: %s got: %v", contextual.IllegalArgumentError(), e)
+	}
+
+	c := open(t, t.TempDir(), nil)
+	type unregistered struct{}
+	if e := c.Bind("a", unregistered{}); e == nil || !goerror.TypeOf(e).Is(contextual.IllegalArgumentError) {
+		t.Fatalf("Bind(unregistered) - expected error: %s got: %v", contextual.IllegalArgumentError(), e)
+	}
+	for _, v := range []float64{math.NaN(), math.Inf(1)} {
+		if e := c.Bind("a", v); e == nil || !goerror.TypeOf(e).Is(contextual.IllegalArgumentError) {
+			t.Fatalf("Bind(%v) - expected error: %s got: %v", v, contextual.IllegalArgumentError(), e)
+		}
+	}
+	if c.Size() != 0 {
+		t.Fatalf("faulted Bind modified the context")
+	}
+
+	c.Close()
+	if e := c.Bind("a", "alpha"); e == nil || !goerror.TypeOf(e).Is(contextual.IllegalStateError) {
+		t.Fatalf("Bind(closed) - expected error: %s got: %v", contextual.IllegalStateError(), e)
+	}
+	if e := c.Close(); e == nil || !goerror.TypeOf(e).Is(contextual.IllegalStateError) {
+		t.Fatalf("Close(closed) - expected error: %s got: %v", contextual.IllegalStateError(), e)
+	}
+}
+
+func TestChildren(t *testing.T) {
+	dir := t.TempDir()
+	c := open(t, dir, nil)
+	c.Bind("registry.addr", "localhost:7000")
+
+	child, _ := contextual.ChildContext(c)
+	child.Bind("registry.addr", "localhost:7001")
+	child.Bind("local", true)
+	assertLookup(t, child, "registry.addr", "localhost:7001")
+	c.Close()
+
+	// child bindings are not persisted
+	c = open(t, dir, nil)
+	defer c.Close()
+	assertLookup(t, c, "registry.addr", "localhost:7000")
+	assertLookup(t, c, "local", nil)
+}
@@ -0,0 +1,124 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package filecontext
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// journal and snapshot files are a sequence of records:
+//
+//	record:  [payload-len uint32][crc32c(payload) uint32][payload]
+//	payload: [op byte][uvarint len][name][uvarint len][codec tag][value]
+//
+// all integers are big-endian. The value of an opUnbind record is empty.
+
+type op byte
+
+const (
+	opBind op = iota + 1
+	opUnbind
+	opRebind
+)
+
+const recordHeaderSize = 8
+
+// upper bound on payload size, to guard replay against garbage lengths.
+const maxPayloadSize = 1 << 28
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type record struct {
+	op    op
+	name  string
+	tag   string
+	value []byte
+}
+
+func (r *record) marshal() []byte {
+	payload := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(r.name)+len(r.tag)+len(r.value))
+	payload = append(payload, byte(r.op))
+	payload = binary.AppendUvarint(payload, uint64(len(r.name)))
+	payload = append(payload, r.name...)
+	payload = binary.AppendUvarint(payload, uint64(len(r.tag)))
+	payload = append(payload, r.tag...)
+	payload = append(payload, r.value...)
+
+	b := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(b[4:8], crc32.Checksum(payload, crcTable))
+	return append(b, payload...)
+}
+
+func (r *record) unmarshal(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+	r.op, payload = op(payload[0]), payload[1:]
+	if r.op < opBind || r.op > opRebind {
+		return false
+	}
+	var ok bool
+	if r.name, payload, ok = readString(payload); !ok || r.name == "" {
+		return false
+	}
+	if r.tag, payload, ok = readString(payload); !ok {
+		return false
+	}
+	r.value = payload
+	return true
+}
+
+func readString(b []byte) (string, []byte, bool) {
+	n, k := binary.Uvarint(b)
+	if k <= 0 || uint64(len(b)-k) < n {
+		return "", nil, false
+	}
+	b = b[k:]
+	return string(b[:n]), b[n:], true
+}
+
+// recordReader reads records and tracks the offset of the end of the last
+// valid record read.
+type recordReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{r: bufio.NewReader(r)}
+}
+
+// next returns the next record. Returns io.EOF at a clean end of input, and
+// io.ErrUnexpectedEOF for a truncated or corrupt record.
+func (rr *recordReader) next() (*record, error) {
+	var header [recordHeaderSize]byte
+	if _, e := io.ReadFull(rr.r, header[:]); e != nil {
+		if e == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > maxPayloadSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, size)
+	if _, e := io.ReadFull(rr.r, payload); e != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	r := &record{}
+	if !r.unmarshal(payload) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	rr.offset += int64(recordHeaderSize) + int64(size)
+	return r, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+)
+
+// ShadowingPolicy is the policy of a context on bindings that shadow those
+// of its ancestors. Final bindings may not be shadowed regardless.
+type ShadowingPolicy int
+
+const (
+	AllowShadowing ShadowingPolicy = iota
+	ForbidShadowing
+	WarnShadowing
+)
+
+func (p ShadowingPolicy) String() string {
+	switch p {
+	case AllowShadowing:
+		return "allow"
+	case ForbidShadowing:
+		return "forbid"
+	case WarnShadowing:
+		return "warn"
+	}
+	return fmt.Sprintf("policy(%d)", int(p))
+}
+
+// WithShadowing sets the shadowing policy of the context, and of the
+// children that are subsequently created of it. Under WarnShadowing, the
+// warn func is called after a binding shadows the value of an ancestor. The
+// default is the policy of the parent, if an in-memory context, and
+// otherwise AllowShadowing.
+//
+// Bindings of the names that the package binds (BusBinding,
+// FailureReporterBinding, and those of the service registry), which are
+// shadowed by design, are exempt from the policy and from final bindings.
+// Other names, even of the same "contextual." prefix, are not.
+func WithShadowing(policy ShadowingPolicy, warn func(ctx Context, name string, shadowed interface{})) ContextOption {
+	return func(c *context) {
+		c.shadowing, c.warn = policy, warn
+	}
+}
+
+// BindFinal binds the value as a final binding. See FinalBinder.
+func (c *context) BindFinal(name string, value interface{}) error {
+	return c.bindWith(name, value, func(name string) {
+		c.meta[name].final = true
+	})
+}
+
+func (c *context) IsFinal(name string) bool {
+	key, e := c.checkName(name, false)
+	if e != nil {
+		return false
+	}
+	c.RLock()
+	m, masked := c.meta[key], c.masks[key]
+	_, bound := c.get(key)
+	final := m != nil && m.final && bound
+	c.RUnlock()
+	if final || masked {
+		return final
+	}
+	if p, ok := c.parent.(FinalBinder); ok {
+		return p.IsFinal(key)
+	}
+	return false
+}
+
+// checks that a binding of the name in the context may shadow the binding
+// visible from its parent, and returns the func that warns of the shadowing
+// after the binding, if any.
+// REVU: c must not be locked.
+func (c *context) mayShadow(name string) (warn func(), e error) {
+	if c.parent == nil || reserved(name) {
+		return nil, nil
+	}
+	if p, ok := c.parent.(FinalBinder); ok && p.IsFinal(name) {
+		return nil, ShadowingForbiddenError(fmt.Sprintf("%s is final", name))
+	}
+
+	c.RLock()
+	policy, fn, masked := c.shadowing, c.warn, c.masks[name]
+	c.RUnlock()
+	if policy == AllowShadowing || masked {
+		return nil, nil
+	}
+	shadowed, _ := c.parent.Lookup(name)
+	switch {
+	case shadowed == nil:
+		return nil, nil
+	case policy == ForbidShadowing:
+		return nil, ShadowingForbiddenError(name)
+	case fn != nil:
+		return func() { fn(c, name, shadowed) }, nil
+	}
+	return nil, nil
+}
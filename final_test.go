@@ -0,0 +1,95 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// testing: final bindings and shadowing policies
+// ============================================================================
+
+func TestBindFinal(t *testing.T) {
+	root := NewContext()
+	child, _ := ChildContext(root)
+	grandchild, _ := ChildContext(child)
+
+	if e := root.(FinalBinder).BindFinal("security.tls.required", true); e != nil {
+		t.Fatalf("BindFinal - unexpected error: %s", e)
+	}
+	root.Bind("log.level", "info")
+
+	e := grandchild.Bind("security.tls.required", false)
+	if !IsError(e, ShadowingForbiddenError) || !IsError(e, AlreadyBoundError) {
+		t.Fatalf("Bind(final) - expected error: %s got: %v", ShadowingForbiddenError(), e)
+	}
+	assertError(t, "BindTTL(final)", child.(Expiring).BindTTL("security.tls.required", false, 1), ShadowingForbiddenError)
+	if e := grandchild.Bind("log.level", "debug"); e != nil {
+		t.Fatalf("Bind(not final) - unexpected error: %s", e)
+	}
+	if !grandchild.(FinalBinder).IsFinal("security.tls.required") || grandchild.(FinalBinder).IsFinal("log.level") {
+		t.Fatalf("IsFinal - unexpected")
+	}
+	if info, _ := grandchild.(Describable).Describe("security.tls.required"); !info.Final {
+		t.Fatalf("Describe - final binding is not described as final")
+	}
+
+	// lookups are unchanged; rebinding keeps, and unbinding removes, finality
+	if v, _ := grandchild.LookupN("security.tls.required", 2); v != true {
+		t.Fatalf("LookupN - unexpected: %v", v)
+	}
+	root.Rebind("security.tls.required", false)
+	assertError(t, "Bind(rebound final)", child.Bind("security.tls.required", true), ShadowingForbiddenError)
+	root.Unbind("security.tls.required")
+	if e := child.Bind("security.tls.required", true); e != nil {
+		t.Fatalf("Bind(unbound final) - unexpected error: %s", e)
+	}
+
+	// only the names that the package binds are exempt
+	root.(FinalBinder).BindFinal("contextual.tls", true)
+	assertError(t, "Bind(final, package prefix)", child.Bind("contextual.tls", false), ShadowingForbiddenError)
+}
+
+func TestShadowingPolicy(t *testing.T) {
+	type warning struct {
+		ctx      Context
+		name     string
+		shadowed interface{}
+	}
+	var warnings []warning
+	root := NewContext(WithShadowing(WarnShadowing, func(ctx Context, name string, shadowed interface{}) {
+		warnings = append(warnings, warning{ctx, name, shadowed})
+	}))
+	root.Bind("a", 1)
+	child, _ := ChildContext(root)
+	if e := child.Bind("a", 2); e != nil {
+		t.Fatalf("Bind(warn) - unexpected error: %s", e)
+	}
+	child.Bind("b", 1)
+	if len(warnings) != 1 || warnings[0] != (warning{child, "a", 1}) {
+		t.Fatalf("WarnShadowing - unexpected: %v", warnings)
+	}
+
+	strict, _ := ChildContext(child, WithShadowing(ForbidShadowing, nil))
+	assertError(t, "Bind(forbidden)", strict.Bind("a", 3), ShadowingForbiddenError)
+	assertError(t, "Bind(forbidden)", strict.Bind("b", 3), ShadowingForbiddenError)
+	if e := strict.Bind("c", 3); e != nil {
+		t.Fatalf("Bind(not shadowing) - unexpected error: %s", e)
+	}
+	strictChild, _ := ChildContext(strict)
+	assertError(t, "Bind(inherited policy)", strictChild.Bind("c", 4), ShadowingForbiddenError)
+	if e := strictChild.Bind(BusBinding, 4); e != nil {
+		t.Fatalf("Bind(reserved name) - unexpected error: %s", e)
+	}
+
+	lax, _ := ChildContext(strict, WithShadowing(AllowShadowing, nil))
+	if e := lax.Bind("a", 5); e != nil {
+		t.Fatalf("Bind(allowed) - unexpected error: %s", e)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("WarnShadowing - unexpected: %v", warnings)
+	}
+}
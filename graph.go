@@ -0,0 +1,227 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// A provider is bound in the context under the ProviderBinding of each of
+// the types that its constructor produces. Providers are constructed at most
+// once, lazily, with the parameters of the constructor resolved from the
+// context of the provider.
+type provider struct {
+	context Context
+	ctor    reflect.Value
+	sync.Mutex
+	values []reflect.Value // constructed values, by constructor result
+}
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*Context)(nil)).Elem()
+)
+
+var providerKeys = struct {
+	sync.Mutex
+	byType map[reflect.Type]string
+	byKey  map[string]reflect.Type
+}{byType: make(map[reflect.Type]string), byKey: make(map[string]reflect.Type)}
+
+// ProviderBinding returns the name of the binding of the provider of the
+// type. Distinct types with the same string representation have distinct
+// binding names.
+func ProviderBinding(t reflect.Type) string {
+	providerKeys.Lock()
+	defer providerKeys.Unlock()
+	if key, ok := providerKeys.byType[t]; ok {
+		return key
+	}
+	key := "provider." + t.String()
+	for n := 2; providerKeys.byKey[key] != nil; n++ {
+		key = fmt.Sprintf("provider.%s#%d", t, n)
+	}
+	providerKeys.byType[t] = key
+	providerKeys.byKey[key] = t
+	return key
+}
+
+// Provide registers the constructor as the provider, in the context, of the
+// types that it returns. The constructor is a function that may take any
+// number of parameters, which are resolved as in Invoke, and returns one or
+// more values, optionally followed by an error.
+//
+// Providers registered in a child context override those of its ancestors.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx is nil, or constructor is not a valid function
+//	AlreadyBoundError <= a provider of a type is already registered in ctx
+func Provide(ctx Context, constructor interface{}) error {
+	if ctx == nil {
+		return IllegalArgumentError("context is nil")
+	}
+	fn := reflect.ValueOf(constructor)
+	if fn.Kind() != reflect.Func || fn.IsNil() {
+		return IllegalArgumentError(fmt.Sprintf("constructor is %T; expected a function", constructor))
+	}
+	types := provided(fn.Type())
+	if len(types) == 0 {
+		return IllegalArgumentError(fmt.Sprintf("constructor %s provides nothing", fn.Type()))
+	}
+	for i, t := range types {
+		if t == errorType {
+			return IllegalArgumentError(fmt.Sprintf("constructor %s: error result %d is not last", fn.Type(), i))
+		}
+		for _, t0 := range types[:i] {
+			if t0 == t {
+				return IllegalArgumentError(fmt.Sprintf("constructor %s: %s is provided twice", fn.Type(), t))
+			}
+		}
+	}
+
+	p := &provider{context: ctx, ctor: fn}
+	var bound []string
+	for _, t := range types {
+		if e := ctx.Bind(ProviderBinding(t), p); e != nil {
+			for _, name := range bound {
+				ctx.Unbind(name)
+			}
+			return e
+		}
+		bound = append(bound, ProviderBinding(t))
+	}
+	return nil
+}
+
+// returns the types provided by a constructor of type t.
+func provided(t reflect.Type) []reflect.Type {
+	n := t.NumOut()
+	if n > 0 && t.Out(n-1) == errorType {
+		n--
+	}
+	types := make([]reflect.Type, n)
+	for i := range types {
+		types[i] = t.Out(i)
+	}
+	return types
+}
+
+// Invoke calls the function with its parameters resolved from the context
+// hierarchy. A parameter of type Context is the context itself; any other
+// parameter is the value of the nearest provider of its type, which is
+// constructed as necessary. If the function returns an error as its last
+// result, that error is returned.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx is nil, or fn is not a function
+//	NoSuchBindingError <= no provider of a (transitive) parameter type
+//	DependencyCycleError <= providers depend on each other
+//	DependencyError <= a constructor failed
+func Invoke(ctx Context, fn interface{}) error {
+	if ctx == nil {
+		return IllegalArgumentError("context is nil")
+	}
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func || f.IsNil() {
+		return IllegalArgumentError(fmt.Sprintf("fn is %T; expected a function", fn))
+	}
+	args, e := resolve(ctx, f.Type(), nil)
+	if e != nil {
+		return e
+	}
+	results := f.Call(args)
+	if n := len(results); n > 0 && f.Type().Out(n-1) == errorType && !results[n-1].IsNil() {
+		return results[n-1].Interface().(error)
+	}
+	return nil
+}
+
+// a step of a resolution path: the type, and its provider.
+type step struct {
+	t reflect.Type
+	p *provider
+}
+
+// resolves the parameters of a function of type t. The path is the chain of
+// types under construction.
+func resolve(ctx Context, t reflect.Type, path []step) ([]reflect.Value, error) {
+	args := make([]reflect.Value, t.NumIn())
+	for i := range args {
+		pt := t.In(i)
+		if pt == contextType {
+			args[i] = reflect.ValueOf(&ctx).Elem()
+			continue
+		}
+		v, e := construct(ctx, pt, path)
+		if e != nil {
+			return nil, e
+		}
+		args[i] = v
+	}
+	return args, nil
+}
+
+// returns the value of type t of the nearest provider.
+func construct(ctx Context, t reflect.Type, path []step) (reflect.Value, error) {
+	v, e := ctx.Lookup(ProviderBinding(t))
+	if e != nil {
+		return reflect.Value{}, e
+	}
+	p, ok := v.(*provider)
+	if !ok {
+		return reflect.Value{}, NoSuchBindingError(fmt.Sprintf("provider of %s (%s)", t, pathString(append(path, step{t, nil}))))
+	}
+	// a provider under construction is on the path
+	for i, s := range path {
+		if s.p == p {
+			return reflect.Value{}, DependencyCycleError(pathString(append(path[i:], step{t, p})))
+		}
+	}
+	path = append(path, step{t, p})
+
+	// the parameters are resolved with the provider unlocked, and so the
+	// constructor is called with no lock but that of its provider held.
+	p.Lock()
+	constructed := p.values != nil
+	p.Unlock()
+	var args []reflect.Value
+	if !constructed {
+		if args, e = resolve(p.context, p.ctor.Type(), path); e != nil {
+			return reflect.Value{}, e
+		}
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if p.values == nil {
+		results := p.ctor.Call(args)
+		if n := len(results); p.ctor.Type().Out(n-1) == errorType {
+			if !results[n-1].IsNil() {
+				return reflect.Value{}, DependencyError(fmt.Sprintf("constructor of %s (%s)", t, pathString(path))).WithCause(results[n-1].Interface().(error))
+			}
+			results = results[:n-1]
+		}
+		p.values = results
+	}
+	for i, rt := range provided(p.ctor.Type()) {
+		if rt == t {
+			return p.values[i], nil
+		}
+	}
+	return reflect.Value{}, IllegalStateError(fmt.Sprintf("provider of %s does not provide it", t))
+}
+
+func pathString(path []step) string {
+	s := make([]string, len(path))
+	for i, step := range path {
+		s[i] = step.t.String()
+	}
+	return strings.Join(s, " -> ")
+}
@@ -0,0 +1,147 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// testing: dependency graph
+// ============================================================================
+
+type (
+	config  struct{ dsn string }
+	pool    struct{ config *config }
+	handler struct{ pool *pool }
+	cycleA  struct{}
+	cycleB  struct{}
+	cycleC  struct{}
+)
+
+func TestProvideInvoke(t *testing.T) {
+	root := NewContext()
+	constructed := make(map[string]int)
+
+	Provide(root, func() *config {
+		constructed["config"]++
+		return &config{dsn: "root"}
+	})
+	Provide(root, func(c *config) (*pool, error) {
+		constructed["pool"]++
+		return &pool{c}, nil
+	})
+	Provide(root, func(p *pool, ctx Context) *handler {
+		constructed["handler"]++
+		if ctx != root {
+			t.Fatalf("Provide - constructor context is not the provider's context")
+		}
+		return &handler{p}
+	})
+	if len(constructed) != 0 {
+		t.Fatalf("Provide - providers are not lazy")
+	}
+
+	var h *handler
+	e := Invoke(root, func(h0 *handler, p *pool) {
+		if h0.pool != p {
+			t.Fatalf("Invoke - provider is constructed more than once")
+		}
+		h = h0
+	})
+	if e != nil {
+		t.Fatalf("Invoke - unexpected error: %s", e)
+	}
+	if h.pool.config.dsn != "root" {
+		t.Fatalf("Invoke - expected dsn:root got:%s", h.pool.config.dsn)
+	}
+	Invoke(root, func(*handler) {})
+	for name, n := range constructed {
+		if n != 1 {
+			t.Fatalf("%s - constructed %d times", name, n)
+		}
+	}
+
+	// child contexts override providers of their ancestors; providers are
+	// constructed in their own context.
+	child, _ := ChildContext(root)
+	Provide(child, func() *config { return &config{dsn: "child"} })
+	Invoke(child, func(c *config, h0 *handler) {
+		if c.dsn != "child" {
+			t.Fatalf("Invoke(child) - expected dsn:child got:%s", c.dsn)
+		}
+		if h0 != h {
+			t.Fatalf("Invoke(child) - ancestor provider is reconstructed")
+		}
+	})
+
+	// errors of the invoked function are returned
+	oops := errors.New("oops")
+	if e := Invoke(root, func(*config) error { return oops }); e != oops {
+		t.Fatalf("Invoke - expected error:%s got:%v", oops, e)
+	}
+}
+
+func TestInvokeErrors(t *testing.T) {
+	ctx := NewContext()
+	Provide(ctx, func(*cycleB) *cycleA { return nil })
+	Provide(ctx, func(*cycleC) *cycleB { return nil })
+	Provide(ctx, func(*cycleA) *cycleC { return nil })
+
+	e := Invoke(ctx, func(*cycleB) {})
+	if !IsError(e, DependencyCycleError) || !IsError(e, DependencyError) {
+		t.Fatalf("Invoke(cycle) - expected error: %s got: %v", DependencyCycleError(), e)
+	}
+	path := "*contextual.cycleB -> *contextual.cycleC -> *contextual.cycleA -> *contextual.cycleB"
+	if !strings.Contains(e.Error(), path) {
+		t.Fatalf("Invoke(cycle) - expected path: %s got: %s", path, e)
+	}
+
+	assertError(t, "Invoke(no provider)", Invoke(ctx, func(*handler) {}), NoSuchBindingError)
+
+	Provide(ctx, func() (*config, error) { return nil, errors.New("no dsn") })
+	assertError(t, "Invoke(constructor error)", Invoke(ctx, func(*config) {}), DependencyError)
+
+	assertError(t, "Provide(dup)", Provide(ctx, func() *cycleA { return nil }), AlreadyBoundError)
+	assertError(t, "Provide(non-func)", Provide(ctx, 42), IllegalArgumentError)
+	assertError(t, "Provide(nothing)", Provide(ctx, func() error { return nil }), IllegalArgumentError)
+	assertError(t, "Provide(error first)", Provide(ctx, func() (error, *pool) { return nil, nil }), IllegalArgumentError)
+	assertError(t, "Invoke(non-func)", Invoke(ctx, "f"), IllegalArgumentError)
+	assertError(t, "Invoke(nil context)", Invoke(nil, func() {}), IllegalArgumentError)
+}
+
+func TestConcurrentCycle(t *testing.T) {
+	ctx := NewContext()
+	entered, gate := make(chan struct{}), make(chan struct{})
+	Provide(ctx, func() *cycleC {
+		close(entered)
+		<-gate
+		return &cycleC{}
+	})
+	Provide(ctx, func(*cycleC, *cycleB) *cycleA { return nil })
+	Provide(ctx, func(*cycleA) *cycleB { return nil })
+
+	// a cycle that is resolved concurrently from both of its ends fails on
+	// both, rather than deadlocking on the locks of the providers
+	errs := make(chan error, 2)
+	go func() { errs <- Invoke(ctx, func(*cycleA) {}) }()
+	<-entered
+	go func() { errs <- Invoke(ctx, func(*cycleB) {}) }()
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-errs:
+			if !IsError(e, DependencyCycleError) {
+				t.Fatalf("Invoke(cycle) - expected error: %s got: %v", DependencyCycleError(), e)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Invoke(cycle) - deadlocked")
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HealthStatus is the health status of a component. Statuses are ordered
+// from best to worst.
+type HealthStatus int
+
+const (
+	HealthUp HealthStatus = iota
+	HealthDegraded
+	HealthDown
+)
+
+var healthNames = [...]string{
+	HealthUp:       "up",
+	HealthDegraded: "degraded",
+	HealthDown:     "down",
+}
+
+func (s HealthStatus) String() string {
+	if s < 0 || int(s) >= len(healthNames) {
+		return fmt.Sprintf("health(%d)", int(s))
+	}
+	return healthNames[s]
+}
+
+func (s HealthStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *HealthStatus) UnmarshalText(b []byte) error {
+	for i, name := range healthNames {
+		if name == string(b) {
+			*s = HealthStatus(i)
+			return nil
+		}
+	}
+	return IllegalArgumentError(fmt.Sprintf("health status %q", b))
+}
+
+// HealthReport is the health of a component, and of its components if it is
+// a container.
+type HealthReport struct {
+	Status     HealthStatus            `json:"status"`
+	Reason     string                  `json:"reason,omitempty"`
+	Components map[string]HealthReport `json:"components,omitempty"`
+}
+
+// HealthChecker components check their health. The health of components
+// that are not HealthCheckers is that of their lifecycle state.
+type HealthChecker interface {
+	CheckHealth() HealthReport
+}
+
+// HealthInterval enables periodic health checks of a started container.
+func HealthInterval(d time.Duration) ContainerOption {
+	return func(c *container) { c.healthInterval = d }
+}
+
+// CheckHealth checks the health of the components of the container. A
+// component that is not started is down, and a started component is up,
+// unless it is a HealthChecker. The container has the worst status of its
+// components, and the reason lists the components that are not up. An
+// empty container is up.
+func (c *container) CheckHealth() HealthReport {
+	entries := c.entriesIn(StateNew, StateInitialized, StateStarted, StateStopped, StateDestroyed)
+	report := HealthReport{Components: make(map[string]HealthReport, len(entries))}
+	var unhealthy []string
+	for _, e := range entries {
+		r := checkHealth(c, e)
+		report.Components[e.name] = r
+		if r.Status > report.Status {
+			report.Status = r.Status
+		}
+		if r.Status != HealthUp {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s: %s", e.name, r.Status))
+		}
+	}
+	report.Reason = strings.Join(unhealthy, ", ")
+	return report
+}
+
+// returns the health of the entry.
+func checkHealth(c *container, e *entry) HealthReport {
+	c.Lock()
+	state := e.state
+	c.Unlock()
+	if state != StateStarted {
+		return HealthReport{Status: HealthDown, Reason: state.String()}
+	}
+	if checker, ok := e.component.(HealthChecker); ok {
+		return checker.CheckHealth()
+	}
+	return HealthReport{Status: HealthUp}
+}
+
+func (c *container) Health() HealthReport {
+	c.Lock()
+	health := c.health
+	c.Unlock()
+	if health != nil {
+		return *health
+	}
+	return c.CheckHealth()
+}
+
+// starts periodic health checks, if enabled.
+func (c *container) startHealthChecks() {
+	c.Lock()
+	defer c.Unlock()
+	if c.healthInterval <= 0 || c.healthStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	c.healthStop = stop
+	go func() {
+		ticker := time.NewTicker(c.healthInterval)
+		defer ticker.Stop()
+		for {
+			report := c.CheckHealth()
+			c.Lock()
+			if c.healthStop == stop {
+				c.health = &report
+			}
+			c.Unlock()
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stops periodic health checks, and discards the latest report.
+func (c *container) stopHealthChecks() {
+	c.Lock()
+	defer c.Unlock()
+	if c.healthStop != nil {
+		close(c.healthStop)
+		c.healthStop = nil
+	}
+	c.health = nil
+}
+
+// LivenessHandler serves the health report of the container as JSON. The
+// response status is 200 (OK) unless the container is down, and 503
+// (Service Unavailable) otherwise.
+func LivenessHandler(c Container) http.Handler {
+	return healthHandler(c, HealthDegraded)
+}
+
+// ReadinessHandler serves the health report of the container as JSON. The
+// response status is 200 (OK) if the container is up, and 503 (Service
+// Unavailable) otherwise.
+func ReadinessHandler(c Container) http.Handler {
+	return healthHandler(c, HealthUp)
+}
+
+// serves the health report; the status is OK if the health is at least as
+// good as the threshold.
+func healthHandler(c Container, threshold HealthStatus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Health()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status > threshold {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,146 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// testing: health checks
+// ============================================================================
+
+// helper - a component with settable health
+type healthComponent struct {
+	testComponent
+	sync.Mutex
+	report HealthReport
+	checks int
+}
+
+func (c *healthComponent) CheckHealth() HealthReport {
+	c.Lock()
+	defer c.Unlock()
+	c.checks++
+	return c.report
+}
+
+func (c *healthComponent) set(status HealthStatus, reason string) {
+	c.Lock()
+	c.report = HealthReport{Status: status, Reason: reason}
+	c.Unlock()
+}
+
+func assertHealth(t *testing.T, report HealthReport, expected HealthStatus) {
+	if report.Status != expected {
+		t.Fatalf("health - expected:%s got:%s (%+v)", expected, report.Status, report)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	c, _ := newTestContainer(t)
+	db := &healthComponent{testComponent: testComponent{name: "db"}}
+	c.Add(db)
+	nested := NewContainer()
+	c.AddNamed("nested", nested)
+	nested.Add(&testComponent{name: "plain"})
+
+	// components that are not started are down
+	report := c.CheckHealth()
+	assertHealth(t, report, HealthDown)
+	if r := report.Components["nested"]; r.Status != HealthDown || r.Reason != "new" {
+		t.Fatalf("health - expected down (new) got: %+v", r)
+	}
+
+	c.Start()
+	defer c.Stop()
+	assertHealth(t, c.CheckHealth(), HealthUp)
+
+	db.set(HealthDegraded, "replica lag")
+	report = c.CheckHealth()
+	assertHealth(t, report, HealthDegraded)
+	if report.Reason != "db: degraded" || report.Components["db"].Reason != "replica lag" {
+		t.Fatalf("health - unexpected reasons: %+v", report)
+	}
+
+	nested.Transition("plain", StateStopped)
+	report = c.CheckHealth()
+	assertHealth(t, report, HealthDown)
+	assertHealth(t, report.Components["nested"], HealthDown)
+	if report.Reason != "db: degraded, nested: down" {
+		t.Fatalf("health - unexpected reason: %s", report.Reason)
+	}
+}
+
+func TestPeriodicHealthChecks(t *testing.T) {
+	c := NewContainer(HealthInterval(time.Hour))
+	c.SetContext(NewContext())
+	db := &healthComponent{testComponent: testComponent{name: "db"}}
+	c.Add(db)
+	c.Start()
+
+	// the first check runs on start
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c.(*container).Lock()
+		checked := c.(*container).health != nil
+		c.(*container).Unlock()
+		if checked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Health - periodic check did not run")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	db.set(HealthDown, "no connection")
+	assertHealth(t, c.Health(), HealthUp)
+	assertHealth(t, c.CheckHealth(), HealthDown)
+
+	// reports are discarded on stop
+	c.Stop()
+	assertHealth(t, c.Health(), HealthDown)
+}
+
+func TestHealthHandlers(t *testing.T) {
+	c, _ := newTestContainer(t)
+	db := &healthComponent{testComponent: testComponent{name: "db"}}
+	c.Add(db)
+	c.Start()
+	defer c.Stop()
+
+	get := func(h http.Handler) (int, HealthReport) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+		var report HealthReport
+		if e := json.Unmarshal(w.Body.Bytes(), &report); e != nil {
+			t.Fatalf("health handler - invalid JSON: %s", e)
+		}
+		return w.Code, report
+	}
+
+	for _, test := range []struct {
+		status          HealthStatus
+		liveness, ready int
+	}{
+		{HealthUp, 200, 200},
+		{HealthDegraded, 200, 503},
+		{HealthDown, 503, 503},
+	} {
+		db.set(test.status, "")
+		if code, report := get(LivenessHandler(c)); code != test.liveness || report.Components["db"].Status != test.status {
+			t.Fatalf("liveness(%s) - expected:%d got:%d %+v", test.status, test.liveness, code, report)
+		}
+		if code, _ := get(ReadinessHandler(c)); code != test.ready {
+			t.Fatalf("readiness(%s) - expected:%d got:%d", test.status, test.ready, code)
+		}
+	}
+}
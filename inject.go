@@ -0,0 +1,210 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InjectTag is the struct tag key of injected fields.
+//
+// The tag value is the binding name, optionally followed by the modifiers
+// "optional", and "default=<value>":
+//
+//	type Store struct {
+//		DB      *sql.DB       `contextual:"db.primary"`
+//		Replica *sql.DB       `contextual:"db.replica,optional"`
+//		Timeout time.Duration `contextual:"db.timeout,default=5s"`
+//	}
+//
+// A required field that is not bound, or is masked (see Maskable), is an
+// error. An optional field that is not bound is left as is. A field with a
+// default is optional; the default is parsed per the field's type, which
+// must be a string, bool, numeric, or time.Duration type.
+const InjectTag = "contextual"
+
+// an injected field of a struct
+type injection struct {
+	field    reflect.StructField
+	name     string
+	optional bool
+	def      *string
+}
+
+// Inject resolves the tagged fields of the struct pointed to by target via
+// Lookup on the context, and assigns them. The bound value must be
+// assignable to the field. All missing and mistyped dependencies are
+// reported at once, as the ErrorList cause of the returned error; fields are
+// not assigned if there are any errors.
+//
+// Containers inject their struct components on initialization, rather than
+// when the component's context is set: Start initializes a component once
+// the components it depends on are started, so that the bindings they
+// provide can be injected (see Dependent). A component that is never
+// initialized is not injected.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx is nil, or target is not a struct pointer
+//	InjectionError <= dependencies are missing or mistyped, or tags are invalid
+func Inject(ctx Context, target interface{}) error {
+	if ctx == nil {
+		return IllegalArgumentError("context is nil")
+	}
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return IllegalArgumentError(fmt.Sprintf("target is %T; expected a struct pointer", target))
+	}
+	v = v.Elem()
+
+	var errors ErrorList
+	values := make(map[int]reflect.Value)
+	for i := 0; i < v.NumField(); i++ {
+		inj, e := parseInjection(v.Type().Field(i))
+		if e != nil {
+			errors = append(errors, e)
+			continue
+		}
+		if inj == nil {
+			continue
+		}
+		value, e := inj.resolve(ctx)
+		if e != nil {
+			errors = append(errors, e)
+			continue
+		}
+		if value.IsValid() {
+			values[i] = value
+		}
+	}
+	if len(errors) > 0 {
+		return InjectionError(fmt.Sprintf("%s: %d error(s)", v.Type(), len(errors))).WithCause(errors)
+	}
+	for i, value := range values {
+		v.Field(i).Set(value)
+	}
+	return nil
+}
+
+// returns true if the component is a struct pointer with injected fields.
+func injectable(comp interface{}) bool {
+	t := reflect.TypeOf(comp)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	t = t.Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if tag, ok := t.Field(i).Tag.Lookup(InjectTag); ok && tag != "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the injection of the field, or nil if the field is not tagged.
+func parseInjection(f reflect.StructField) (*injection, error) {
+	tag, ok := f.Tag.Lookup(InjectTag)
+	if !ok || tag == "-" {
+		return nil, nil
+	}
+	inj := &injection{field: f}
+	parts := strings.Split(tag, ",")
+	inj.name = strings.TrimSpace(parts[0])
+	if inj.name == "" {
+		return nil, IllegalArgumentError(fmt.Sprintf("field %s: binding name is not specified", f.Name))
+	}
+	for _, mod := range parts[1:] {
+		mod = strings.TrimSpace(mod)
+		switch {
+		case mod == "optional":
+			inj.optional = true
+		case strings.HasPrefix(mod, "default="):
+			def := strings.TrimPrefix(mod, "default=")
+			inj.def = &def
+		default:
+			return nil, IllegalArgumentError(fmt.Sprintf("field %s: unknown modifier %q", f.Name, mod))
+		}
+	}
+	if f.PkgPath != "" {
+		return nil, IllegalArgumentError(fmt.Sprintf("field %s: field is not exported", f.Name))
+	}
+	return inj, nil
+}
+
+// returns the value to assign to the field, or the zero Value if the field
+// is to be left as is.
+func (inj *injection) resolve(ctx Context) (reflect.Value, error) {
+	t := inj.field.Type
+	v, e := lookup(ctx, inj.name)
+	if e != nil {
+		return reflect.Value{}, e
+	}
+	switch {
+	case v != nil:
+		if !reflect.TypeOf(v).AssignableTo(t) {
+			return reflect.Value{}, IllegalArgumentError(fmt.Sprintf("field %s: %s is %T; expected %s", inj.field.Name, inj.name, v, t))
+		}
+		return reflect.ValueOf(v), nil
+	case inj.def != nil:
+		dv, e := parseDefault(*inj.def, t)
+		if e != nil {
+			return reflect.Value{}, IllegalArgumentError(fmt.Sprintf("field %s: default %q: %s", inj.field.Name, *inj.def, e))
+		}
+		return dv, nil
+	case inj.optional:
+		return reflect.Value{}, nil
+	}
+	return reflect.Value{}, NoSuchBindingError(fmt.Sprintf("field %s: %s", inj.field.Name, inj.name))
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// parses the default value per the type.
+func parseDefault(s string, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	if t == durationType {
+		d, e := time.ParseDuration(s)
+		if e != nil {
+			return v, e
+		}
+		v.SetInt(int64(d))
+		return v, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, e := strconv.ParseBool(s)
+		if e != nil {
+			return v, e
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, e := strconv.ParseInt(s, 0, t.Bits())
+		if e != nil {
+			return v, e
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, e := strconv.ParseUint(s, 0, t.Bits())
+		if e != nil {
+			return v, e
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, e := strconv.ParseFloat(s, t.Bits())
+		if e != nil {
+			return v, e
+		}
+		v.SetFloat(f)
+	default:
+		return v, fmt.Errorf("defaults are not supported for %s", t)
+	}
+	return v, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"goerror"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// testing: dependency injection
+// ============================================================================
+
+type store struct {
+	DB       fmt.Stringer  `contextual:"db.primary"`
+	Replica  fmt.Stringer  `contextual:"db.replica,optional"`
+	Timeout  time.Duration `contextual:"db.timeout,default=5s"`
+	Retries  int           `contextual:"db.retries, default=3"`
+	Name     string        `contextual:"db.name"`
+	Ignored  string        `contextual:"-"`
+	Untagged string
+	context  Context
+}
+
+func (s *store) SetContext(ctx Context) { s.context = ctx }
+
+func TestInject(t *testing.T) {
+	ctx := NewContext()
+	ctx.Bind("db.primary", time.Second) // a fmt.Stringer
+	ctx.Bind("db.name", "orders")
+	ctx.Bind("db.retries", 7)
+
+	s := &store{Replica: time.Minute, Untagged: "as is"}
+	if e := Inject(ctx, s); e != nil {
+		t.Fatalf("Inject - unexpected error: %s", e)
+	}
+	switch {
+	case s.DB != time.Second:
+		t.Fatalf("DB - expected:%v got:%v", time.Second, s.DB)
+	case s.Replica != time.Minute:
+		t.Fatalf("Replica - optional field is not left as is: %v", s.Replica)
+	case s.Timeout != 5*time.Second:
+		t.Fatalf("Timeout - expected default:%v got:%v", 5*time.Second, s.Timeout)
+	case s.Retries != 7:
+		t.Fatalf("Retries - expected:7 got:%d", s.Retries)
+	case s.Name != "orders" || s.Untagged != "as is":
+		t.Fatalf("Name - expected:orders got:%v", s.Name)
+	}
+}
+
+func TestInjectMasked(t *testing.T) {
+	root := NewContext()
+	for _, name := range []string{"db.primary", "db.replica", "db.timeout", "db.retries", "db.name"} {
+		root.Bind(name, 1)
+	}
+	ctx, _ := ChildContext(root)
+	root.Rebind("db.primary", time.Second)
+	root.Rebind("db.name", "orders")
+	m := ctx.(Maskable)
+	m.Mask("db.replica")
+	m.Mask("db.timeout")
+	m.Mask("db.retries")
+
+	// masked fields are unbound, and so may be optional or defaulted
+	s := &store{}
+	if e := Inject(ctx, s); e != nil {
+		t.Fatalf("Inject - unexpected error: %s", e)
+	}
+	if s.Replica != nil || s.Timeout != 5*time.Second || s.Retries != 3 {
+		t.Fatalf("Inject - masked fields are not unbound: %v %v %v", s.Replica, s.Timeout, s.Retries)
+	}
+
+	m.Mask("db.name")
+	e := Inject(ctx, &store{})
+	if e == nil || !goerror.TypeOf(e).Is(InjectionError) {
+		t.Fatalf("Inject - expected error: %s got: %v", InjectionError(), e)
+	}
+	if list := goerror.TypeOf(e).Cause().(ErrorList); len(list) != 1 || !strings.Contains(list[0].Error(), "field Name") {
+		t.Fatalf("Inject - expected error of field Name got: %s", list)
+	}
+}
+
+func TestInjectErrors(t *testing.T) {
+	ctx := NewContext()
+	ctx.Bind("db.primary", "not a stringer")
+	ctx.Bind("db.timeout", "5s")
+
+	s := &store{}
+	e := Inject(ctx, s)
+	if e == nil || !goerror.TypeOf(e).Is(InjectionError) {
+		t.Fatalf("Inject - expected error: %s got: %v", InjectionError(), e)
+	}
+	list := goerror.TypeOf(e).Cause().(ErrorList)
+	expected := []string{"field DB", "field Timeout", "field Name"}
+	if len(list) != len(expected) {
+		t.Fatalf("Inject - expected %d errors got: %s", len(expected), list)
+	}
+	for i, s := range expected {
+		if !strings.Contains(list[i].Error(), s) {
+			t.Fatalf("Inject - error %d - expected:%q got:%q", i, s, list[i])
+		}
+	}
+	if s.Retries != 0 {
+		t.Fatalf("Inject - fields are assigned on error")
+	}
+
+	var bad struct {
+		A int `contextual:""`
+		B int `contextual:"b,required"`
+		C int `contextual:"c,default=x"`
+		d int `contextual:"d,optional"`
+	}
+	_ = bad.d
+	e = Inject(ctx, &bad)
+	if e == nil || len(goerror.TypeOf(e).Cause().(ErrorList)) != 4 {
+		t.Fatalf("Inject(bad tags) - expected 4 errors got: %v", e)
+	}
+
+	assertError(t, "Inject(non-pointer)", Inject(ctx, store{}), IllegalArgumentError)
+	assertError(t, "Inject(nil)", Inject(ctx, nil), IllegalArgumentError)
+	assertError(t, "Inject(nil context)", Inject(nil, s), IllegalArgumentError)
+}
+
+func TestContainerInjection(t *testing.T) {
+	c, ctx := newTestContainer(t)
+	ctx.Bind("db.primary", time.Second)
+
+	s := &store{}
+	c.AddNamed("store", s)
+	assertError(t, "Init", c.Init(), LifecycleError)
+	assertState(t, c, "store", StateNew)
+
+	s.context.Bind("db.name", "orders")
+	if e := c.Init(); e != nil {
+		t.Fatalf("Init - unexpected error: %s", e)
+	}
+	if s.DB != time.Second || s.Name != "orders" {
+		t.Fatalf("Init - component is not injected: %+v", s)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ComponentInfo describes a component of a container.
+type ComponentInfo struct {
+	Name      string
+	Path      string // from the container, e.g. "/frontend/http"
+	Component Component
+	State     State
+	Depth     int // of the component's context
+}
+
+// EventKind is the kind of a container event.
+type EventKind int
+
+const (
+	EventAdded EventKind = iota
+	EventRemoved
+	EventStateChanged
+	EventFailed
+)
+
+var eventNames = [...]string{
+	EventAdded:        "added",
+	EventRemoved:      "removed",
+	EventStateChanged: "state changed",
+	EventFailed:       "failed",
+}
+
+func (k EventKind) String() string {
+	if k < 0 || int(k) >= len(eventNames) {
+		return fmt.Sprintf("event(%d)", int(k))
+	}
+	return eventNames[k]
+}
+
+// Event is an event of a component of a container. From and To are the
+// states of the transition of a state change or failure, and otherwise the
+// state of the component. Err is the error of a failure.
+type Event struct {
+	Kind      EventKind
+	Path      string // from the container, e.g. "/frontend/http"
+	Component Component
+	From, To  State
+	Err       error
+	Time      time.Time
+}
+
+func (ev Event) String() string {
+	switch ev.Kind {
+	case EventStateChanged:
+		return fmt.Sprintf("%s: %s => %s", ev.Path, ev.From, ev.To)
+	case EventFailed:
+		return fmt.Sprintf("%s: failed: %v", ev.Path, ev.Err)
+	}
+	return fmt.Sprintf("%s: %s", ev.Path, ev.Kind)
+}
+
+type subscriber struct {
+	fn func(Event)
+}
+
+func (c *container) Components() []ComponentInfo {
+	c.Lock()
+	defer c.Unlock()
+	infos := make([]ComponentInfo, len(c.order))
+	for i, name := range c.order {
+		infos[i] = c.entries[name].info("/" + name)
+	}
+	return infos
+}
+
+// REVU: c must be locked.
+func (e *entry) info(path string) ComponentInfo {
+	return ComponentInfo{
+		Name:      e.name,
+		Path:      path,
+		Component: e.component,
+		State:     e.state,
+		Depth:     e.context.Depth(),
+	}
+}
+
+func (c *container) Lookup(path string) (ComponentInfo, error) {
+	names := strings.Split(strings.Trim(path, "/"), "/")
+	current, prefix := c, ""
+	for i, name := range names {
+		current.Lock()
+		e, ok := current.entries[name]
+		if !ok || name == "" {
+			current.Unlock()
+			return ComponentInfo{}, NoSuchBindingError(path)
+		}
+		prefix += "/" + name
+		if i == len(names)-1 {
+			info := e.info(prefix)
+			current.Unlock()
+			return info, nil
+		}
+		current.Unlock()
+		if current = asContainer(e.component); current == nil {
+			return ComponentInfo{}, NoSuchBindingError(path)
+		}
+	}
+	return ComponentInfo{}, NoSuchBindingError(path)
+}
+
+func (c *container) Subscribe(fn func(Event)) (cancel func()) {
+	s := &subscriber{fn}
+	c.events.Lock()
+	c.subscribers = append(c.subscribers, s)
+	c.events.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.events.Lock()
+			defer c.events.Unlock()
+			for i, s0 := range c.subscribers {
+				if s0 == s {
+					c.subscribers = append(c.subscribers[:i:i], c.subscribers[i+1:]...)
+					break
+				}
+			}
+		})
+	}
+}
+
+// notifies the subscribers of the event.
+// REVU: c must not be locked.
+func (c *container) emit(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	c.events.Lock()
+	subscribers := c.subscribers
+	c.events.Unlock()
+	for _, s := range subscribers {
+		s.fn(ev)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// ============================================================================
+// testing: container introspection and events
+// ============================================================================
+
+// helper - records events as strings
+type eventLog struct {
+	sync.Mutex
+	events []string
+}
+
+func (l *eventLog) add(ev Event) {
+	l.Lock()
+	l.events = append(l.events, ev.String())
+	l.Unlock()
+}
+
+func (l *eventLog) assert(t *testing.T, expected ...string) {
+	l.Lock()
+	defer l.Unlock()
+	if !reflect.DeepEqual(l.events, expected) {
+		t.Fatalf("events - expected:%q got:%q", expected, l.events)
+	}
+	l.events = nil
+}
+
+func TestIntrospection(t *testing.T) {
+	root, _ := newTestContainer(t)
+	frontend := NewContainer()
+	http := NewContainer()
+	router := &testComponent{name: "router"}
+	root.Add(&testComponent{name: "db"})
+	root.AddNamed("frontend", frontend)
+	frontend.AddNamed("http", http)
+	http.Add(router)
+
+	infos := root.Components()
+	if len(infos) != 2 || infos[0].Name != "db" || infos[1].Path != "/frontend" || infos[1].Depth != 1 {
+		t.Fatalf("Components - unexpected: %+v", infos)
+	}
+
+	for _, path := range []string{"/frontend/http/router", "frontend/http/router"} {
+		info, e := root.Lookup(path)
+		if e != nil {
+			t.Fatalf("Lookup(%s) - unexpected error: %s", path, e)
+		}
+		if info.Component != router || info.Path != "/frontend/http/router" || info.Depth != 3 || info.State != StateNew {
+			t.Fatalf("Lookup(%s) - unexpected: %+v", path, info)
+		}
+	}
+	for _, path := range []string{"", "/", "/x", "/db/x", "/frontend//http", "/frontend/http/router/x"} {
+		if _, e := root.Lookup(path); e == nil {
+			t.Fatalf("Lookup(%q) - expected error: %s", path, NoSuchBindingError())
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	root, _ := newTestContainer(t)
+	nested := NewContainer()
+	root.AddNamed("nested", nested)
+
+	log := &eventLog{}
+	cancel := root.Subscribe(log.add)
+
+	a := newLifecycleComponent("a", &callLog{})
+	nested.Add(a)
+	// the nested container initializes its components first
+	root.Transition("nested", StateInitialized)
+	a.fail["start"] = errors.New("oops")
+	nested.Transition("a", StateStarted)
+	nested.Remove(a)
+	log.assert(t,
+		"/nested/a: added",
+		"/nested/a: new => initialized",
+		"/nested: new => initialized",
+		"/nested/a: failed: lifecycle error - a: initialized => started (cause: oops)",
+		"/nested/a: removed",
+	)
+
+	// removed nested containers are unsubscribed
+	root.Remove(nested)
+	log.assert(t, "/nested: removed")
+	nested.Add(&testComponent{name: "b"})
+	log.assert(t)
+
+	cancel()
+	root.Add(&testComponent{name: "c"})
+	log.assert(t)
+}
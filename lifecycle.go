@@ -0,0 +1,294 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+)
+
+// State is the lifecycle state of a component in a container.
+//
+// Valid transitions are:
+//
+//	New         => Initialized, Destroyed
+//	Initialized => Started, Destroyed
+//	Started     => Stopped
+//	Stopped     => Started, Destroyed
+type State int
+
+const (
+	StateNew State = iota
+	StateInitialized
+	StateStarted
+	StateStopped
+	StateDestroyed
+)
+
+var stateNames = [...]string{
+	StateNew:         "new",
+	StateInitialized: "initialized",
+	StateStarted:     "started",
+	StateStopped:     "stopped",
+	StateDestroyed:   "destroyed",
+}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("state(%d)", int(s))
+	}
+	return stateNames[s]
+}
+
+// valid transitions, by from state
+var transitions = map[State][]State{
+	StateNew:         {StateInitialized, StateDestroyed},
+	StateInitialized: {StateStarted, StateDestroyed},
+	StateStarted:     {StateStopped},
+	StateStopped:     {StateStarted, StateDestroyed},
+}
+
+// CanTransition returns true if the transition is valid.
+func CanTransition(from, to State) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// calls the lifecycle method of the component associated with the state.
+func invokeLifecycle(comp Component, to State) error {
+	switch to {
+	case StateInitialized:
+		if c, ok := comp.(Initializer); ok {
+			return c.Init()
+		}
+	case StateStarted:
+		if c, ok := comp.(Starter); ok {
+			return c.Start()
+		}
+	case StateStopped:
+		if c, ok := comp.(Stopper); ok {
+			return c.Stop()
+		}
+	case StateDestroyed:
+		if c, ok := comp.(Destroyer); ok {
+			return c.Destroy()
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// container lifecycle
+// ----------------------------------------------------------------------------
+
+func (c *container) State(name string) (State, error) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[name]
+	if !ok {
+		return 0, NoSuchBindingError(name)
+	}
+	return e.state, nil
+}
+
+func (c *container) Transition(name string, to State) error {
+	c.Lock()
+	e, ok := c.entries[name]
+	c.Unlock()
+	if !ok {
+		return NoSuchBindingError(name)
+	}
+	return c.transition(e, to)
+}
+
+// transitions of an entry are serialized by the entry's lifecycle lock, and
+// the lifecycle methods are called with the container unlocked. Components
+// with injected fields are injected before they are initialized.
+func (c *container) transition(e *entry, to State) error {
+	e.lifecycle.Lock()
+	defer e.lifecycle.Unlock()
+
+	c.Lock()
+	from := e.state
+	c.Unlock()
+	if !CanTransition(from, to) {
+		return IllegalStateError(fmt.Sprintf("%s: %s => %s", e.name, from, to))
+	}
+	switch {
+	case to == StateInitialized && injectable(e.component):
+		if err := Inject(e.context, e.component); err != nil {
+			return c.failed(e, from, to, LifecycleError(fmt.Sprintf("%s: %s => %s", e.name, from, to)).WithCause(err))
+		}
+	case to == StateStarted:
+		if err := c.renewPorts(e); err != nil {
+			return c.failed(e, from, to, err)
+		}
+	}
+	if err := invokeLifecycle(e.component, to); err != nil {
+		if to == StateStarted {
+			e.bus.cancelAll()
+		}
+		return c.failed(e, from, to, LifecycleError(fmt.Sprintf("%s: %s => %s", e.name, from, to)).WithCause(err))
+	}
+	switch to {
+	case StateStarted:
+		if err := c.publish(e); err != nil {
+			invokeLifecycle(e.component, StateStopped)
+			e.bus.cancelAll()
+			return c.failed(e, from, to, err)
+		}
+		c.forwardPorts(e)
+	case StateStopped:
+		c.closePorts(e)
+		c.unpublish(e)
+		e.bus.cancelAll()
+	}
+
+	c.Lock()
+	if e.forced {
+		c.Unlock()
+		return IllegalStateError(fmt.Sprintf("%s: destroyed by force", e.name))
+	}
+	e.state = to
+	if to == StateStarted {
+		e.starts++
+	}
+	c.Unlock()
+	c.emit(Event{Kind: EventStateChanged, Path: "/" + e.name, Component: e.component, From: from, To: to})
+	return nil
+}
+
+// emits the failure of the transition, and returns the error.
+func (c *container) failed(e *entry, from, to State, err error) error {
+	c.emit(Event{Kind: EventFailed, Path: "/" + e.name, Component: e.component, From: from, To: to, Err: err})
+	return err
+}
+
+// returns the entries, in order of addition, that are in any of the states.
+func (c *container) entriesIn(states ...State) []*entry {
+	c.Lock()
+	defer c.Unlock()
+	var entries []*entry
+	for _, name := range c.order {
+		e := c.entries[name]
+		for _, s := range states {
+			if e.state == s {
+				entries = append(entries, e)
+				break
+			}
+		}
+	}
+	return entries
+}
+
+func reversed(entries []*entry) []*entry {
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// Init initializes all new components, in order of addition. Init stops on
+// the first error.
+func (c *container) Init() error {
+	for _, e := range c.entriesIn(StateNew) {
+		if err := c.transition(e, StateInitialized); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start initializes and starts all new components, and starts all
+// initialized and stopped components, in dependency order (see Dependent).
+// Components are initialized, and injected, once the components they depend
+// on are started, and so their fields may be injected with the bindings
+// that those provide. Components that are not Dependent are started in
+// order of addition, and Dependent components that do not depend on each
+// other are started concurrently. If a component fails to initialize or
+// start, the components started by this call are stopped, in reverse order.
+//
+// Errors:
+//
+//	DependencyError <= requirements are not satisfied (cause is an ErrorList)
+//	DependencyCycleError <= components depend on each other
+//	LifecycleError <= a component failed to initialize or start
+func (c *container) Start() error {
+	entries := c.entriesIn(StateNew, StateInitialized, StateStopped)
+	if errors := c.unsatisfied(entries); len(errors) > 0 {
+		return DependencyError(fmt.Sprintf("%d unsatisfied requirement(s)", len(errors))).WithCause(errors)
+	}
+	lv, err := levels(entries)
+	if err != nil {
+		return err
+	}
+
+	var started [][]*entry
+	for _, level := range lv {
+		err := c.initLevel(level)
+		if err == nil {
+			done, errors := c.transitionLevel(level, StateStarted, 0)
+			started = append(started, done)
+			if len(errors) > 0 {
+				err = errors[0]
+			}
+		}
+		if err != nil {
+			for i := len(started) - 1; i >= 0; i-- {
+				c.transitionLevel(reversed(started[i]), StateStopped, c.stopTimeout)
+			}
+			return err
+		}
+	}
+	c.startHealthChecks()
+	return nil
+}
+
+// initializes the new entries of the level, in order. Stops on the first
+// error.
+func (c *container) initLevel(level []*entry) error {
+	for _, e := range level {
+		c.Lock()
+		fresh := e.state == StateNew
+		c.Unlock()
+		if !fresh {
+			continue
+		}
+		if err := c.transition(e, StateInitialized); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops all started components, in reverse dependency order. Each
+// component is given the container's stop timeout, if any. All components
+// are stopped even if some fail to stop; the first error is returned.
+func (c *container) Stop() error {
+	c.stopHealthChecks()
+	var err error
+	lv := c.startedLevels()
+	for i := len(lv) - 1; i >= 0; i-- {
+		if _, errors := c.transitionLevel(reversed(lv[i]), StateStopped, c.stopTimeout); len(errors) > 0 && err == nil {
+			err = errors[0]
+		}
+	}
+	return err
+}
+
+// Destroy stops all started components and then destroys all components,
+// in reverse order of addition. The first error is returned.
+func (c *container) Destroy() error {
+	err := c.Stop()
+	for _, e := range reversed(c.entriesIn(StateNew, StateInitialized, StateStopped)) {
+		if e0 := c.transition(e, StateDestroyed); e0 != nil && err == nil {
+			err = e0
+		}
+	}
+	return err
+}
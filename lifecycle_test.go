@@ -0,0 +1,179 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// ============================================================================
+// testing: component lifecycle
+// ============================================================================
+
+// helper - records lifecycle calls in a shared log
+type lifecycleComponent struct {
+	testComponent
+	log  *callLog
+	fail map[string]error // by method
+}
+
+type callLog struct {
+	sync.Mutex
+	calls []string
+}
+
+func (l *callLog) add(s string) {
+	l.Lock()
+	l.calls = append(l.calls, s)
+	l.Unlock()
+}
+
+func (l *callLog) get() []string {
+	l.Lock()
+	defer l.Unlock()
+	return append([]string(nil), l.calls...)
+}
+
+func newLifecycleComponent(name string, log *callLog) *lifecycleComponent {
+	return &lifecycleComponent{testComponent{name: name}, log, make(map[string]error)}
+}
+
+func (c *lifecycleComponent) call(method string) error {
+	if e := c.fail[method]; e != nil {
+		return e
+	}
+	c.log.add(c.name + "." + method)
+	return nil
+}
+
+func (c *lifecycleComponent) Init() error    { return c.call("init") }
+func (c *lifecycleComponent) Start() error   { return c.call("start") }
+func (c *lifecycleComponent) Stop() error    { return c.call("stop") }
+func (c *lifecycleComponent) Destroy() error { return c.call("destroy") }
+
+func assertState(t *testing.T, c Container, name string, expected State) {
+	s, e := c.State(name)
+	if e != nil {
+		t.Fatalf("State(%s) - unexpected error: %s", name, e)
+	}
+	if s != expected {
+		t.Fatalf("State(%s) - expected:%s got:%s", name, expected, s)
+	}
+}
+
+func assertCalls(t *testing.T, log *callLog, expected ...string) {
+	if calls := log.get(); !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("lifecycle calls - expected:%v got:%v", expected, calls)
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	c, _ := newTestContainer(t)
+	log := &callLog{}
+	c.Add(newLifecycleComponent("a", log))
+	// components without lifecycle methods have a lifecycle state too
+	c.AddNamed("b", NewComponent())
+
+	assertState(t, c, "a", StateNew)
+	assertError(t, "Transition(new => started)", c.Transition("a", StateStarted), IllegalStateError)
+	assertError(t, "Transition(new => stopped)", c.Transition("a", StateStopped), IllegalStateError)
+
+	for _, s := range []State{StateInitialized, StateStarted, StateStopped, StateStarted, StateStopped, StateDestroyed} {
+		if e := c.Transition("a", s); e != nil {
+			t.Fatalf("Transition(%s) - unexpected error: %s", s, e)
+		}
+		assertState(t, c, "a", s)
+		if e := c.Transition("b", s); e != nil {
+			t.Fatalf("Transition(%s) - unexpected error: %s", s, e)
+		}
+	}
+	assertCalls(t, log, "a.init", "a.start", "a.stop", "a.start", "a.stop", "a.destroy")
+
+	for _, s := range []State{StateNew, StateInitialized, StateStarted, StateStopped, StateDestroyed} {
+		assertError(t, "Transition(destroyed => *)", c.Transition("a", s), IllegalStateError)
+	}
+	assertError(t, "Transition(no such component)", c.Transition("x", StateInitialized), NoSuchBindingError)
+	if _, e := c.State("x"); e == nil {
+		t.Fatalf("State(no such component) - expected error: %s", NoSuchBindingError())
+	}
+}
+
+func TestTransitionFailure(t *testing.T) {
+	c, _ := newTestContainer(t)
+	comp := newLifecycleComponent("a", &callLog{})
+	comp.fail["init"] = errors.New("no db")
+	c.Add(comp)
+
+	assertError(t, "Transition(initialized)", c.Transition("a", StateInitialized), LifecycleError)
+	assertState(t, c, "a", StateNew)
+
+	delete(comp.fail, "init")
+	if e := c.Transition("a", StateInitialized); e != nil {
+		t.Fatalf("Transition - unexpected error: %s", e)
+	}
+	assertState(t, c, "a", StateInitialized)
+}
+
+func TestContainerLifecycle(t *testing.T) {
+	c, _ := newTestContainer(t)
+	log := &callLog{}
+	for _, name := range []string{"a", "b", "c"} {
+		c.Add(newLifecycleComponent(name, log))
+	}
+
+	if e := c.Start(); e != nil {
+		t.Fatalf("Start - unexpected error: %s", e)
+	}
+	assertCalls(t, log, "a.init", "b.init", "c.init", "a.start", "b.start", "c.start")
+	for _, name := range []string{"a", "b", "c"} {
+		assertState(t, c, name, StateStarted)
+	}
+	assertError(t, "Remove(started)", c.Remove(c.(*container).entries["a"].component), IllegalStateError)
+
+	log.calls = nil
+	if e := c.Destroy(); e != nil {
+		t.Fatalf("Destroy - unexpected error: %s", e)
+	}
+	assertCalls(t, log, "c.stop", "b.stop", "a.stop", "c.destroy", "b.destroy", "a.destroy")
+}
+
+func TestContainerStartFailure(t *testing.T) {
+	c, _ := newTestContainer(t)
+	log := &callLog{}
+	for _, name := range []string{"a", "b", "c"} {
+		comp := newLifecycleComponent(name, log)
+		if name == "c" {
+			comp.fail["start"] = errors.New("port in use")
+		}
+		c.Add(comp)
+	}
+
+	assertError(t, "Start", c.Start(), LifecycleError)
+	assertCalls(t, log, "a.init", "b.init", "c.init", "a.start", "b.start", "b.stop", "a.stop")
+	assertState(t, c, "a", StateStopped)
+	assertState(t, c, "c", StateInitialized)
+}
+
+func TestNestedContainerLifecycle(t *testing.T) {
+	root, _ := newTestContainer(t)
+	nested := NewContainer()
+	log := &callLog{}
+	root.Add(newLifecycleComponent("a", log))
+	root.AddNamed("nested", nested)
+	nested.Add(newLifecycleComponent("b", log))
+
+	if e := root.Start(); e != nil {
+		t.Fatalf("Start - unexpected error: %s", e)
+	}
+	assertState(t, nested, "b", StateStarted)
+	if e := root.Stop(); e != nil {
+		t.Fatalf("Stop - unexpected error: %s", e)
+	}
+	assertState(t, nested, "b", StateStopped)
+	assertCalls(t, log, "a.init", "b.init", "a.start", "b.start", "b.stop", "a.stop")
+}
@@ -0,0 +1,314 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Manifest describes the assembly of a container: the bindings of the
+// container's context, the components of the container, and the wiring of
+// their ports.
+//
+// Example:
+//
+//	{
+//	  "bindings": {"env": "prod"},
+//	  "components": [
+//	    {"name": "db", "factory": "postgres", "bindings": {"url": "..."}},
+//	    {"name": "api", "factory": "http", "bindings": {"port": 8080}},
+//	    {"name": "workers", "container": {"components": [...]}}
+//	  ],
+//	  "wires": [{"from": "db.changes", "to": "api.events"}]
+//	}
+//
+// JSON numbers are bound as int if integral, and as float64 otherwise.
+type Manifest struct {
+	Bindings   map[string]interface{} `json:"bindings,omitempty"`
+	Components []ComponentSpec        `json:"components"`
+	Wires      []WireSpec             `json:"wires,omitempty"`
+}
+
+// ComponentSpec describes a component of a manifest. The component is either
+// created by the registered factory, or is a nested container assembled per
+// the nested manifest.
+type ComponentSpec struct {
+	Name      string                 `json:"name"`
+	Factory   string                 `json:"factory,omitempty"`
+	Container *Manifest              `json:"container,omitempty"`
+	Bindings  map[string]interface{} `json:"bindings,omitempty"` // of the component's context, bound before it is set
+}
+
+// WireSpec describes the wiring of an output port to an input port. Ports
+// are specified as "<component>.<port>".
+type WireSpec struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ComponentFactory creates components.
+type ComponentFactory func() (Component, error)
+
+var factories = struct {
+	sync.RWMutex
+	m map[string]ComponentFactory
+}{m: make(map[string]ComponentFactory)}
+
+// RegisterFactory registers the named component factory for use in
+// manifests.
+//
+// Errors:
+//
+//	NilNameError <= name is zero-value
+//	IllegalArgumentError <= factory is nil
+//	AlreadyBoundError <= a factory is already registered with the name
+func RegisterFactory(name string, factory ComponentFactory) error {
+	if name == "" {
+		return NilNameError()
+	}
+	if factory == nil {
+		return IllegalArgumentError("factory is nil")
+	}
+	factories.Lock()
+	defer factories.Unlock()
+	if _, ok := factories.m[name]; ok {
+		return AlreadyBoundError(fmt.Sprintf("factory %s", name))
+	}
+	factories.m[name] = factory
+	return nil
+}
+
+func factory(name string) (ComponentFactory, bool) {
+	factories.RLock()
+	defer factories.RUnlock()
+	f, ok := factories.m[name]
+	return f, ok
+}
+
+// ParseManifest reads a JSON manifest.
+//
+// Errors:
+//
+//	ManifestError <= manifest is not valid JSON
+func ParseManifest(r io.Reader) (*Manifest, error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	var m Manifest
+	if e := d.Decode(&m); e != nil {
+		return nil, ManifestError("parse").WithCause(e)
+	}
+	normalize(&m)
+	return &m, nil
+}
+
+// converts the json.Number values of the manifest's bindings.
+func normalize(m *Manifest) {
+	convert := func(bindings map[string]interface{}) {
+		for k, v := range bindings {
+			if n, ok := v.(json.Number); ok {
+				if i, e := n.Int64(); e == nil && int64(int(i)) == i {
+					bindings[k] = int(i)
+				} else if f, e := n.Float64(); e == nil {
+					bindings[k] = f
+				}
+			}
+		}
+	}
+	convert(m.Bindings)
+	for i := range m.Components {
+		convert(m.Components[i].Bindings)
+		if m.Components[i].Container != nil {
+			normalize(m.Components[i].Container)
+		}
+	}
+}
+
+// Build assembles a container per the manifest. The container's context is
+// a child context of ctx. All errors of the manifest are reported at once,
+// as the ErrorList cause of the returned error.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx or manifest is nil
+//	ManifestError <= the manifest can not be assembled
+func Build(ctx Context, m *Manifest) (Container, error) {
+	if ctx == nil {
+		return nil, NilParentError()
+	}
+	if m == nil {
+		return nil, IllegalArgumentError("manifest is nil")
+	}
+	cctx, e := ChildContext(ctx)
+	if e != nil {
+		return nil, e
+	}
+	c := NewContainer()
+	c.SetContext(cctx)
+
+	var errors ErrorList
+	assemble(c.(*container), m, "", &errors)
+	if len(errors) > 0 {
+		c.(*container).discard()
+		return nil, ManifestError(fmt.Sprintf("%d error(s)", len(errors))).WithCause(errors)
+	}
+	return c, nil
+}
+
+// Load assembles a container per the manifest, and starts it. See Build. A
+// container that fails to start is destroyed, and its context closed.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx or manifest is nil
+//	ManifestError <= the manifest can not be assembled
+//	LifecycleError <= the container failed to start
+func Load(ctx Context, m *Manifest) (Container, error) {
+	c, e := Build(ctx, m)
+	if e != nil {
+		return nil, e
+	}
+	if e := c.Start(); e != nil {
+		c.Destroy()
+		c.(*container).discard()
+		return nil, e
+	}
+	return c, nil
+}
+
+// assembles the container per the manifest, and appends errors to the list.
+// The path qualifies the component names in errors.
+func assemble(c *container, m *Manifest, path string, errors *ErrorList) {
+	report := func(where string, e error) {
+		*errors = append(*errors, ManifestError(path+where).WithCause(e))
+	}
+
+	bind(c.context, m.Bindings, func(name string, e error) {
+		report(fmt.Sprintf("bindings[%s]", name), e)
+	})
+
+	failed := make(map[string]bool)
+	for i, spec := range m.Components {
+		where := fmt.Sprintf("components[%d] %s", i, spec.Name)
+		comp, e := create(spec)
+		if e == nil {
+			// bound before the context is set on the component
+			e = c.addWith(spec.Name, comp, func(ctx Context) {
+				bind(ctx, spec.Bindings, func(name string, e error) {
+					report(fmt.Sprintf("%s bindings[%s]", where, name), e)
+				})
+			})
+		}
+		if e != nil {
+			report(where, e)
+			if _, e := c.State(spec.Name); e != nil {
+				failed[spec.Name] = true
+			}
+			continue
+		}
+		if spec.Container != nil {
+			assemble(comp.(*container), spec.Container, path+spec.Name+"/", errors)
+		}
+	}
+
+	for i, w := range m.Wires {
+		where := fmt.Sprintf("wires[%d] %s => %s", i, w.From, w.To)
+		from, out, e1 := endpoint(w.From)
+		to, in, e2 := endpoint(w.To)
+		switch {
+		case e1 != nil:
+			report(where, e1)
+		case e2 != nil:
+			report(where, e2)
+		case failed[from] || failed[to]:
+			// already reported
+		default:
+			if e := c.Wire(from, out, to, in); e != nil {
+				report(where, e)
+			}
+		}
+	}
+}
+
+// creates the component of the spec.
+func create(spec ComponentSpec) (Component, error) {
+	switch {
+	case spec.Factory != "" && spec.Container != nil:
+		return nil, IllegalArgumentError("both factory and container are specified")
+	case spec.Container != nil:
+		return NewContainer(), nil
+	case spec.Factory == "":
+		return nil, IllegalArgumentError("factory is not specified")
+	}
+	f, ok := factory(spec.Factory)
+	if !ok {
+		return nil, NoSuchBindingError(fmt.Sprintf("factory %s", spec.Factory))
+	}
+	comp, e := f()
+	if e != nil {
+		return nil, e
+	}
+	if comp == nil {
+		return nil, IllegalArgumentError(fmt.Sprintf("factory %s returned nil", spec.Factory))
+	}
+	return comp, nil
+}
+
+// binds the bindings, in name order, and reports errors.
+func bind(ctx Context, bindings map[string]interface{}, report func(string, error)) {
+	names := make([]string, 0, len(bindings))
+	for name := range bindings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if e := ctx.Bind(name, bindings[name]); e != nil {
+			report(name, e)
+		} else if d, ok := ctx.(Describable); ok {
+			d.SetSource(name, "manifest")
+		}
+	}
+}
+
+// parses a "<component>.<port>" endpoint.
+func endpoint(s string) (component, port string, e error) {
+	i := strings.LastIndex(s, ".")
+	if i <= 0 || i == len(s)-1 {
+		return "", "", IllegalArgumentError(fmt.Sprintf("endpoint %q is not <component>.<port>", s))
+	}
+	return s[:i], s[i+1:], nil
+}
+
+// releases the container, and closes its context.
+func (c *container) discard() {
+	c.release()
+	c.Lock()
+	ctx := c.context
+	c.Unlock()
+	closeContext(ctx)
+}
+
+// removes all entries of the container, and of its nested containers and
+// supervisors.
+func (c *container) release() {
+	c.Lock()
+	var removed []*entry
+	for _, name := range append([]string(nil), c.order...) {
+		e := c.entries[name]
+		c.remove(e)
+		removed = append(removed, e)
+	}
+	c.Unlock()
+	for _, e := range removed {
+		if nested := asContainer(e.component); nested != nil {
+			nested.release()
+		}
+		detach(e)
+	}
+}
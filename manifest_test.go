@@ -0,0 +1,257 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"errors"
+	"goerror"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// testing: manifests
+// ============================================================================
+
+func init() {
+	RegisterFactory("test.plain", func() (Component, error) {
+		return &testComponent{}, nil
+	})
+	RegisterFactory("test.source", func() (Component, error) {
+		return newPortedComponent("", PortSpec{Name: "out", Dir: PortOut, Type: intType}), nil
+	})
+	RegisterFactory("test.sink", func() (Component, error) {
+		return newPortedComponent("", PortSpec{Name: "in", Dir: PortIn, Type: intType}), nil
+	})
+	RegisterFactory("test.text", func() (Component, error) {
+		return newPortedComponent("", PortSpec{Name: "in", Dir: PortIn, Type: stringType}), nil
+	})
+	RegisterFactory("test.broken", func() (Component, error) {
+		return nil, errors.New("no license")
+	})
+	RegisterFactory("test.eager", func() (Component, error) {
+		lastEager = &eagerComponent{}
+		return lastEager, nil
+	})
+	RegisterFactory("test.reconfigurable", func() (Component, error) {
+		return &reconfigurableComponent{newLifecycleComponent("", &callLog{}), []string{"port"}, make(map[string]interface{})}, nil
+	})
+	RegisterFactory("test.unstartable", func() (Component, error) {
+		comp := newLifecycleComponent("", &callLog{})
+		comp.fail["start"] = errors.New("port in use")
+		return comp, nil
+	})
+}
+
+// helper - a component that reads its context when it is set
+type eagerComponent struct {
+	testComponent
+	rate   interface{}
+	parent Context // of the context, per the source of the env binding
+}
+
+var lastEager *eagerComponent
+
+func (c *eagerComponent) SetContext(ctx Context) {
+	c.testComponent.SetContext(ctx)
+	if ctx != nil {
+		c.rate, _ = ctx.Lookup("rate")
+		if info, e := ctx.(Describable).Describe("env"); e == nil {
+			c.parent = info.Context
+		}
+	}
+}
+
+func parse(t *testing.T, s string) *Manifest {
+	m, e := ParseManifest(strings.NewReader(s))
+	if e != nil {
+		t.Fatalf("ParseManifest - unexpected error: %s", e)
+	}
+	return m
+}
+
+func TestLoad(t *testing.T) {
+	m := parse(t, `{
+		"bindings": {"env": "test"},
+		"components": [
+			{"name": "src", "factory": "test.source", "bindings": {"rate": 10, "ratio": 0.5}},
+			{"name": "workers", "container": {
+				"bindings": {"pool": "workers"},
+				"components": [{"name": "w1", "factory": "test.plain"}]
+			}},
+			{"name": "sink", "factory": "test.sink"}
+		],
+		"wires": [{"from": "src.out", "to": "sink.in"}]
+	}`)
+
+	root := NewContext()
+	c, e := Load(root, m)
+	if e != nil {
+		t.Fatalf("Load - unexpected error: %s", e)
+	}
+	defer c.Destroy()
+
+	assertState(t, c, "src", StateStarted)
+	src := c.(*container).entries["src"].component.(*portedComponent)
+	for name, expected := range map[string]interface{}{"env": "test", "rate": 10, "ratio": 0.5} {
+		if v, _ := src.context.Lookup(name); v != expected {
+			t.Fatalf("Lookup(%s) - expected:%v got:%v", name, expected, v)
+		}
+	}
+	if v, _ := root.Lookup("env"); v != nil {
+		t.Fatalf("Lookup(env) - manifest bindings are visible in the parent context")
+	}
+	if info, _ := src.context.(Describable).Describe("env"); info.Source != "manifest" {
+		t.Fatalf("Describe(env) - expected source:manifest got:%q", info.Source)
+	}
+
+	workers := c.(*container).entries["workers"].component.(Container)
+	assertState(t, workers, "w1", StateStarted)
+	w1 := workers.(*container).entries["w1"].component.(*testComponent)
+	if v, _ := w1.context.Lookup("pool"); v != "workers" {
+		t.Fatalf("Lookup(pool) - expected:workers got:%v", v)
+	}
+
+	sink := c.(*container).entries["sink"].component.(*portedComponent)
+	out, _ := OutPort[int](src.context, "out")
+	in, _ := InPort[int](sink.context, "in")
+	out <- 7
+	if v := receive(t, in, 1); v[0] != 7 {
+		t.Fatalf("receive - expected:7 got:%v", v[0])
+	}
+}
+
+func TestLoadBindsBeforeSetContext(t *testing.T) {
+	m := parse(t, `{"components": [{"name": "e", "factory": "test.eager", "bindings": {"rate": 10}}]}`)
+	c, e := Build(NewContext(), m)
+	if e != nil {
+		t.Fatalf("Build - unexpected error: %s", e)
+	}
+	if comp := c.(*container).entries["e"].component.(*eagerComponent); comp.rate != 10 {
+		t.Fatalf("SetContext - expected rate:10 got:%v", comp.rate)
+	}
+}
+
+func TestBuildReconfigurable(t *testing.T) {
+	m := parse(t, `{"components": [{"name": "a", "factory": "test.reconfigurable", "bindings": {"port": 1}}]}`)
+	done := make(chan error, 1)
+	go func() {
+		_, e := Build(NewContext(), m)
+		done <- e
+	}()
+	select {
+	case e := <-done:
+		if e != nil {
+			t.Fatalf("Build - unexpected error: %s", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Build - deadlock on binding the configuration")
+	}
+}
+
+func TestReleaseSupervisors(t *testing.T) {
+	c, _ := newTestContainer(t)
+	s := NewSupervisor(OneForOne)
+	c.AddNamed("s", s)
+	comp := &testComponent{name: "a"}
+	s.Add(comp)
+
+	c.(*container).discard()
+	if comp.context != nil {
+		t.Fatalf("discard - component of nested supervisor is not released")
+	}
+}
+
+func TestLoadStartFailure(t *testing.T) {
+	m := parse(t, `{
+		"bindings": {"env": "test"},
+		"components": [
+			{"name": "e", "factory": "test.eager"},
+			{"name": "b", "factory": "test.unstartable"}
+		]
+	}`)
+	c, e := Load(NewContext(), m)
+	if c != nil {
+		t.Fatalf("Load - unexpected container")
+	}
+	assertError(t, "Load", e, LifecycleError)
+
+	// the container is released, and its context closed
+	comp := lastEager
+	if comp.context != nil {
+		t.Fatalf("Load - component context is not reset")
+	}
+	assertError(t, "Bind(container context)", comp.parent.Bind("a", 1), IllegalStateError)
+}
+
+func TestBuildErrors(t *testing.T) {
+	m := parse(t, `{
+		"bindings": {"env": "test"},
+		"components": [
+			{"name": "src", "factory": "test.source", "bindings": {"env": "shadowed"}},
+			{"name": "x", "factory": "test.unknown"},
+			{"name": "y", "factory": "test.broken"},
+			{"name": "text", "factory": "test.text"},
+			{"name": "src", "factory": "test.plain"},
+			{"name": "nested", "container": {"components": [{"name": "z", "factory": "test.unknown"}]}}
+		],
+		"wires": [
+			{"from": "src.out", "to": "text.in"},
+			{"from": "x.out", "to": "text.in"},
+			{"from": "src", "to": "text.in"}
+		]
+	}`)
+
+	root := NewContext()
+	root.Bind("env", "root")
+	c, e := Build(root, m)
+	if c != nil || e == nil || !goerror.TypeOf(e).Is(ManifestError) {
+		t.Fatalf("Build - expected error: %s got: %v", ManifestError(), e)
+	}
+	list, ok := goerror.TypeOf(e).Cause().(ErrorList)
+	if !ok {
+		t.Fatalf("Build - expected ErrorList cause got: %T", goerror.TypeOf(e).Cause())
+	}
+
+	// all errors are reported; the wire of the failed component is not
+	expected := []string{
+		"components[1] x",
+		"components[2] y",
+		"components[4] src",
+		"nested/components[0] z",
+		"wires[0] src.out => text.in",
+		"wires[2] src => text.in",
+	}
+	if len(list) != len(expected) {
+		t.Fatalf("Build - expected %d errors got: %s", len(expected), list)
+	}
+	for i, s := range expected {
+		if !strings.Contains(list[i].Error(), s) {
+			t.Fatalf("Build - error %d - expected:%q got:%q", i, s, list[i])
+		}
+	}
+	// shadowing a binding of an ancestor is allowed
+	if v, _ := root.Lookup("env"); v != "root" {
+		t.Fatalf("Lookup(env) - expected:root got:%v", v)
+	}
+}
+
+func TestManifestErrors(t *testing.T) {
+	if _, e := ParseManifest(strings.NewReader("{")); e == nil || !goerror.TypeOf(e).Is(ManifestError) {
+		t.Fatalf("ParseManifest - expected error: %s got: %v", ManifestError(), e)
+	}
+	if _, e := Build(NewContext(), nil); e == nil {
+		t.Fatalf("Build(nil) - expected error: %s", IllegalArgumentError())
+	}
+	m := parse(t, `{"bindings": {"a": 1}, "components": [{"name": "a", "factory": "test.plain"}]}`)
+	if _, e := Build(nil, m); e == nil {
+		t.Fatalf("Build(nil context) - expected error: %s", IllegalArgumentError())
+	}
+
+	assertError(t, "RegisterFactory(dup)", RegisterFactory("test.plain", func() (Component, error) { return nil, nil }), AlreadyBoundError)
+	assertError(t, "RegisterFactory(\"\")", RegisterFactory("", func() (Component, error) { return nil, nil }), NilNameError)
+	assertError(t, "RegisterFactory(nil)", RegisterFactory("test.nil", nil), IllegalArgumentError)
+}
@@ -0,0 +1,130 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+)
+
+// Mask masks the bindings of the name of the ancestors. See Maskable.
+func (c *context) Mask(name string) error {
+	name, e := c.checkName(name, false)
+	if e != nil {
+		return e
+	}
+	var visible interface{}
+	if c.parent != nil {
+		if p, ok := c.parent.(FinalBinder); ok && p.IsFinal(name) {
+			return ShadowingForbiddenError(fmt.Sprintf("%s is final", name))
+		}
+		visible, _ = c.parent.Lookup(name)
+	}
+
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return IllegalStateError("context is closed")
+	}
+	_, bound := c.get(name)
+	masked := c.masks[name]
+	if c.masks == nil {
+		c.masks = make(map[string]bool)
+	}
+	c.masks[name] = true
+	c.Unlock()
+	if !bound && !masked && visible != nil {
+		c.notify([]Change{{c, name, visible, nil, ChangeMasked}})
+	}
+	return nil
+}
+
+// Unmask removes the mask of the name. See Maskable.
+func (c *context) Unmask(name string) error {
+	name, e := c.checkName(name, false)
+	if e != nil {
+		return e
+	}
+
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return IllegalStateError("context is closed")
+	}
+	if !c.masks[name] {
+		c.Unlock()
+		return NoSuchBindingError(fmt.Sprintf("%s is not masked", name))
+	}
+	delete(c.masks, name)
+	_, bound := c.get(name)
+	c.Unlock()
+
+	if !bound && c.parent != nil {
+		if visible, _ := c.parent.Lookup(name); visible != nil {
+			c.notify([]Change{{c, name, nil, visible, ChangeUnmasked}})
+		}
+	}
+	return nil
+}
+
+func (c *context) IsMasked(name string) bool {
+	name, e := c.checkName(name, false)
+	if e != nil {
+		return false
+	}
+	c.RLock()
+	defer c.RUnlock()
+	return c.masks[name]
+}
+
+// returns the number of the bindings of the parent that the masks of the
+// context hide from it.
+// REVU: c must not be locked.
+func (c *context) masked() int {
+	c.RLock()
+	var names []string
+	for name := range c.masks {
+		names = append(names, name)
+	}
+	c.RUnlock()
+	n := 0
+	for _, name := range names {
+		n += occurrences(c.parent, name)
+	}
+	return n
+}
+
+// returns the number of the bindings of the name in the context and its
+// ancestors that are counted by the Size of the context. Only the nearest
+// binding is known of contexts that are not in-memory.
+func occurrences(ctx Context, name string) int {
+	switch c := ctx.(type) {
+	case nil:
+		return 0
+	case *context:
+		key, e := c.checkName(name, false)
+		if e != nil {
+			return 0
+		}
+		c.RLock()
+		closed, masked := c.closed, c.masks[key]
+		_, bound := c.get(key)
+		c.RUnlock()
+		n := 0
+		if closed {
+			return 0
+		}
+		if bound {
+			n++
+		}
+		if masked {
+			return n
+		}
+		return n + occurrences(c.parent, key)
+	}
+	if v, _ := ctx.Lookup(name); v != nil {
+		return 1
+	}
+	return 0
+}
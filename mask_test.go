@@ -0,0 +1,162 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// testing: masking
+// ============================================================================
+
+func TestMask(t *testing.T) {
+	root := NewContext()
+	root.Bind("a", 1)
+	root.Bind("b", 2)
+	child, _ := ChildContext(root)
+	grandchild, _ := ChildContext(child)
+	m := child.(Maskable)
+
+	if e := m.Mask("a"); e != nil {
+		t.Fatalf("Mask - unexpected error: %s", e)
+	}
+	if e := m.Mask("a"); e != nil {
+		t.Fatalf("Mask(masked) - unexpected error: %s", e)
+	}
+	if !m.IsMasked("a") || m.IsMasked("b") {
+		t.Fatalf("IsMasked - unexpected")
+	}
+	for _, ctx := range []Context{child, grandchild} {
+		_, e := ctx.Lookup("a")
+		assertError(t, "Lookup(masked)", e, NoSuchBindingError)
+		_, e = ctx.LookupN("a", 2)
+		assertError(t, "LookupN(masked)", e, NoSuchBindingError)
+		if v, _ := ctx.Lookup("b"); v != 2 {
+			t.Fatalf("Lookup - expected:2 got:%v", v)
+		}
+	}
+	if v, e := grandchild.LookupN("a", 0); v != nil || e != nil {
+		t.Fatalf("LookupN(short of mask) - unexpected: %v, %v", v, e)
+	}
+	if v, _ := root.Lookup("a"); v != 1 {
+		t.Fatalf("Lookup(root) - expected:1 got:%v", v)
+	}
+
+	// a binding of the masking context (or a descendant) is not masked
+	if e := grandchild.Bind("a", 3); e != nil {
+		t.Fatalf("Bind(masked) - unexpected error: %s", e)
+	}
+	if v, _ := grandchild.Lookup("a"); v != 3 {
+		t.Fatalf("Lookup(descendant) - expected:3 got:%v", v)
+	}
+	if e := child.Bind("a", 4); e != nil {
+		t.Fatalf("Bind(masked) - unexpected error: %s", e)
+	}
+	if v, _ := child.Lookup("a"); v != 4 {
+		t.Fatalf("Lookup(local) - expected:4 got:%v", v)
+	}
+	child.Unbind("a")
+	_, e := child.Lookup("a")
+	assertError(t, "Lookup(unbound)", e, NoSuchBindingError)
+
+	if e := m.Unmask("a"); e != nil {
+		t.Fatalf("Unmask - unexpected error: %s", e)
+	}
+	if v, _ := child.Lookup("a"); v != 1 {
+		t.Fatalf("Lookup(unmasked) - expected:1 got:%v", v)
+	}
+	assertError(t, "Unmask(not masked)", m.Unmask("a"), NoSuchBindingError)
+	assertError(t, "Mask(empty)", m.Mask(""), NilNameError)
+
+	child.(*context).Close()
+	assertError(t, "Mask(closed)", m.Mask("a"), IllegalStateError)
+}
+
+func TestMaskAccounting(t *testing.T) {
+	root := NewContext()
+	root.Bind("a", 1)
+	child, _ := ChildContext(root)
+	child.Bind("a", 2)
+	grandchild, _ := ChildContext(child)
+	m := grandchild.(Maskable)
+
+	// shadowed bindings are counted per context
+	if n := grandchild.Size(); n != 2 {
+		t.Fatalf("Size - expected:2 got:%d", n)
+	}
+	m.Mask("a")
+	if n := grandchild.Size(); n != 0 || !grandchild.IsEmpty() {
+		t.Fatalf("Size(masked) - expected:0 got:%d", n)
+	}
+	if n := child.Size(); n != 2 || child.IsEmpty() {
+		t.Fatalf("Size(parent) - expected:2 got:%d", n)
+	}
+	grandchild.Bind("a", 3)
+	if n := grandchild.Size(); n != 1 || grandchild.IsEmpty() {
+		t.Fatalf("Size(bound) - expected:1 got:%d", n)
+	}
+	grandchild.Unbind("a")
+
+	// a mask of an intermediate context hides the bindings beyond it
+	child.(Maskable).Mask("a")
+	m.Unmask("a")
+	if n := grandchild.Size(); n != 1 {
+		t.Fatalf("Size(masked parent) - expected:1 got:%d", n)
+	}
+
+	d := grandchild.(Describable)
+	if infos := d.Bindings(); len(infos) != 1 || infos[0].Value != 2 {
+		t.Fatalf("Bindings - unexpected: %v", infos)
+	}
+	m.Mask("a")
+	if infos := d.Bindings(); len(infos) != 0 {
+		t.Fatalf("Bindings(masked) - unexpected: %v", infos)
+	}
+	_, e := d.Describe("a")
+	assertError(t, "Describe(masked)", e, NoSuchBindingError)
+}
+
+func TestMaskFinal(t *testing.T) {
+	root := NewContext()
+	root.(FinalBinder).BindFinal("a", 1)
+	root.Bind("b", 2)
+	child, _ := ChildContext(root, WithShadowing(ForbidShadowing, nil))
+	m := child.(Maskable)
+
+	assertError(t, "Mask(final)", m.Mask("a"), ShadowingForbiddenError)
+	if m.IsMasked("a") {
+		t.Fatalf("IsMasked - final binding is masked")
+	}
+
+	// a masked name is not shadowed by a binding of the context
+	m.Mask("b")
+	if e := child.Bind("b", 3); e != nil {
+		t.Fatalf("Bind(masked) - unexpected error: %s", e)
+	}
+}
+
+func TestMaskWatch(t *testing.T) {
+	root := NewContext()
+	root.Bind("a", 1)
+	child, _ := ChildContext(root)
+	m := child.(Maskable)
+
+	var changes []Change
+	child.(Watchable).Watch(func(ch []Change) { changes = append(changes, ch...) })
+
+	m.Mask("a")
+	root.Rebind("a", 2)
+	m.Unmask("a")
+	expected := []Change{{child, "a", 1, nil, ChangeMasked}, {child, "a", nil, 2, ChangeUnmasked}}
+	if len(changes) != len(expected) {
+		t.Fatalf("Watch - unexpected changes: %v", changes)
+	}
+	for i, ch := range changes {
+		if ch != expected[i] {
+			t.Fatalf("Watch - expected:%v got:%v", expected[i], ch)
+		}
+	}
+}
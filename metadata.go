@@ -0,0 +1,267 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the metadata of a binding of a context.
+type bindingMeta struct {
+	bound, rebound time.Time
+	caller         string
+	source         string
+	annotations    map[string]string
+	final          bool
+}
+
+// the import path of the package, whose (non-test) frames are skipped when
+// the caller of a bind is determined.
+var pkgPath = reflect.TypeOf(context{}).PkgPath()
+
+// RecordCallers has the context record the source location of the caller of
+// each bind (see BindingInfo.Caller). Determining the caller walks the stack
+// of each bind, and so callers are not recorded by default.
+func RecordCallers(record bool) ContextOption {
+	return func(c *context) { c.callers = record }
+}
+
+// returns the source location of the nearest caller outside of the package.
+func caller() string {
+	var pcs [16]uintptr
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		fn := frame.Function
+		internal := strings.HasPrefix(fn, pkgPath+".") && !strings.HasSuffix(frame.File, "_test.go")
+		if !internal || !more {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+	}
+}
+
+// records the metadata of the new binding.
+// REVU: c must be locked.
+func (c *context) bound(name string) {
+	if c.meta == nil {
+		c.meta = make(map[string]*bindingMeta)
+	}
+	m := &bindingMeta{bound: c.clock.Now()}
+	if c.callers {
+		m.caller = caller()
+	}
+	c.meta[name] = m
+}
+
+// rebinds the name, and carries over the metadata of the prior binding.
+// REVU: c must be locked.
+func (c *context) rebind(name string, value interface{}) (unboundValue interface{}, e error) {
+	// a faulted rebind leaves the binding as is
+	if _, bound := c.get(name); bound && !c.closed {
+		if e := c.schema.check(name, value); e != nil {
+			return nil, e
+		}
+	}
+	m := c.meta[name]
+	if unboundValue, e = c.unbind(name); e != nil {
+		return nil, e
+	}
+	if e = c.bind(name, value); e != nil {
+		return nil, e
+	}
+	if m != nil {
+		m0 := c.meta[name]
+		m.rebound, m.caller = m0.bound, m0.caller
+		c.meta[name] = m
+	}
+	return
+}
+
+func (c *context) Describe(name string) (BindingInfo, error) {
+	key, e := c.checkName(name, false)
+	if e != nil {
+		return BindingInfo{}, e
+	}
+	c.RLock()
+	closed, masked := c.closed, c.masks[key]
+	info, ok := c.info(key)
+	c.RUnlock()
+	if closed {
+		return BindingInfo{}, IllegalStateError("context is closed")
+	}
+	if ok {
+		return info, nil
+	}
+	if masked {
+		return BindingInfo{}, NoSuchBindingError(fmt.Sprintf("%s is masked", key))
+	}
+
+	switch p := c.parent.(type) {
+	case nil:
+	case Describable:
+		return p.Describe(key)
+	default:
+		v, e := p.Lookup(key)
+		if e != nil {
+			return BindingInfo{}, e
+		}
+		if v != nil {
+			return BindingInfo{Name: key, Value: v}, nil
+		}
+	}
+	return BindingInfo{}, NoSuchBindingError(key)
+}
+
+// returns the metadata of the binding of the context, if bound.
+// REVU: c must be (read) locked.
+func (c *context) info(name string) (BindingInfo, bool) {
+	value, ok := c.get(name)
+	if !ok {
+		return BindingInfo{}, false
+	}
+	info := BindingInfo{Name: name, Value: value, Context: c, Expires: c.deadlines[name]}
+	if m := c.meta[name]; m != nil {
+		info.Bound, info.Rebound = m.bound, m.rebound
+		info.Caller, info.Source = m.caller, m.source
+		info.Final = m.final
+		if len(m.annotations) > 0 {
+			info.Annotations = make(map[string]string, len(m.annotations))
+			for k, v := range m.annotations {
+				info.Annotations[k] = v
+			}
+		}
+	}
+	return info, true
+}
+
+func (c *context) Bindings() []BindingInfo {
+	var infos []BindingInfo
+	if p, ok := c.parent.(Describable); ok {
+		infos = p.Bindings()
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+	if c.closed {
+		return nil
+	}
+	visible := infos[:0]
+	for _, info := range infos {
+		if _, ok := c.get(info.Name); !ok && !c.masks[info.Name] {
+			visible = append(visible, info)
+		}
+	}
+	infos = visible
+	for name := range c.bindings {
+		if info, ok := c.info(name); ok {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+func (c *context) SetSource(name, source string) error {
+	return c.update(name, func(m *bindingMeta) { m.source = source })
+}
+
+func (c *context) Annotate(name, key, value string) error {
+	return c.update(name, func(m *bindingMeta) {
+		if value == "" {
+			delete(m.annotations, key)
+			return
+		}
+		if m.annotations == nil {
+			m.annotations = make(map[string]string)
+		}
+		m.annotations[key] = value
+	})
+}
+
+// updates the metadata of the binding of the context.
+func (c *context) update(name string, fn func(*bindingMeta)) error {
+	name, e := c.checkName(name, false)
+	if e != nil {
+		return e
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return IllegalStateError("context is closed")
+	}
+	if _, ok := c.get(name); !ok {
+		return NoSuchBindingError(name)
+	}
+	m := c.meta[name]
+	if m == nil {
+		m = &bindingMeta{}
+		c.meta[name] = m
+	}
+	fn(m)
+	return nil
+}
+
+// Dump writes the bindings visible from the context, and their metadata, to
+// the writer; one binding per line, by name.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx is not Describable
+//	(and those of the writer)
+func Dump(w io.Writer, ctx Context) error {
+	d, ok := ctx.(Describable)
+	if !ok {
+		return IllegalArgumentError(fmt.Sprintf("context %T is not Describable", ctx))
+	}
+	for _, info := range d.Bindings() {
+		if _, e := fmt.Fprintln(w, info); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// String returns the binding and its metadata, e.g.
+//
+//	token = "abc" (depth 1; bound 2016-01-02T15:04:05Z; source env; caller main.go:12; owner=ops)
+func (info BindingInfo) String() string {
+	var meta []string
+	if info.Context != nil {
+		meta = append(meta, fmt.Sprintf("depth %d", info.Context.Depth()))
+	}
+	for _, t := range []struct {
+		label string
+		time  time.Time
+	}{{"bound", info.Bound}, {"rebound", info.Rebound}, {"expires", info.Expires}} {
+		if !t.time.IsZero() {
+			meta = append(meta, t.label+" "+t.time.Format(time.RFC3339Nano))
+		}
+	}
+	if info.Source != "" {
+		meta = append(meta, "source "+info.Source)
+	}
+	if info.Caller != "" {
+		meta = append(meta, "caller "+info.Caller)
+	}
+	keys := make([]string, 0, len(info.Annotations))
+	for k := range info.Annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		meta = append(meta, k+"="+info.Annotations[k])
+	}
+	if len(meta) == 0 {
+		return fmt.Sprintf("%s = %#v", info.Name, info.Value)
+	}
+	return fmt.Sprintf("%s = %#v (%s)", info.Name, info.Value, strings.Join(meta, "; "))
+}
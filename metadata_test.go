@@ -0,0 +1,112 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual_test
+
+import (
+	"bytes"
+	"goerror"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alphazero/contextual"
+	"github.com/alphazero/contextual/contextualtest"
+)
+
+// ============================================================================
+// testing: binding metadata
+// ============================================================================
+
+func TestDescribe(t *testing.T) {
+	clock := contextualtest.NewFakeClock(time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC))
+	root := contextual.NewContext(contextual.WithClock(clock), contextual.RecordCallers(true))
+	child, _ := contextual.ChildContext(root)
+	d := child.(contextual.Describable)
+
+	t0 := clock.Now()
+	root.Bind("dsn", "postgres://")
+	root.(contextual.Describable).SetSource("dsn", "env")
+	child.Bind("port", 8080)
+	d.Annotate("port", "owner", "ops")
+
+	info, e := d.Describe("dsn")
+	if e != nil {
+		t.Fatalf("Describe - unexpected error: %s", e)
+	}
+	if info.Value != "postgres://" || info.Context != root || !info.Bound.Equal(t0) || info.Source != "env" {
+		t.Fatalf("Describe - unexpected: %+v", info)
+	}
+	if !strings.Contains(info.Caller, "metadata_test.go:") {
+		t.Fatalf("Describe - caller is not the test: %s", info.Caller)
+	}
+
+	// callers are recorded only if asked for
+	other := contextual.NewContext()
+	other.Bind("dsn", "postgres://")
+	if info, _ = other.(contextual.Describable).Describe("dsn"); info.Caller != "" {
+		t.Fatalf("Describe - caller recorded by default: %s", info.Caller)
+	}
+
+	// rebinding keeps the metadata, and records the time of the rebind
+	clock.Advance(time.Minute)
+	child.Rebind("port", 9090)
+	info, _ = d.Describe("port")
+	if info.Value != 9090 || !info.Bound.Equal(t0) || !info.Rebound.Equal(t0.Add(time.Minute)) ||
+		!reflect.DeepEqual(info.Annotations, map[string]string{"owner": "ops"}) {
+		t.Fatalf("Describe(rebound) - unexpected: %+v", info)
+	}
+	d.Annotate("port", "owner", "")
+	if info, _ = d.Describe("port"); len(info.Annotations) != 0 {
+		t.Fatalf("Annotate - annotation is not removed: %v", info.Annotations)
+	}
+
+	child.(contextual.Expiring).BindTTL("token", "t", time.Hour)
+	if info, _ = d.Describe("token"); !info.Expires.Equal(clock.Now().Add(time.Hour)) {
+		t.Fatalf("Describe(expiring) - unexpected: %+v", info)
+	}
+
+	if _, e := d.Describe("none"); e == nil || !goerror.TypeOf(e).Is(contextual.NoSuchBindingError) {
+		t.Fatalf("Describe(unbound) - expected error: %s got: %v", contextual.NoSuchBindingError(), e)
+	}
+	if e := d.SetSource("dsn", "x"); e == nil {
+		t.Fatalf("SetSource(parent binding) - expected error: %s", contextual.NoSuchBindingError())
+	}
+
+	// bindings of foreign parents are described by name and value only
+	child, _ = contextual.ChildContext(foreign{root})
+	if info, _ = child.(contextual.Describable).Describe("dsn"); info.Value != "postgres://" || info.Context != nil {
+		t.Fatalf("Describe(foreign) - unexpected: %+v", info)
+	}
+}
+
+func TestDump(t *testing.T) {
+	clock := contextualtest.NewFakeClock(time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC))
+	root := contextual.NewContext(contextual.WithClock(clock), contextual.RecordCallers(true))
+	child, _ := contextual.ChildContext(root)
+	root.Bind("b", 1)
+	root.Bind("a", "x")
+	child.Bind("a", "y")
+	child.(contextual.Describable).SetSource("a", "file:/etc/app.json")
+
+	infos := child.(contextual.Describable).Bindings()
+	if len(infos) != 2 || infos[0].Value != "y" || infos[1].Name != "b" {
+		t.Fatalf("Bindings - unexpected: %v", infos)
+	}
+
+	var buf bytes.Buffer
+	if e := contextual.Dump(&buf, child); e != nil {
+		t.Fatalf("Dump - unexpected error: %s", e)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 ||
+		!strings.HasPrefix(lines[0], `a = "y" (depth 1; bound 2016-01-02T15:04:05Z; source file:/etc/app.json; caller `) ||
+		!strings.HasPrefix(lines[1], `b = 1 (depth 0; bound 2016-01-02T15:04:05Z; caller `) {
+		t.Fatalf("Dump - unexpected:\n%s", buf.String())
+	}
+	if e := contextual.Dump(&buf, foreign{root}); e == nil {
+		t.Fatalf("Dump(foreign) - expected error: %s", contextual.IllegalArgumentError())
+	}
+}
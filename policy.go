@@ -0,0 +1,150 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// the binding policy of a context. The policy is fixed when the context is
+// created, and is inherited by its in-memory children unless overridden by
+// their options. The zero value is the default policy.
+//
+// The names that the package binds (e.g. BusBinding) are exempt from the
+// policy: they are not normalized nor validated, and their bindings are not
+// limited by MaxBindings, nor checked per the schema of the context.
+type policy struct {
+	validRune   func(r rune) bool // of names; nil for any
+	maxNameLen  int               // in runes; 0 for none
+	normalize   func(name string) string
+	allowNil    bool
+	overwrite   bool
+	maxBindings int // of the context; 0 for none
+	maxDepth    int // of the hierarchy; 0 for none
+}
+
+// NameCharset restricts the names of bindings of the context to those of
+// the runes for which valid returns true, e.g. unicode.IsLetter. A nil func
+// allows any name.
+func NameCharset(valid func(r rune) bool) ContextOption {
+	return func(c *context) { c.policy.validRune = valid }
+}
+
+// MaxNameLength restricts the names of bindings of the context to n runes;
+// 0 for no limit.
+func MaxNameLength(n int) ContextOption {
+	return func(c *context) { c.policy.maxNameLen = n }
+}
+
+// NormalizeNames sets the func that normalizes names of all ops of the
+// context before they are validated and used, e.g. strings.ToLower for
+// case-insensitive names. A nil func does not normalize names. Lookups that
+// reach the ancestors of the context look up the normalized name, whatever
+// the policies of the ancestors.
+func NormalizeNames(fn func(name string) string) ContextOption {
+	return func(c *context) { c.policy.normalize = fn }
+}
+
+// AllowNil allows (or forbids) nil values in the context. A nil binding is a
+// binding: it shadows the bindings of ancestors, and is counted by Size.
+func AllowNil(allow bool) ContextOption {
+	return func(c *context) { c.policy.allowNil = allow }
+}
+
+// OverwriteOnBind has Bind (and BindTTL, BindFinal) replace the value bound
+// to the name, rather than fail with AlreadyBoundError.
+func OverwriteOnBind(overwrite bool) ContextOption {
+	return func(c *context) { c.policy.overwrite = overwrite }
+}
+
+// MaxBindings limits the number of bindings of the context; 0 for no limit.
+// Binds in excess of the limit fail with IllegalStateError. The bindings of
+// the names that the package binds (e.g. BusBinding) are not counted.
+func MaxBindings(n int) ContextOption {
+	return func(c *context) { c.policy.maxBindings = n }
+}
+
+// MaxDepth limits the depth of the hierarchy; 0 for no limit. ChildContext
+// fails with IllegalStateError for children deeper than the limit.
+func MaxDepth(n int) ContextOption {
+	return func(c *context) { c.policy.maxDepth = n }
+}
+
+// returns the normalized name. The name of a binding is validated per the
+// policy if binding is set.
+func (c *context) checkName(name string, binding bool) (string, error) {
+	if name == "" {
+		return "", NilNameError()
+	}
+	if reserved(name) {
+		return name, nil
+	}
+	if c.policy.normalize != nil {
+		if name = c.policy.normalize(name); name == "" {
+			return "", NilNameError()
+		}
+	}
+	if !binding {
+		return name, nil
+	}
+	if n := c.policy.maxNameLen; n > 0 && utf8.RuneCountInString(name) > n {
+		return "", IllegalArgumentError(fmt.Sprintf("name %q is longer than %d", name, n))
+	}
+	if valid := c.policy.validRune; valid != nil {
+		for _, r := range name {
+			if !valid(r) {
+				return "", IllegalArgumentError(fmt.Sprintf("name %q: %q is not allowed", name, r))
+			}
+		}
+	}
+	return name, nil
+}
+
+// returns true if the name is one that the package binds.
+func reserved(name string) bool {
+	switch name {
+	case BusBinding, FailureReporterBinding:
+		return true
+	}
+	if depth, ok := strings.CutPrefix(name, registryPrefix); ok {
+		n, e := strconv.Atoi(depth)
+		return e == nil && n >= 0 && registryBinding(n) == name
+	}
+	return false
+}
+
+// returns the number of the bindings of the context of reserved names.
+// REVU: c must be (read) locked.
+func (c *context) reservedBindings() int {
+	n := 0
+	for name := range c.bindings {
+		if reserved(name) {
+			n++
+		}
+	}
+	return n
+}
+
+// checks the name and value of a rebind, and returns the normalized name.
+func (c *context) checkRebind(name string, value interface{}) (string, error) {
+	if name == "" {
+		return "", NilNameError()
+	}
+	if e := c.checkValue(value); e != nil {
+		return "", e
+	}
+	return c.checkName(name, true)
+}
+
+// checks the value of a binding per the policy.
+func (c *context) checkValue(value interface{}) error {
+	if value == nil && !c.policy.allowNil {
+		return NilValueError()
+	}
+	return nil
+}
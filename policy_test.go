@@ -0,0 +1,153 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// ============================================================================
+// testing: context policies
+// ============================================================================
+
+func TestNamePolicy(t *testing.T) {
+	charset := func(r rune) bool { return unicode.IsLower(r) || r == '.' }
+	root := NewContext(NameCharset(charset), MaxNameLength(8), NormalizeNames(strings.ToLower))
+
+	if e := root.Bind("App.Name", "x"); e != nil {
+		t.Fatalf("Bind - unexpected error: %s", e)
+	}
+	for _, name := range []string{"app.name", "APP.NAME"} {
+		if v, _ := root.Lookup(name); v != "x" {
+			t.Fatalf("Lookup(%s) - names are not normalized: %v", name, v)
+		}
+	}
+	assertError(t, "Bind(charset)", root.Bind("app_name", 1), IllegalArgumentError)
+	assertError(t, "Bind(length)", root.Bind("app.names", 1), IllegalArgumentError)
+	_, e := root.Rebind("app-name", 1)
+	assertError(t, "Rebind(charset)", e, IllegalArgumentError)
+	assertError(t, "Bind(empty)", root.Bind("", 1), NilNameError)
+
+	// children inherit the policy, unless overridden
+	child, _ := ChildContext(root)
+	assertError(t, "Bind(inherited)", child.Bind("app_name", 1), IllegalArgumentError)
+	if v, _ := child.Lookup("APP.name"); v != "x" {
+		t.Fatalf("Lookup - inherited normalization: %v", v)
+	}
+	lax, _ := ChildContext(root, NameCharset(nil), MaxNameLength(0))
+	if e := lax.Bind("App_Name_Long", 1); e != nil {
+		t.Fatalf("Bind(overridden) - unexpected error: %s", e)
+	}
+	if v, _ := lax.LookupN("app_name_long", 0); v != 1 {
+		t.Fatalf("LookupN - unexpected: %v", v)
+	}
+}
+
+func TestMixedNamePolicy(t *testing.T) {
+	root := NewContext()
+	root.Bind("foo", 1)
+	child, _ := ChildContext(root, NormalizeNames(strings.ToLower))
+
+	// the ancestors are looked up with the normalized name
+	if v, _ := child.Lookup("Foo"); v != 1 {
+		t.Fatalf("Lookup - expected:1 got:%v", v)
+	}
+	if v, _ := child.LookupN("FOO", 1); v != 1 {
+		t.Fatalf("LookupN - expected:1 got:%v", v)
+	}
+	if info, e := child.(Describable).Describe("Foo"); e != nil || info.Value != 1 {
+		t.Fatalf("Describe - unexpected: %v, %v", info, e)
+	}
+	if v, _ := root.Lookup("Foo"); v != nil {
+		t.Fatalf("Lookup(root) - unexpected normalization: %v", v)
+	}
+	root.(FinalBinder).BindFinal("bar", 2)
+	if !child.(FinalBinder).IsFinal("Bar") {
+		t.Fatalf("IsFinal - expected final binding of the parent")
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	root := NewContext(NameCharset(unicode.IsLower), MaxBindings(1))
+	s := NewSupervisor(OneForOne)
+	s.SetContext(root)
+
+	// the bindings of the package are exempt from the policy
+	comp := &testComponent{name: "a"}
+	if e := s.Add(comp); e != nil {
+		t.Fatalf("Add - unexpected error: %s", e)
+	}
+	if e := comp.context.Bind("x", 1); e != nil {
+		t.Fatalf("Bind - unexpected error: %s", e)
+	}
+	assertError(t, "Bind(limit)", comp.context.Bind("y", 2), IllegalStateError)
+	assertError(t, "Bind(charset)", comp.context.Bind("contextual.x", 2), IllegalArgumentError)
+	if e := RegisterService(comp.context, &kvStore{"a"}, As[storage]()); e != nil {
+		t.Fatalf("RegisterService - unexpected error: %s", e)
+	}
+}
+
+func TestValuePolicy(t *testing.T) {
+	root := NewContext(AllowNil(true), OverwriteOnBind(true), MaxBindings(2))
+	root.Bind("a", 1)
+	child, _ := ChildContext(root)
+
+	// a nil binding is a binding
+	if e := child.Bind("a", nil); e != nil {
+		t.Fatalf("Bind(nil) - unexpected error: %s", e)
+	}
+	if v, e := child.Lookup("a"); v != nil || e != nil {
+		t.Fatalf("Lookup(nil binding) - expected nil got: %v, %v", v, e)
+	}
+	if child.Size() != 2 || child.IsEmpty() {
+		t.Fatalf("Size - nil binding is not counted: %d", child.Size())
+	}
+	if v, e := child.Unbind("a"); v != nil || e != nil {
+		t.Fatalf("Unbind(nil binding) - unexpected: %v, %v", v, e)
+	}
+	if v, _ := child.Lookup("a"); v != 1 {
+		t.Fatalf("Lookup - expected:1 got:%v", v)
+	}
+
+	// bind overwrites
+	var changes []Change
+	root.(Watchable).Watch(func(ch []Change) { changes = append(changes, ch...) })
+	if e := root.Bind("a", 2); e != nil {
+		t.Fatalf("Bind(overwrite) - unexpected error: %s", e)
+	}
+	if len(changes) != 1 || changes[0] != (Change{root, "a", 1, 2, ChangeBinding}) {
+		t.Fatalf("Bind(overwrite) - unexpected changes: %v", changes)
+	}
+
+	root.Bind("b", 1)
+	assertError(t, "Bind(max bindings)", root.Bind("c", 1), IllegalStateError)
+	if e := root.Bind("b", 2); e != nil {
+		t.Fatalf("Bind(overwrite at max) - unexpected error: %s", e)
+	}
+
+	strict, _ := ChildContext(root, AllowNil(false), OverwriteOnBind(false), MaxBindings(0))
+	assertError(t, "Bind(nil)", strict.Bind("a", nil), NilValueError)
+	strict.Bind("a", 1)
+	assertError(t, "Bind(bound)", strict.Bind("a", 2), AlreadyBoundError)
+}
+
+func TestMaxDepth(t *testing.T) {
+	root := NewContext(MaxDepth(2))
+	child, e := ChildContext(root)
+	if e != nil {
+		t.Fatalf("ChildContext - unexpected error: %s", e)
+	}
+	grandchild, e := ChildContext(child)
+	if e != nil {
+		t.Fatalf("ChildContext - unexpected error: %s", e)
+	}
+	_, e = ChildContext(grandchild)
+	assertError(t, "ChildContext(max depth)", e, IllegalStateError)
+	if _, e = ChildContext(grandchild, MaxDepth(0)); e != nil {
+		t.Fatalf("ChildContext(overridden) - unexpected error: %s", e)
+	}
+}
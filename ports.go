@@ -0,0 +1,375 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// PortDirection is the direction of a port, relative to its component.
+type PortDirection int
+
+const (
+	PortIn PortDirection = iota
+	PortOut
+)
+
+func (d PortDirection) String() string {
+	switch d {
+	case PortIn:
+		return "in"
+	case PortOut:
+		return "out"
+	}
+	return fmt.Sprintf("direction(%d)", int(d))
+}
+
+// DefaultPortCapacity is the buffer capacity of ports that do not specify
+// a capacity.
+const DefaultPortCapacity = 16
+
+// PortSpec declares a named and typed port of a component.
+type PortSpec struct {
+	Name     string
+	Dir      PortDirection
+	Type     reflect.Type // element type
+	Capacity int          // channel buffer capacity; DefaultPortCapacity if 0
+}
+
+// Ported components declare their ports. The container binds a channel for
+// each declared port in the component's context: a send-only channel
+// (chan<- T) for an output port, and a receive-only channel (<-chan T) for
+// an input port. See InPort and OutPort.
+//
+// Data sent on an output port is delivered to every input port wired to
+// it. A full input port blocks the delivery, and thus (once its buffer is
+// full) the output port.
+//
+// Data is forwarded once the component is started. Data sent before then
+// (e.g. on Init) is buffered in the output port, and sends in excess of its
+// capacity block until the component is started.
+//
+// When a component is stopped, the container closes its output ports, and
+// the input ports wired to them are closed once pending data is delivered.
+// When the component is restarted, its output ports and the input ports
+// wired to them are renewed; consumers should look up their input ports
+// when they are started. Data of an output port is not delivered to input
+// ports that are unwired, e.g. of a removed component.
+type Ported interface {
+	Ports() []PortSpec
+}
+
+// PortBinding returns the name of the binding of the port in the context of
+// its component.
+func PortBinding(dir PortDirection, name string) string {
+	return "port." + dir.String() + "." + name
+}
+
+// InPort returns the named input port bound in the context.
+//
+// Errors:
+//
+//	NoSuchBindingError <= no such port
+//	IllegalArgumentError <= port element type is not T
+func InPort[T any](ctx Context, name string) (<-chan T, error) {
+	v, e := ctx.Lookup(PortBinding(PortIn, name))
+	if e != nil {
+		return nil, e
+	}
+	if v == nil {
+		return nil, NoSuchBindingError(PortBinding(PortIn, name))
+	}
+	ch, ok := v.(<-chan T)
+	if !ok {
+		return nil, IllegalArgumentError(fmt.Sprintf("port %s is %T", name, v))
+	}
+	return ch, nil
+}
+
+// OutPort returns the named output port bound in the context.
+//
+// Errors:
+//
+//	NoSuchBindingError <= no such port
+//	IllegalArgumentError <= port element type is not T
+func OutPort[T any](ctx Context, name string) (chan<- T, error) {
+	v, e := ctx.Lookup(PortBinding(PortOut, name))
+	if e != nil {
+		return nil, e
+	}
+	if v == nil {
+		return nil, NoSuchBindingError(PortBinding(PortOut, name))
+	}
+	ch, ok := v.(chan<- T)
+	if !ok {
+		return nil, IllegalArgumentError(fmt.Sprintf("port %s is %T", name, v))
+	}
+	return ch, nil
+}
+
+// ----------------------------------------------------------------------------
+// container ports
+// ----------------------------------------------------------------------------
+
+// a port of an entry. Ports are guarded by the container lock.
+type port struct {
+	spec       PortSpec
+	owner      *entry
+	ch         reflect.Value // bidirectional channel
+	closed     bool          // closed when the (source) component is stopped
+	forwarding bool          // output port: forwarder is running
+	targets    []*port       // output port: wired input ports
+	source     *port         // input port: wired output port
+	cut        chan struct{} // input port: closed when unwired or renewed
+}
+
+// creates the channel of the port and binds it in the owner's context.
+func (p *port) open() error {
+	capacity := p.spec.Capacity
+	if capacity == 0 {
+		capacity = DefaultPortCapacity
+	}
+	dir := reflect.RecvDir
+	if p.spec.Dir == PortOut {
+		dir = reflect.SendDir
+	}
+	renew := p.ch.IsValid()
+	p.ch = reflect.MakeChan(reflect.ChanOf(reflect.BothDir, p.spec.Type), capacity)
+	p.closed = false
+	if p.spec.Dir == PortIn {
+		p.sever()
+	}
+	name, ch := PortBinding(p.spec.Dir, p.spec.Name), p.ch.Convert(reflect.ChanOf(dir, p.spec.Type)).Interface()
+	if renew {
+		_, e := p.owner.context.Rebind(name, ch)
+		return e
+	}
+	return p.owner.context.Bind(name, ch)
+}
+
+// stops the delivery of data to the current channel of the input port.
+func (p *port) sever() {
+	if p.cut != nil {
+		close(p.cut)
+	}
+	p.cut = make(chan struct{})
+}
+
+// creates and binds the ports declared by the component of the entry.
+func declarePorts(e *entry) error {
+	ported, ok := e.component.(Ported)
+	if !ok {
+		return nil
+	}
+	e.ports = make(map[string]*port)
+	for _, spec := range ported.Ports() {
+		switch {
+		case spec.Name == "":
+			return NilNameError("port name")
+		case spec.Type == nil:
+			return IllegalArgumentError(fmt.Sprintf("port %s: type is nil", spec.Name))
+		case spec.Dir != PortIn && spec.Dir != PortOut:
+			return IllegalArgumentError(fmt.Sprintf("port %s: %s", spec.Name, spec.Dir))
+		case spec.Capacity < 0:
+			return IllegalArgumentError(fmt.Sprintf("port %s: capacity is negative", spec.Name))
+		}
+		if _, ok := e.ports[spec.Name]; ok {
+			return AlreadyBoundError(fmt.Sprintf("port %s", spec.Name))
+		}
+		p := &port{spec: spec, owner: e}
+		if err := p.open(); err != nil {
+			return err
+		}
+		e.ports[spec.Name] = p
+	}
+	return nil
+}
+
+// unwires the ports of the entry.
+// REVU: c must be locked.
+func unwirePorts(e *entry) {
+	for _, p := range e.ports {
+		if src := p.source; src != nil {
+			for i, t := range src.targets {
+				if t == p {
+					src.targets = append(src.targets[:i], src.targets[i+1:]...)
+					break
+				}
+			}
+			p.source = nil
+			p.sever()
+		}
+		for _, t := range p.targets {
+			t.source = nil
+			t.sever()
+		}
+		p.targets = nil
+	}
+}
+
+func (c *container) Wire(from, out, to, in string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	src, e := c.port(from, out, PortOut)
+	if e != nil {
+		return e
+	}
+	dst, e := c.port(to, in, PortIn)
+	if e != nil {
+		return e
+	}
+	if !src.spec.Type.AssignableTo(dst.spec.Type) {
+		return IllegalArgumentError(fmt.Sprintf("%s.%s (%s) => %s.%s (%s)", from, out, src.spec.Type, to, in, dst.spec.Type))
+	}
+	if dst.source != nil {
+		return AlreadyBoundError(fmt.Sprintf("%s.%s is wired to %s.%s", to, in, dst.source.owner.name, dst.source.spec.Name))
+	}
+	for _, e := range []*entry{src.owner, dst.owner} {
+		if e.state == StateStarted {
+			return IllegalStateError(fmt.Sprintf("%s: component is started", e.name))
+		}
+	}
+	src.targets = append(src.targets, dst)
+	dst.source = src
+	return nil
+}
+
+// returns the named port of the named component.
+// REVU: c must be locked.
+func (c *container) port(component, name string, dir PortDirection) (*port, error) {
+	e, ok := c.entries[component]
+	if !ok {
+		return nil, NoSuchBindingError(component)
+	}
+	p, ok := e.ports[name]
+	if !ok {
+		return nil, NoSuchBindingError(fmt.Sprintf("%s.%s", component, name))
+	}
+	if p.spec.Dir != dir {
+		return nil, IllegalArgumentError(fmt.Sprintf("%s.%s is an %s port", component, name, p.spec.Dir))
+	}
+	return p, nil
+}
+
+// renews the closed output ports of the entry, along with the input ports
+// wired to them, and the closed input ports wired to its open output ports.
+// Called before the component is started.
+func (c *container) renewPorts(e *entry) error {
+	c.Lock()
+	defer c.Unlock()
+	for _, p := range e.ports {
+		if p.spec.Dir != PortOut {
+			continue
+		}
+		renew := p.closed
+		if renew {
+			if err := p.open(); err != nil {
+				return err
+			}
+		}
+		for _, t := range p.targets {
+			if !renew && !t.closed {
+				continue
+			}
+			if err := t.open(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// starts the forwarders of the output ports of the entry. Called after the
+// component is started; data sent by the component before then is buffered.
+func (c *container) forwardPorts(e *entry) {
+	c.Lock()
+	defer c.Unlock()
+	for _, p := range e.ports {
+		if p.spec.Dir != PortOut || p.forwarding {
+			continue
+		}
+		p.forwarding = true
+		go c.forward(p, p.ch)
+	}
+}
+
+// the channel of a wired input port, as of a delivery.
+type target struct {
+	port *port
+	ch   reflect.Value
+	cut  chan struct{}
+}
+
+// returns the targets of the output port, unless its channel is no longer
+// out, i.e. it has been renewed.
+func (c *container) targets(p *port, out reflect.Value) ([]target, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if p.ch != out {
+		return nil, false
+	}
+	targets := make([]target, len(p.targets))
+	for i, t := range p.targets {
+		targets[i] = target{t, t.ch, t.cut}
+	}
+	return targets, true
+}
+
+// delivers data received on the output channel to the input channels, and
+// closes the input channels once the output channel is closed. The targets
+// are read per delivery, and a delivery to an input port that is unwired
+// (or renewed) while blocked is dropped. Data of unwired output ports is
+// discarded.
+func (c *container) forward(p *port, out reflect.Value) {
+	var targets []target
+	for {
+		v, ok := out.Recv()
+		if !ok {
+			break
+		}
+		if current, ok := c.targets(p, out); ok {
+			targets = current
+		}
+		for _, t := range targets {
+			reflect.Select([]reflect.SelectCase{
+				{Dir: reflect.SelectSend, Chan: t.ch, Send: v},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(t.cut)},
+			})
+		}
+	}
+	if current, ok := c.targets(p, out); ok {
+		targets = current
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, t := range targets {
+		// channels of input ports that are since wired to another output
+		// port are not closed
+		switch {
+		case t.port.ch != t.ch:
+			t.ch.Close()
+		case t.port.source == p && !t.port.closed:
+			t.ch.Close()
+			t.port.closed = true
+		}
+	}
+}
+
+// closes the output ports of the entry. Called after the component is
+// stopped.
+func (c *container) closePorts(e *entry) {
+	c.Lock()
+	defer c.Unlock()
+	for _, p := range e.ports {
+		if p.spec.Dir != PortOut || p.closed {
+			continue
+		}
+		p.ch.Close()
+		p.closed = true
+		p.forwarding = false
+	}
+}
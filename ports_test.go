@@ -0,0 +1,225 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// testing: component ports
+// ============================================================================
+
+// helper - a component with declared ports
+type portedComponent struct {
+	testComponent
+	ports []PortSpec
+}
+
+func (c *portedComponent) Ports() []PortSpec { return c.ports }
+
+func newPortedComponent(name string, ports ...PortSpec) *portedComponent {
+	return &portedComponent{testComponent{name: name}, ports}
+}
+
+var (
+	intType    = reflect.TypeOf(0)
+	stringType = reflect.TypeOf("")
+	stringer   = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// helper - receives n values from the port, or fails on timeout.
+func receive[T any](t *testing.T, ch <-chan T, n int) []T {
+	var values []T
+	for i := 0; i < n; i++ {
+		select {
+		case v := <-ch:
+			values = append(values, v)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("receive - timeout after %d values", len(values))
+		}
+	}
+	return values
+}
+
+// helper - asserts that the port is closed.
+func assertClosed[T any](t *testing.T, ch <-chan T) {
+	select {
+	case v, ok := <-ch:
+		if ok {
+			t.Fatalf("port - expected closed got: %v", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("port - expected closed")
+	}
+}
+
+func newPipeline(t *testing.T) (Container, *portedComponent, *portedComponent, *portedComponent) {
+	c, _ := newTestContainer(t)
+	src := newPortedComponent("src", PortSpec{Name: "out", Dir: PortOut, Type: intType, Capacity: 1})
+	a := newPortedComponent("a", PortSpec{Name: "in", Dir: PortIn, Type: intType})
+	b := newPortedComponent("b", PortSpec{Name: "in", Dir: PortIn, Type: intType})
+	for _, comp := range []Component{src, a, b} {
+		if e := c.Add(comp); e != nil {
+			t.Fatalf("Add - unexpected error: %s", e)
+		}
+	}
+	for _, to := range []string{"a", "b"} {
+		if e := c.Wire("src", "out", to, "in"); e != nil {
+			t.Fatalf("Wire - unexpected error: %s", e)
+		}
+	}
+	return c, src, a, b
+}
+
+func TestPorts(t *testing.T) {
+	c, src, a, b := newPipeline(t)
+	if e := c.Start(); e != nil {
+		t.Fatalf("Start - unexpected error: %s", e)
+	}
+
+	out, e := OutPort[int](src.context, "out")
+	if e != nil {
+		t.Fatalf("OutPort - unexpected error: %s", e)
+	}
+	inA, _ := InPort[int](a.context, "in")
+	inB, _ := InPort[int](b.context, "in")
+	for i := 1; i <= 3; i++ {
+		out <- i
+	}
+	// fan-out
+	for _, in := range []<-chan int{inA, inB} {
+		if values := receive(t, in, 3); !reflect.DeepEqual(values, []int{1, 2, 3}) {
+			t.Fatalf("receive - expected:%v got:%v", []int{1, 2, 3}, values)
+		}
+	}
+
+	// stopping the producer closes its consumers' inputs
+	if e := c.Transition("src", StateStopped); e != nil {
+		t.Fatalf("Transition - unexpected error: %s", e)
+	}
+	assertClosed(t, inA)
+	assertClosed(t, inB)
+
+	// restarting the producer renews the ports
+	if e := c.Transition("src", StateStarted); e != nil {
+		t.Fatalf("Transition - unexpected error: %s", e)
+	}
+	out, _ = OutPort[int](src.context, "out")
+	inA, _ = InPort[int](a.context, "in")
+	out <- 4
+	if values := receive(t, inA, 1); values[0] != 4 {
+		t.Fatalf("receive - expected:4 got:%v", values[0])
+	}
+	c.Stop()
+}
+
+func TestPortUnwiredConsumer(t *testing.T) {
+	c, src, a, b := newPipeline(t)
+	c.Start()
+	defer c.Stop()
+
+	// the removed consumer is not delivered to, and does not block the
+	// delivery to the others
+	c.Transition("b", StateStopped)
+	if e := c.Remove(b); e != nil {
+		t.Fatalf("Remove - unexpected error: %s", e)
+	}
+	out, _ := OutPort[int](src.context, "out")
+	inA, _ := InPort[int](a.context, "in")
+	n := 2 * DefaultPortCapacity
+	go func() {
+		for i := 0; i < n; i++ {
+			out <- i
+		}
+	}()
+	if values := receive(t, inA, n); values[n-1] != n-1 {
+		t.Fatalf("receive - expected:%d got:%v", n-1, values[n-1])
+	}
+}
+
+func TestPortBackpressure(t *testing.T) {
+	c, src, a, _ := newPipeline(t)
+	c.Start()
+	defer c.Stop()
+
+	out, _ := OutPort[int](src.context, "out")
+	inA, _ := InPort[int](a.context, "in")
+
+	// b is not consumed: its buffer, the forwarder, and then the output
+	// port buffer fill up, and the producer blocks.
+	sent := 0
+	for blocked := false; !blocked; {
+		select {
+		case out <- sent:
+			sent++
+		case <-time.After(50 * time.Millisecond):
+			blocked = true
+		}
+	}
+	if expected := DefaultPortCapacity + 2; sent != expected {
+		t.Fatalf("sent - expected:%d got:%d", expected, sent)
+	}
+	receive(t, inA, DefaultPortCapacity)
+}
+
+func TestPortTypes(t *testing.T) {
+	c, _ := newTestContainer(t)
+	c.Add(newPortedComponent("ticker", PortSpec{Name: "out", Dir: PortOut, Type: reflect.TypeOf(time.Duration(0))}))
+	c.Add(newPortedComponent("log", PortSpec{Name: "in", Dir: PortIn, Type: stringer}))
+	c.Add(newPortedComponent("count", PortSpec{Name: "in", Dir: PortIn, Type: intType}))
+
+	// assignable element types
+	if e := c.Wire("ticker", "out", "log", "in"); e != nil {
+		t.Fatalf("Wire - unexpected error: %s", e)
+	}
+	assertError(t, "Wire(type mismatch)", c.Wire("ticker", "out", "count", "in"), IllegalArgumentError)
+
+	comp := c.(*container).entries["log"].component.(*portedComponent)
+	if _, e := InPort[string](comp.context, "in"); e == nil {
+		t.Fatalf("InPort(type mismatch) - expected error: %s", IllegalArgumentError())
+	}
+	if _, e := InPort[fmt.Stringer](comp.context, "in"); e != nil {
+		t.Fatalf("InPort - unexpected error: %s", e)
+	}
+}
+
+func TestWireErrors(t *testing.T) {
+	c, src, _, _ := newPipeline(t)
+	c.Add(newPortedComponent("c", PortSpec{Name: "in", Dir: PortIn, Type: intType}))
+
+	assertError(t, "Wire(no such component)", c.Wire("x", "out", "c", "in"), NoSuchBindingError)
+	assertError(t, "Wire(no such port)", c.Wire("src", "x", "c", "in"), NoSuchBindingError)
+	assertError(t, "Wire(in => in)", c.Wire("a", "in", "c", "in"), IllegalArgumentError)
+	assertError(t, "Wire(wired input)", c.Wire("src", "out", "a", "in"), AlreadyBoundError)
+
+	c.Start()
+	assertError(t, "Wire(started)", c.Wire("src", "out", "c", "in"), IllegalStateError)
+	c.Stop()
+
+	// removing a component unwires its ports
+	if e := c.Remove(src); e != nil {
+		t.Fatalf("Remove - unexpected error: %s", e)
+	}
+	c.Add(newPortedComponent("src", PortSpec{Name: "out", Dir: PortOut, Type: intType}))
+	if e := c.Wire("src", "out", "a", "in"); e != nil {
+		t.Fatalf("Wire - unexpected error: %s", e)
+	}
+
+	// invalid port declarations
+	for _, spec := range []PortSpec{
+		{Name: "", Dir: PortIn, Type: intType},
+		{Name: "p", Dir: PortIn},
+		{Name: "p", Dir: PortDirection(7), Type: intType},
+		{Name: "p", Dir: PortIn, Type: intType, Capacity: -1},
+	} {
+		assertError(t, fmt.Sprintf("Add(%v)", spec), c.Add(newPortedComponent("bad", spec)), IllegalArgumentError)
+	}
+	dup := PortSpec{Name: "p", Dir: PortIn, Type: stringType}
+	assertError(t, "Add(dup port)", c.Add(newPortedComponent("bad", dup, dup)), AlreadyBoundError)
+}
@@ -0,0 +1,133 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+)
+
+// OnReconfigurationError sets the func that is called with the errors of
+// the reconfiguration of components. The changes, other than expiries, are
+// rolled back regardless; the errors of the rollback, if any, are reported
+// as well.
+func OnReconfigurationError(fn func(name string, err error)) ContainerOption {
+	return func(c *container) { c.reconfigurationError = fn }
+}
+
+// watches the context of a Reconfigurable entry, if Watchable.
+// REVU: c must be locked.
+func (c *container) watch(e *entry) {
+	if _, ok := e.component.(Reconfigurable); !ok {
+		return
+	}
+	if w, ok := e.context.(Watchable); ok {
+		e.unwatch = w.Watch(func(changes []Change) { c.reconfigure(e, changes) })
+	}
+}
+
+// reconfigures the started entry if the changes are of its configuration.
+// Notifications of the changes made by rolling back a failed reconfiguration
+// are ignored.
+func (c *container) reconfigure(e *entry, changes []Change) {
+	comp := e.component.(Reconfigurable)
+	var names []string
+	var relevant []Change
+	for _, name := range comp.Configuration() {
+		changed := false
+		for _, ch := range changes {
+			if ch.Name == name {
+				relevant = append(relevant, ch)
+				changed = true
+			}
+		}
+		if changed {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	c.Lock()
+	if e.reconfiguring || e.state != StateStarted {
+		c.Unlock()
+		return
+	}
+	e.reconfiguring = true
+	c.Unlock()
+	defer func() {
+		c.Lock()
+		e.reconfiguring = false
+		c.Unlock()
+	}()
+
+	// a component in transition is not reconfigured; the transition may
+	// well be what made the changes.
+	if !e.lifecycle.TryLock() {
+		return
+	}
+	defer e.lifecycle.Unlock()
+	c.Lock()
+	started := e.state == StateStarted
+	c.Unlock()
+	if !started {
+		return
+	}
+
+	err := reconfigure(comp, names)
+	if err == nil {
+		return
+	}
+	errors := rollback(relevant)
+	if c.reconfigurationError != nil {
+		c.reconfigurationError(e.name, ReconfigurationError(fmt.Sprintf("%s: %v", e.name, names)).WithCause(err))
+		if len(errors) > 0 {
+			c.reconfigurationError(e.name, ReconfigurationError(fmt.Sprintf("%s: rollback", e.name)).WithCause(errors))
+		}
+	}
+}
+
+// quiesces, reconfigures, and resumes the component.
+func reconfigure(comp Reconfigurable, names []string) error {
+	q, quiescent := comp.(Quiescent)
+	if quiescent {
+		if err := q.Quiesce(); err != nil {
+			return err
+		}
+	}
+	err := comp.Reconfigure(names)
+	if quiescent {
+		if e := q.Resume(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// reverts the changes, in reverse order, and returns the errors. Expired
+// bindings stay expired.
+func rollback(changes []Change) (errors ErrorList) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		ch := changes[i]
+		var e error
+		switch {
+		case ch.Kind == ChangeExpired:
+		case ch.Kind == ChangeMasked:
+			e = ch.Context.(Maskable).Unmask(ch.Name)
+		case ch.Kind == ChangeUnmasked:
+			e = ch.Context.(Maskable).Mask(ch.Name)
+		case ch.Old == nil:
+			_, e = ch.Context.Unbind(ch.Name)
+		case ch.New == nil:
+			e = ch.Context.Bind(ch.Name, ch.Old)
+		default:
+			_, e = ch.Context.Rebind(ch.Name, ch.Old)
+		}
+		if e != nil {
+			errors = append(errors, e)
+		}
+	}
+	return errors
+}
@@ -0,0 +1,202 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"errors"
+	"goerror"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// testing: watches and reconfiguration
+// ============================================================================
+
+func TestWatch(t *testing.T) {
+	root := NewContext()
+	child, _ := ChildContext(root)
+
+	var changes []Change
+	cancel := child.(Watchable).Watch(func(ch []Change) { changes = append(changes, ch...) })
+
+	root.Bind("a", 1)
+	child.Bind("b", 2)
+	root.Rebind("a", 3)
+	child.Bind("a", 4)
+	root.Rebind("a", 5) // shadowed
+	child.Unbind("a")
+
+	expected := []Change{
+		{root, "a", nil, 1, ChangeBinding},
+		{child, "b", nil, 2, ChangeBinding},
+		{root, "a", 1, 3, ChangeBinding},
+		{child, "a", nil, 4, ChangeBinding},
+		{child, "a", 4, nil, ChangeBinding},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Watch - expected:%v got:%v", expected, changes)
+	}
+
+	cancel()
+	cancel()
+	changes = nil
+	root.Bind("c", 1)
+	child.Bind("c", 1)
+	if len(changes) != 0 {
+		t.Fatalf("Watch - cancelled watcher is notified: %v", changes)
+	}
+	if child.(*context).unwatch != nil || len(root.(*context).watchers) != 0 {
+		t.Fatalf("Watch - parent is watched without watchers")
+	}
+}
+
+// helper - a reconfigurable component
+type reconfigurableComponent struct {
+	*lifecycleComponent
+	configuration []string
+	applied       map[string]interface{}
+}
+
+func (c *reconfigurableComponent) Configuration() []string { return c.configuration }
+func (c *reconfigurableComponent) Quiesce() error          { return c.call("quiesce") }
+func (c *reconfigurableComponent) Resume() error           { return c.call("resume") }
+
+func (c *reconfigurableComponent) Reconfigure(changed []string) error {
+	if e := c.call("reconfigure"); e != nil {
+		return e
+	}
+	for _, name := range changed {
+		c.applied[name], _ = c.context.Lookup(name)
+	}
+	return nil
+}
+
+func TestReconfigure(t *testing.T) {
+	var failures []error
+	ctx := NewContext()
+	c := NewContainer(OnReconfigurationError(func(name string, e error) { failures = append(failures, e) }))
+	c.SetContext(ctx)
+	ctx.Bind("rate", 10)
+	ctx.Bind("other", "x")
+
+	log := &callLog{}
+	comp := &reconfigurableComponent{newLifecycleComponent("a", log), []string{"rate", "size"}, make(map[string]interface{})}
+	c.Add(comp)
+
+	// not started
+	ctx.Rebind("rate", 20)
+	c.Start()
+	defer c.Stop()
+	log.calls = nil
+
+	ctx.Rebind("other", "y")
+	assertCalls(t, log)
+
+	ctx.Rebind("rate", 30)
+	comp.context.Bind("size", 5)
+	assertCalls(t, log, "a.quiesce", "a.reconfigure", "a.resume", "a.quiesce", "a.reconfigure", "a.resume")
+	if !reflect.DeepEqual(comp.applied, map[string]interface{}{"rate": 30, "size": 5}) {
+		t.Fatalf("Reconfigure - unexpected configuration: %v", comp.applied)
+	}
+
+	// failed reconfigurations are rolled back
+	log.calls = nil
+	comp.fail["reconfigure"] = errors.New("rate too high")
+	ctx.Rebind("rate", 1000)
+	if v, _ := ctx.Lookup("rate"); v != 30 {
+		t.Fatalf("Reconfigure - change is not rolled back: %v", v)
+	}
+	comp.context.Unbind("size")
+	if v, _ := comp.context.Lookup("size"); v != 5 {
+		t.Fatalf("Reconfigure - unbind is not rolled back: %v", v)
+	}
+	if len(failures) != 2 || !goerror.TypeOf(failures[0]).Is(ReconfigurationError) {
+		t.Fatalf("Reconfigure - expected 2 errors: %s got: %v", ReconfigurationError(), failures)
+	}
+	assertCalls(t, log, "a.quiesce", "a.resume", "a.quiesce", "a.resume")
+	assertState(t, c, "a", StateStarted)
+
+	// masks are rolled back by unmasking, and unmasks by masking
+	m := comp.context.(Maskable)
+	m.Mask("rate")
+	if v, _ := comp.context.Lookup("rate"); v != 30 || m.IsMasked("rate") {
+		t.Fatalf("Reconfigure - mask is not rolled back: %v", v)
+	}
+	if v, _ := comp.context.LookupN("rate", 0); v != nil {
+		t.Fatalf("Reconfigure - mask is rolled back by binding: %v", v)
+	}
+	delete(comp.fail, "reconfigure")
+	m.Mask("rate")
+	comp.fail["reconfigure"] = errors.New("rate required")
+	m.Unmask("rate")
+	if !m.IsMasked("rate") {
+		t.Fatalf("Reconfigure - unmask is not rolled back")
+	}
+}
+
+// helper - a reconfigurable component that binds its configuration when its
+// context is set
+type selfConfiguringComponent struct {
+	*reconfigurableComponent
+}
+
+func (c *selfConfiguringComponent) SetContext(ctx Context) {
+	c.reconfigurableComponent.SetContext(ctx)
+	if ctx != nil {
+		ctx.Bind("rate", 1)
+	}
+}
+
+func TestReconfigureOnAdd(t *testing.T) {
+	c, _ := newTestContainer(t)
+	log := &callLog{}
+	comp := &selfConfiguringComponent{&reconfigurableComponent{newLifecycleComponent("a", log), []string{"rate"}, make(map[string]interface{})}}
+	done := make(chan error, 1)
+	go func() { done <- c.Add(comp) }()
+	select {
+	case e := <-done:
+		if e != nil {
+			t.Fatalf("Add - unexpected error: %s", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Add - deadlock on binding the configuration")
+	}
+	assertCalls(t, log)
+}
+
+// helper - a reconfigurable component that unbinds its configuration, and
+// fails, on reconfiguration
+type unbindingComponent struct {
+	*reconfigurableComponent
+}
+
+func (c *unbindingComponent) Reconfigure(changed []string) error {
+	for _, name := range changed {
+		c.context.Unbind(name)
+	}
+	return errors.New("unbound")
+}
+
+func TestReconfigureRollbackErrors(t *testing.T) {
+	var failures []error
+	ctx := NewContext()
+	c := NewContainer(OnReconfigurationError(func(name string, e error) { failures = append(failures, e) }))
+	c.SetContext(ctx)
+	comp := &unbindingComponent{&reconfigurableComponent{newLifecycleComponent("a", &callLog{}), []string{"size"}, make(map[string]interface{})}}
+	c.Add(comp)
+	c.Start()
+	defer c.Stop()
+
+	// the rollback of the bind fails, as the name is unbound
+	comp.context.Bind("size", 5)
+	if len(failures) != 2 || !goerror.TypeOf(failures[1]).Is(ReconfigurationError) {
+		t.Fatalf("Reconfigure - expected 2 errors: %s got: %v", ReconfigurationError(), failures)
+	}
+	if list := goerror.TypeOf(failures[1]).Cause().(ErrorList); len(list) != 1 || !goerror.TypeOf(list[0]).Is(NoSuchBindingError) {
+		t.Fatalf("Reconfigure - expected rollback error: %s got: %v", NoSuchBindingError(), list)
+	}
+}
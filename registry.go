@@ -0,0 +1,312 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Registration is a service registered in a context.
+type Registration struct {
+	Service  interface{}
+	Types    []reflect.Type // that the service is registered as
+	Version  string
+	Tags     []string
+	Priority int // of the service among the providers of a type
+	Context  Context
+}
+
+// HasTag returns true if the service is tagged with the tag.
+func (r *Registration) HasTag(tag string) bool {
+	for _, t := range r.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceOption sets the types and metadata of a registration.
+type ServiceOption func(*Registration)
+
+// As registers the service as a provider of type T, typically an interface
+// that the service implements.
+func As[T any]() ServiceOption {
+	return func(r *Registration) {
+		r.Types = append(r.Types, reflect.TypeOf((*T)(nil)).Elem())
+	}
+}
+
+// Version sets the version of the service.
+func Version(v string) ServiceOption {
+	return func(r *Registration) { r.Version = v }
+}
+
+// Tags tags the service.
+func Tags(tags ...string) ServiceOption {
+	return func(r *Registration) { r.Tags = append(r.Tags, tags...) }
+}
+
+// Priority sets the priority of the service: of the providers of a type in
+// a context, the providers of higher priority are found first. The default
+// is 0.
+func Priority(p int) ServiceOption {
+	return func(r *Registration) { r.Priority = p }
+}
+
+// ServiceFilter selects the registrations that Find considers.
+type ServiceFilter func(*Registration) bool
+
+// Tagged selects the services that are tagged with all of the tags.
+func Tagged(tags ...string) ServiceFilter {
+	return func(r *Registration) bool {
+		for _, tag := range tags {
+			if !r.HasTag(tag) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// WithVersion selects the services of the version.
+func WithVersion(v string) ServiceFilter {
+	return func(r *Registration) bool { return r.Version == v }
+}
+
+// RegisterService registers the service in the context as a provider of the
+// types of the options (see As). Services are identified by reference, and so
+// must be of comparable types, e.g. pointers, but not funcs.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx or service is nil, no types are given, the
+//	  service is not assignable to a type, or is not comparable
+//	AlreadyBoundError <= the service is already registered in ctx
+func RegisterService(ctx Context, service interface{}, opts ...ServiceOption) error {
+	if ctx == nil {
+		return IllegalArgumentError("context is nil")
+	}
+	if service == nil {
+		return IllegalArgumentError("service is nil")
+	}
+	if !reflect.TypeOf(service).Comparable() {
+		return IllegalArgumentError(fmt.Sprintf("service is %T; expected a comparable (e.g. pointer) type", service))
+	}
+	r := &Registration{Service: service, Context: ctx}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if len(r.Types) == 0 {
+		return IllegalArgumentError(fmt.Sprintf("service %T is registered as no type", service))
+	}
+	for _, t := range r.Types {
+		if !reflect.TypeOf(service).AssignableTo(t) {
+			return IllegalArgumentError(fmt.Sprintf("service %T is not a %s", service, t))
+		}
+	}
+
+	reg, e := localRegistry(ctx, true)
+	if e != nil {
+		return e
+	}
+	reg.Lock()
+	defer reg.Unlock()
+	for _, r0 := range reg.registrations {
+		if r0.Service == service {
+			return AlreadyBoundError(fmt.Sprintf("service %T", service))
+		}
+	}
+	reg.registrations = append(reg.registrations, r)
+	return nil
+}
+
+// UnregisterService removes the registration of the service from the
+// context.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx is nil
+//	NoSuchBindingError <= the service is not registered in ctx
+func UnregisterService(ctx Context, service interface{}) error {
+	if ctx == nil {
+		return IllegalArgumentError("context is nil")
+	}
+	reg, e := localRegistry(ctx, false)
+	if e != nil {
+		return e
+	}
+	// services that are not comparable, for which == panics, are never
+	// registered
+	if reg != nil && service != nil && reflect.TypeOf(service).Comparable() {
+		reg.Lock()
+		defer reg.Unlock()
+		for i, r := range reg.registrations {
+			if r.Service == service {
+				reg.registrations = append(reg.registrations[:i:i], reg.registrations[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return NoSuchBindingError(fmt.Sprintf("service %T", service))
+}
+
+// Find returns the service of type T that is registered in the nearest
+// context of the hierarchy, and is selected by the filters. Of the services
+// of that context, the service of highest priority, and then the first
+// registered, is returned. Services registered in a child context thus
+// override those of its ancestors.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx is nil
+//	NoSuchBindingError <= no such service
+func Find[T any](ctx Context, filters ...ServiceFilter) (T, error) {
+	var zero T
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	rs, e := lookupServices(ctx, t, filters, true)
+	if e != nil {
+		return zero, e
+	}
+	if len(rs) == 0 {
+		return zero, NoSuchBindingError(fmt.Sprintf("service %s", t))
+	}
+	return rs[0].Service.(T), nil
+}
+
+// FindAll returns the services of type T that are registered in the context
+// hierarchy and selected by the filters, in the order of Find: nearest
+// context first, and then by priority.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx is nil
+func FindAll[T any](ctx Context, filters ...ServiceFilter) ([]T, error) {
+	rs, e := lookupServices(ctx, reflect.TypeOf((*T)(nil)).Elem(), filters, false)
+	if e != nil {
+		return nil, e
+	}
+	services := make([]T, len(rs))
+	for i, r := range rs {
+		services[i] = r.Service.(T)
+	}
+	return services, nil
+}
+
+// Services returns the registrations of the services of type t in the
+// context hierarchy that are selected by the filters, in the order of
+// FindAll.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx or t is nil
+func Services(ctx Context, t reflect.Type, filters ...ServiceFilter) ([]Registration, error) {
+	if t == nil {
+		return nil, IllegalArgumentError("type is nil")
+	}
+	rs, e := lookupServices(ctx, t, filters, false)
+	if e != nil {
+		return nil, e
+	}
+	registrations := make([]Registration, len(rs))
+	for i, r := range rs {
+		registrations[i] = *r
+	}
+	return registrations, nil
+}
+
+// ----------------------------------------------------------------------------
+// registries
+// ----------------------------------------------------------------------------
+
+// the services registered in a context. The registry of a context is bound
+// under a name qualified by the depth of the context, so that the registries
+// of all of the ancestors of a context are visible from it.
+type registry struct {
+	sync.Mutex
+	registrations []*Registration // in order of registration
+}
+
+// the prefix of the names of the registry bindings
+const registryPrefix = "contextual.services@"
+
+func registryBinding(depth int) string {
+	return registryPrefix + strconv.Itoa(depth)
+}
+
+// returns the registry of the context, which is created if create is set
+// and otherwise may be nil.
+func localRegistry(ctx Context, create bool) (*registry, error) {
+	name := registryBinding(ctx.Depth())
+	for {
+		v, e := ctx.LookupN(name, 0)
+		if e != nil {
+			return nil, e
+		}
+		if reg, ok := v.(*registry); ok || !create {
+			return reg, nil
+		}
+		reg := &registry{}
+		switch e := ctx.Bind(name, reg); {
+		case e == nil:
+			return reg, nil
+		case !IsError(e, AlreadyBoundError): // else, lost a race to bind it
+			return nil, e
+		}
+	}
+}
+
+// returns the registrations of type t, nearest context first; only those
+// of the nearest context with any if nearest is set.
+func lookupServices(ctx Context, t reflect.Type, filters []ServiceFilter, nearest bool) ([]*Registration, error) {
+	if ctx == nil {
+		return nil, IllegalArgumentError("context is nil")
+	}
+	var found []*Registration
+	depth := ctx.Depth()
+	for d := depth; d >= 0; d-- {
+		v, e := ctx.LookupN(registryBinding(d), depth-d)
+		if e != nil {
+			return nil, e
+		}
+		reg, ok := v.(*registry)
+		if !ok {
+			continue
+		}
+		rs := reg.selected(t, filters)
+		found = append(found, rs...)
+		if nearest && len(found) > 0 {
+			break
+		}
+	}
+	return found, nil
+}
+
+// returns the selected registrations of type t, by priority.
+func (reg *registry) selected(t reflect.Type, filters []ServiceFilter) []*Registration {
+	reg.Lock()
+	defer reg.Unlock()
+	var rs []*Registration
+next:
+	for _, r := range reg.registrations {
+		for _, filter := range filters {
+			if !filter(r) {
+				continue next
+			}
+		}
+		for _, t0 := range r.Types {
+			if t0 == t {
+				rs = append(rs, r)
+				break
+			}
+		}
+	}
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].Priority > rs[j].Priority })
+	return rs
+}
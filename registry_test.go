@@ -0,0 +1,99 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// ============================================================================
+// testing: service registry
+// ============================================================================
+
+type storage interface {
+	Get(key string) string
+}
+
+type kvStore struct{ name string }
+
+func (s *kvStore) Get(key string) string { return s.name + ":" + key }
+func (s *kvStore) String() string        { return s.name }
+
+func TestRegisterService(t *testing.T) {
+	ctx := NewContext()
+	s := &kvStore{"a"}
+
+	assertError(t, "RegisterService(nil context)", RegisterService(nil, s, As[storage]()), IllegalArgumentError)
+	assertError(t, "RegisterService(nil service)", RegisterService(ctx, nil, As[storage]()), IllegalArgumentError)
+	assertError(t, "RegisterService(no types)", RegisterService(ctx, s), IllegalArgumentError)
+	assertError(t, "RegisterService(not assignable)", RegisterService(ctx, "s", As[storage]()), IllegalArgumentError)
+	handler := func() {}
+	assertError(t, "RegisterService(not comparable)", RegisterService(ctx, handler, As[func()]()), IllegalArgumentError)
+	assertError(t, "UnregisterService(not comparable)", UnregisterService(ctx, handler), NoSuchBindingError)
+
+	if e := RegisterService(ctx, s, As[storage](), As[fmt.Stringer](), Version("1.0"), Tags("primary")); e != nil {
+		t.Fatalf("RegisterService - unexpected error: %s", e)
+	}
+	assertError(t, "RegisterService(registered)", RegisterService(ctx, s, As[storage]()), AlreadyBoundError)
+
+	found, e := Find[fmt.Stringer](ctx)
+	if e != nil || found != s {
+		t.Fatalf("Find - expected:%v got:%v, %v", s, found, e)
+	}
+	rs, _ := Services(ctx, reflect.TypeOf((*storage)(nil)).Elem())
+	if len(rs) != 1 || rs[0].Version != "1.0" || !rs[0].HasTag("primary") || rs[0].Context != ctx {
+		t.Fatalf("Services - unexpected: %+v", rs)
+	}
+
+	if e := UnregisterService(ctx, s); e != nil {
+		t.Fatalf("UnregisterService - unexpected error: %s", e)
+	}
+	assertError(t, "UnregisterService(unregistered)", UnregisterService(ctx, s), NoSuchBindingError)
+	_, e = Find[storage](ctx)
+	assertError(t, "Find(unregistered)", e, NoSuchBindingError)
+}
+
+func TestFindService(t *testing.T) {
+	root := NewContext()
+	child, _ := ChildContext(root)
+	grandchild, _ := ChildContext(child)
+
+	primary, replica, cache, local := &kvStore{"primary"}, &kvStore{"replica"}, &kvStore{"cache"}, &kvStore{"local"}
+	RegisterService(root, replica, As[storage](), Tags("replica"), Version("1"))
+	RegisterService(root, primary, As[storage](), Tags("primary"), Priority(10), Version("2"))
+	RegisterService(child, cache, As[storage](), Tags("cache"))
+
+	expect := func(ctx Context, expected storage, filters ...ServiceFilter) {
+		t.Helper()
+		found, e := Find[storage](ctx, filters...)
+		if e != nil || found != expected {
+			t.Fatalf("Find - expected:%v got:%v, %v", expected, found, e)
+		}
+	}
+	expect(root, primary)
+	expect(root, replica, Tagged("replica"))
+	expect(root, replica, WithVersion("1"))
+	expect(grandchild, cache)
+	expect(grandchild, primary, Tagged("primary"))
+
+	// child overrides parent
+	RegisterService(grandchild, local, As[storage](), Priority(-1))
+	expect(grandchild, local)
+	expect(child, cache)
+
+	all, _ := FindAll[storage](grandchild)
+	if !reflect.DeepEqual(all, []storage{local, cache, primary, replica}) {
+		t.Fatalf("FindAll - unexpected: %v", all)
+	}
+	if _, e := Find[storage](grandchild, Tagged("none")); e == nil {
+		t.Fatalf("Find - expected error: %s", NoSuchBindingError())
+	}
+
+	// siblings do not see each other's services
+	sibling, _ := ChildContext(child)
+	expect(sibling, cache)
+}
@@ -0,0 +1,258 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package remote
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// bounds of the backoff between reconnect attempts of the watcher.
+const (
+	minWatchBackoff = 10 * time.Millisecond
+	maxWatchBackoff = 2 * time.Second
+)
+
+// lookup cache key. n is -1 for Lookup.
+type cacheKey struct {
+	name string
+	n    int
+}
+
+// cache of lookup results of a client. Entries are invalidated per change
+// events pushed by the server to the watcher connection of the client.
+//
+// While the watcher is disconnected, entries are used for at most maxStale
+// after the disconnect, after which the cache is flushed and bypassed until
+// the watcher reconnects. The cache is flushed on reconnect, as events may
+// have been missed.
+type cache struct {
+	client   *client
+	maxStale time.Duration
+
+	sync.Mutex
+	entries        map[cacheKey]interface{}
+	size           int
+	sizeOK         bool
+	depth          int
+	depthOK        bool
+	gen            uint64 // incremented on every invalidation
+	connected      bool
+	disconnectedAt time.Time
+	conn           net.Conn // watcher
+	closed         bool
+}
+
+func newCache(c *client, maxStale time.Duration) *cache {
+	return &cache{
+		client:   c,
+		maxStale: maxStale,
+		entries:  make(map[cacheKey]interface{}),
+	}
+}
+
+// start connects the watcher and starts the watch loop.
+func (k *cache) start() error {
+	conn, r, e := k.dialWatch()
+	if e != nil {
+		return e
+	}
+	k.Lock()
+	k.conn, k.connected = conn, true
+	k.Unlock()
+	go k.watch(r)
+	return nil
+}
+
+// dials a connection and turns it into an event stream.
+func (k *cache) dialWatch() (net.Conn, *bufio.Reader, error) {
+	c := k.client
+	conn, e := net.DialTimeout(c.network, c.address, c.opts.Timeout)
+	if e != nil {
+		return nil, nil, TransportError("dial").WithCause(e)
+	}
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	conn.SetDeadline(time.Now().Add(c.opts.Timeout))
+	if e := writeFrame(w, encoder{byte(opWatch)}); e != nil {
+		conn.Close()
+		return nil, nil, TransportError("write").WithCause(e)
+	}
+	resp, e := readFrame(r)
+	if e != nil {
+		conn.Close()
+		return nil, nil, TransportError("read").WithCause(e)
+	}
+	if len(resp) == 0 || resp[0] != statusOK {
+		conn.Close()
+		return nil, nil, ProtocolError("watch refused")
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, r, nil
+}
+
+// reads events until the watcher fails, then reconnects, until closed.
+func (k *cache) watch(r *bufio.Reader) {
+	for {
+		for {
+			event, e := readFrame(r)
+			if e != nil {
+				break
+			}
+			d := &decoder{b: event}
+			d.byte()
+			name := d.string()
+			if d.err != nil {
+				break
+			}
+			k.invalidate(name)
+		}
+
+		k.Lock()
+		if k.closed {
+			k.Unlock()
+			return
+		}
+		k.conn.Close()
+		k.connected = false
+		k.disconnectedAt = time.Now()
+		k.Unlock()
+
+		for backoff := minWatchBackoff; ; {
+			time.Sleep(backoff)
+			conn, r0, e := k.dialWatch()
+			k.Lock()
+			if k.closed {
+				k.Unlock()
+				if e == nil {
+					conn.Close()
+				}
+				return
+			}
+			if e == nil {
+				k.conn, k.connected = conn, true
+				k.flush()
+				k.Unlock()
+				r = r0
+				break
+			}
+			k.Unlock()
+			if backoff *= 2; backoff > maxWatchBackoff {
+				backoff = maxWatchBackoff
+			}
+		}
+	}
+}
+
+func (k *cache) close() {
+	k.Lock()
+	defer k.Unlock()
+	k.closed = true
+	if k.conn != nil {
+		k.conn.Close()
+	}
+	k.flush()
+}
+
+// REVU: k must be locked.
+func (k *cache) flush() {
+	k.entries = make(map[cacheKey]interface{})
+	k.sizeOK = false
+	k.gen++
+}
+
+// REVU: k must be locked.
+func (k *cache) usable() bool {
+	if k.closed {
+		return false
+	}
+	if k.connected || time.Since(k.disconnectedAt) <= k.maxStale {
+		return true
+	}
+	if len(k.entries) > 0 || k.sizeOK {
+		k.flush()
+	}
+	return false
+}
+
+func (k *cache) invalidate(name string) {
+	k.Lock()
+	defer k.Unlock()
+	for key := range k.entries {
+		if key.name == name {
+			delete(k.entries, key)
+		}
+	}
+	k.sizeOK = false
+	k.gen++
+}
+
+// returns the cached value, and the generation for a subsequent put.
+func (k *cache) get(key cacheKey) (v interface{}, ok bool, gen uint64) {
+	k.Lock()
+	defer k.Unlock()
+	if !k.usable() {
+		return nil, false, k.gen
+	}
+	v, ok = k.entries[key]
+	return v, ok, k.gen
+}
+
+// put caches v if there were no invalidations since gen.
+func (k *cache) put(gen uint64, key cacheKey, v interface{}) {
+	k.Lock()
+	defer k.Unlock()
+	if gen == k.gen && k.connected {
+		k.entries[key] = v
+	}
+}
+
+func (k *cache) getSize() (n int, ok bool, gen uint64) {
+	k.Lock()
+	defer k.Unlock()
+	if !k.usable() {
+		return 0, false, k.gen
+	}
+	return k.size, k.sizeOK, k.gen
+}
+
+func (k *cache) putSize(gen uint64, n int) {
+	k.Lock()
+	defer k.Unlock()
+	if gen == k.gen && k.connected {
+		k.size, k.sizeOK = n, true
+	}
+}
+
+// depth of a context never changes.
+func (k *cache) getDepth() (int, bool) {
+	k.Lock()
+	defer k.Unlock()
+	return k.depth, k.depthOK
+}
+
+func (k *cache) putDepth(n int) {
+	k.Lock()
+	defer k.Unlock()
+	k.depth, k.depthOK = n, true
+}
+
+// ----------------------------------------------------------------------------
+// cached ops of client
+// ----------------------------------------------------------------------------
+
+func (c *client) cachedLookup(key cacheKey, req encoder) (interface{}, error) {
+	v, ok, gen := c.cache.get(key)
+	if ok {
+		return v, nil
+	}
+	v, e := c.callValue(req)
+	if e != nil {
+		return nil, e
+	}
+	c.cache.put(gen, key, v)
+	return v, nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package remote
+
+import (
+	"bufio"
+	"goerror"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alphazero/contextual"
+)
+
+func dialCached(t *testing.T, network, address, export string, maxStale time.Duration) Client {
+	c, e := Dial(network, address, export, &Options{Cache: true, MaxStale: maxStale})
+	if e != nil {
+		t.Fatalf("Dial - unexpected error: %s", e)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// polls the lookup until it returns the expected value.
+func eventually(t *testing.T, c Client, name string, expected interface{}) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		v, e := c.Lookup(name)
+		if e == nil && v == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Lookup(%q) - expected:%v got:%v (error: %v)", name, expected, v, e)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	s, addr := serve(t, "tcp", "127.0.0.1:0")
+	root := contextual.NewContext()
+	child, _ := contextual.ChildContext(root)
+	s.Export("root", root)
+	s.Export("child", child)
+
+	cached := dialCached(t, addr.Network(), addr.String(), "child", 0)
+	other := dial(t, addr, "root")
+
+	root.Bind("name", "v1")
+	assertCached := func(expected interface{}) {
+		if v, e := cached.Lookup("name"); e != nil || v != expected {
+			t.Fatalf("Lookup - expected:%v got:%v (error: %v)", expected, v, e)
+		}
+	}
+	assertCached("v1")
+
+	// in-process changes are not observed until notified
+	root.Rebind("name", "v2")
+	assertCached("v1")
+	s.Notify("name")
+	eventually(t, cached, "name", "v2")
+
+	// changes via the server are pushed; including those of other exports
+	if _, e := other.Rebind("name", "v3"); e != nil {
+		t.Fatalf("Rebind - unexpected error: %s", e)
+	}
+	eventually(t, cached, "name", "v3")
+	if _, e := other.Unbind("name"); e != nil {
+		t.Fatalf("Unbind - unexpected error: %s", e)
+	}
+	eventually(t, cached, "name", nil)
+
+	// changes by the caching client are visible immediately
+	if e := cached.Bind("name", "v4"); e != nil {
+		t.Fatalf("Bind - unexpected error: %s", e)
+	}
+	assertCached("v4")
+	if n := cached.Size(); n != 1 {
+		t.Fatalf("Size - expected:1 got:%d", n)
+	}
+	if _, e := cached.Unbind("name"); e != nil {
+		t.Fatalf("Unbind - unexpected error: %s", e)
+	}
+	assertCached(nil)
+	if n := cached.Size(); n != 0 {
+		t.Fatalf("Size - expected:0 got:%d", n)
+	}
+}
+
+func TestCacheBoundedStaleness(t *testing.T) {
+	const maxStale = 200 * time.Millisecond
+
+	s, addr := serve(t, "tcp", "127.0.0.1:0")
+	root := contextual.NewContext()
+	root.Bind("name", "value")
+	s.Export("root", root)
+
+	cached := dialCached(t, addr.Network(), addr.String(), "root", maxStale)
+	eventually(t, cached, "name", "value")
+	s.Close()
+
+	// served from cache within the staleness bound
+	if v, e := cached.Lookup("name"); e != nil || v != "value" {
+		t.Fatalf("Lookup - expected:%v got:%v (error: %v)", "value", v, e)
+	}
+	time.Sleep(maxStale + 100*time.Millisecond)
+	if _, e := cached.Lookup("name"); e == nil || !goerror.TypeOf(e).Is(TransportError) {
+		t.Fatalf("Lookup - expected error: %s got: %v", TransportError(), e)
+	}
+}
+
+func TestCacheDisconnectedNoStale(t *testing.T) {
+	s, addr := serve(t, "tcp", "127.0.0.1:0")
+	root := contextual.NewContext()
+	root.Bind("name", "value")
+	s.Export("root", root)
+
+	cached := dialCached(t, addr.Network(), addr.String(), "root", 0)
+	eventually(t, cached, "name", "value")
+	s.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, e := cached.Lookup("name")
+		if e != nil && goerror.TypeOf(e).Is(TransportError) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Lookup - expected error: %s got: %v", TransportError(), e)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatchRegisteredOnAck(t *testing.T) {
+	s, addr := serve(t, "tcp", "127.0.0.1:0")
+	conn, e := net.Dial(addr.Network(), addr.String())
+	if e != nil {
+		t.Fatalf("Dial - unexpected error: %s", e)
+	}
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	if e := writeFrame(w, encoder{byte(opWatch)}); e != nil {
+		t.Fatalf("watch - unexpected error: %s", e)
+	}
+	if resp, e := readFrame(r); e != nil || len(resp) == 0 || resp[0] != statusOK {
+		t.Fatalf("watch - unexpected ack: %v, %v", resp, e)
+	}
+
+	// an event right after the ack is delivered
+	s.Notify("name")
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if event, e := readFrame(r); e != nil || op(event[0]) != opNotify {
+		t.Fatalf("watch - expected event got: %v, %v", event, e)
+	}
+}
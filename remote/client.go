@@ -0,0 +1,363 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+// Package remote provides network-served contexts: a Server that exposes
+// contexts of a Context tree over TCP or Unix sockets, and a Client that
+// is a contextual.Context proxy of an exported context.
+//
+// Values are transported per their registered codec (see package codec).
+//
+// Errors of context ops are reported to clients with their goerror
+// categories intact, e.g. a NoSuchBindingError of the server's context is
+// a NoSuchBindingError per goerror.TypeOf(e).Is() in the client.
+package remote
+
+import (
+	"bufio"
+	"errors"
+	"goerror"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/alphazero/contextual"
+)
+
+var (
+	TransportError = goerror.Define("remote transport error")
+	ProtocolError  = goerror.Define("remote protocol error")
+)
+
+const DefaultTimeout = 5 * time.Second
+
+// Options for Dial. The zero-value is valid.
+type Options struct {
+	// Timeout of dial and of each request. Default is DefaultTimeout.
+	Timeout time.Duration
+
+	// Cache lookup results in the client. Cached results are invalidated
+	// per changes reported by the server to a second (watcher) connection.
+	Cache bool
+
+	// Bound of staleness of cached results when the watcher connection is
+	// down. Zero means cached results are not used while disconnected.
+	MaxStale time.Duration
+}
+
+// Client is a contextual.Context proxy of a context exported by a Server.
+//
+// Methods of Context that do not return errors (Size, Depth, IsRoot, and
+// IsEmpty) return zero-values on transport errors, which are then reported
+// by Err.
+//
+// A Client is safe for concurrent use. A broken connection is redialed on
+// the next op.
+//
+// A caching client (per Options.Cache) only observes changes made via the
+// server, or reported by Server#Notify.
+type Client interface {
+	contextual.Context
+
+	// Err returns the transport error, if any, of the last op.
+	Err() error
+
+	// Close closes the connection. All subsequent ops return IllegalStateError.
+	Close() error
+}
+
+type client struct {
+	network, address, export string
+	opts                     Options
+	cache                    *cache // nil if not caching
+
+	sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	err    error
+	closed bool
+}
+
+// Dial connects to the server at the network address and attaches to the
+// exported context of the given name. opts may be nil.
+//
+// Errors:
+//
+//	NilNameError <= zero-value export name
+//	TransportError <= connection failed
+//	NoSuchBindingError <= no context is exported under the name
+func Dial(network, address, export string, opts *Options) (Client, error) {
+	if export == "" {
+		return nil, contextual.NilNameError()
+	}
+	c := &client{network: network, address: address, export: export}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.Timeout <= 0 {
+		c.opts.Timeout = DefaultTimeout
+	}
+
+	c.Lock()
+	e := c.connect()
+	c.Unlock()
+	if e != nil {
+		return nil, e
+	}
+	if c.opts.Cache {
+		c.cache = newCache(c, c.opts.MaxStale)
+		if e := c.cache.start(); e != nil {
+			c.Close()
+			return nil, e
+		}
+	}
+	return c, nil
+}
+
+// dials and attaches.
+// REVU: c must be locked.
+func (c *client) connect() error {
+	conn, e := net.DialTimeout(c.network, c.address, c.opts.Timeout)
+	if e != nil {
+		return TransportError("dial").WithCause(e)
+	}
+	c.conn, c.r, c.w = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+	if _, e := c.roundtrip(encoder{byte(opAttach)}.string(c.export)); e != nil {
+		c.disconnect()
+		return e
+	}
+	return nil
+}
+
+// REVU: c must be locked.
+func (c *client) disconnect() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.r, c.w = nil, nil, nil
+	}
+}
+
+// sends the request and returns the decoder of the OK response. An error
+// response is returned as an error with the category of the remote error.
+// REVU: c must be locked.
+func (c *client) roundtrip(req encoder) (*decoder, error) {
+	c.conn.SetDeadline(time.Now().Add(c.opts.Timeout))
+	if e := writeFrame(c.w, req); e != nil {
+		c.disconnect()
+		return nil, TransportError("write").WithCause(e)
+	}
+	resp, e := readFrame(c.r)
+	if e != nil {
+		c.disconnect()
+		return nil, TransportError("read").WithCause(e)
+	}
+	d := &decoder{b: resp}
+	switch d.byte() {
+	case statusOK:
+		return d, nil
+	case statusError:
+		msg := d.string()
+		if d.err != nil {
+			return nil, d.err
+		}
+		// goerror categories match on the error message
+		return nil, errors.New(msg)
+	default:
+		c.disconnect()
+		return nil, ProtocolError("unknown response status")
+	}
+}
+
+// call performs the request, (re)connecting if necessary.
+func (c *client) call(req encoder) (*decoder, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, contextual.IllegalStateError("client is closed")
+	}
+	c.err = nil
+	if c.conn == nil {
+		if e := c.connect(); e != nil {
+			c.err = e
+			return nil, e
+		}
+	}
+	d, e := c.roundtrip(req)
+	if e != nil && c.conn == nil {
+		c.err = e
+	}
+	return d, e
+}
+
+func (c *client) callValue(req encoder) (interface{}, error) {
+	d, e := c.call(req)
+	if e != nil {
+		return nil, e
+	}
+	v := d.value()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return v, nil
+}
+
+func (c *client) callInt(req encoder) int {
+	d, e := c.call(req)
+	if e != nil {
+		return 0
+	}
+	n := d.int()
+	if d.err != nil {
+		c.Lock()
+		c.err = d.err
+		c.Unlock()
+		return 0
+	}
+	return n
+}
+
+func (c *client) Err() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.err
+}
+
+func (c *client) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return contextual.IllegalStateError("client is closed")
+	}
+	c.closed = true
+	c.disconnect()
+	if c.cache != nil {
+		c.cache.close()
+	}
+	return nil
+}
+
+// invalidates the cached results of name after a change by the client.
+func (c *client) invalidate(name string, e error) {
+	if c.cache != nil && e == nil {
+		c.cache.invalidate(name)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Context API
+// ----------------------------------------------------------------------------
+
+func (c *client) IsRoot() bool {
+	return c.Depth() == 0
+}
+
+func (c *client) IsEmpty() bool {
+	return c.Size() == 0
+}
+
+func (c *client) Size() int {
+	if c.cache == nil {
+		return c.callInt(encoder{byte(opSize)})
+	}
+	n, ok, gen := c.cache.getSize()
+	if ok {
+		return n
+	}
+	n = c.callInt(encoder{byte(opSize)})
+	if c.Err() == nil {
+		c.cache.putSize(gen, n)
+	}
+	return n
+}
+
+func (c *client) Depth() int {
+	if c.cache == nil {
+		return c.callInt(encoder{byte(opDepth)})
+	}
+	n, ok := c.cache.getDepth()
+	if ok {
+		return n
+	}
+	n = c.callInt(encoder{byte(opDepth)})
+	if c.Err() == nil {
+		c.cache.putDepth(n)
+	}
+	return n
+}
+
+func (c *client) Lookup(name string) (interface{}, error) {
+	if name == "" {
+		return nil, contextual.NilNameError()
+	}
+	req := encoder{byte(opLookup)}.string(name)
+	if c.cache != nil {
+		return c.cachedLookup(cacheKey{name, -1}, req)
+	}
+	return c.callValue(req)
+}
+
+func (c *client) LookupN(name string, n int) (interface{}, error) {
+	if name == "" {
+		return nil, contextual.NilNameError()
+	}
+	if n < 0 {
+		return nil, contextual.NegativeNArgError()
+	}
+	req := encoder{byte(opLookupN)}.string(name).int(n)
+	if c.cache != nil {
+		return c.cachedLookup(cacheKey{name, n}, req)
+	}
+	return c.callValue(req)
+}
+
+// Bind per contextual.Context#Bind.
+//
+// Additional errors:
+//
+//	IllegalArgumentError <= value can not be encoded
+func (c *client) Bind(name string, value interface{}) error {
+	if name == "" {
+		return contextual.NilNameError()
+	}
+	if value == nil {
+		return contextual.NilValueError()
+	}
+	req, e := encoder{byte(opBind)}.string(name).value(value)
+	if e != nil {
+		return contextual.IllegalArgumentError("value").WithCause(e)
+	}
+	_, e = c.call(req)
+	c.invalidate(name, e)
+	return e
+}
+
+func (c *client) Unbind(name string) (interface{}, error) {
+	if name == "" {
+		return nil, contextual.NilNameError()
+	}
+	v, e := c.callValue(encoder{byte(opUnbind)}.string(name))
+	c.invalidate(name, e)
+	return v, e
+}
+
+// Rebind per contextual.Context#Rebind.
+//
+// Additional errors:
+//
+//	IllegalArgumentError <= value can not be encoded
+func (c *client) Rebind(name string, value interface{}) (interface{}, error) {
+	if name == "" {
+		return nil, contextual.NilNameError()
+	}
+	if value == nil {
+		return nil, contextual.NilValueError()
+	}
+	req, e := encoder{byte(opRebind)}.string(name).value(value)
+	if e != nil {
+		return nil, contextual.IllegalArgumentError("value").WithCause(e)
+	}
+	v, e := c.callValue(req)
+	c.invalidate(name, e)
+	return v, e
+}
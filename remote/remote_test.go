@@ -0,0 +1,195 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package remote
+
+import (
+	"fmt"
+	"goerror"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/alphazero/contextual"
+	"github.com/alphazero/contextual/contextualtest"
+)
+
+// starts a server on a loopback listener.
+func serve(t *testing.T, network, address string) (Server, net.Addr) {
+	l, e := net.Listen(network, address)
+	if e != nil {
+		t.Fatalf("Listen - unexpected error: %s", e)
+	}
+	s := NewServer()
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr()
+}
+
+func dial(t *testing.T, addr net.Addr, export string) Client {
+	c, e := Dial(addr.Network(), addr.String(), export, nil)
+	if e != nil {
+		t.Fatalf("Dial - unexpected error: %s", e)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// every context of the hierarchy is server-side; the test only sees clients.
+func TestConformance(t *testing.T) {
+	contextualtest.RunConformance(t, func(t *testing.T, p contextual.Context) contextual.Context {
+		s, addr := serve(t, "tcp", "127.0.0.1:0")
+		var ctx contextual.Context
+		if p == nil {
+			ctx = contextual.NewContext()
+		} else {
+			ctx, _ = contextual.ChildContext(served(p))
+		}
+		s.Export("ctx", ctx)
+		c := dial(t, addr, "ctx")
+		registerServed(c, ctx)
+		return c
+	})
+}
+
+// in-memory children of a remote root.
+func TestConformanceLocalChildren(t *testing.T) {
+	contextualtest.RunConformance(t, func(t *testing.T, p contextual.Context) contextual.Context {
+		if p == nil {
+			s, addr := serve(t, "tcp", "127.0.0.1:0")
+			s.Export("root", contextual.NewContext())
+			return dial(t, addr, "root")
+		}
+		c, _ := contextual.ChildContext(p)
+		return c
+	})
+}
+
+var (
+	servedLock sync.Mutex
+	servedCtx  = make(map[contextual.Context]contextual.Context)
+)
+
+func registerServed(c Client, ctx contextual.Context) {
+	servedLock.Lock()
+	defer servedLock.Unlock()
+	servedCtx[c] = ctx
+}
+
+// returns the server-side context of the client
+func served(c contextual.Context) contextual.Context {
+	servedLock.Lock()
+	defer servedLock.Unlock()
+	return servedCtx[c]
+}
+
+func TestUnixSocket(t *testing.T) {
+	s, addr := serve(t, "unix", filepath.Join(t.TempDir(), "ctx.sock"))
+	root := contextual.NewContext()
+	child, _ := contextual.ChildContext(root)
+	s.Export("root", root)
+	s.Export("child", child)
+
+	croot, cchild := dial(t, addr, "root"), dial(t, addr, "child")
+	if e := croot.Bind("db.primary", "10.0.0.1:5432"); e != nil {
+		t.Fatalf("Bind - unexpected error: %s", e)
+	}
+	if v, _ := root.Lookup("db.primary"); v != "10.0.0.1:5432" {
+		t.Fatalf("server-side Lookup - expected:%v got:%v", "10.0.0.1:5432", v)
+	}
+	if v, _ := cchild.Lookup("db.primary"); v != "10.0.0.1:5432" {
+		t.Fatalf("child Lookup - expected:%v got:%v", "10.0.0.1:5432", v)
+	}
+	if v, _ := cchild.LookupN("db.primary", 0); v != nil {
+		t.Fatalf("child LookupN(0) - expected:nil got:%v", v)
+	}
+	if d := cchild.Depth(); d != 1 {
+		t.Fatalf("child Depth - expected:1 got:%d", d)
+	}
+	if cchild.IsRoot() || !croot.IsRoot() {
+		t.Fatalf("IsRoot - unexpected result")
+	}
+}
+
+func TestErrorCategories(t *testing.T) {
+	s, addr := serve(t, "tcp", "127.0.0.1:0")
+	s.Export("ctx", contextual.NewContext())
+	c := dial(t, addr, "ctx")
+
+	c.Bind("name", "value")
+	e := c.Bind("name", "value")
+	if e == nil || !goerror.TypeOf(e).Is(contextual.AlreadyBoundError) {
+		t.Fatalf("Bind - expected error: %s got: %v", contextual.AlreadyBoundError(), e)
+	}
+	if _, e := c.Unbind("no-such-name"); e == nil || !goerror.TypeOf(e).Is(contextual.NoSuchBindingError) {
+		t.Fatalf("Unbind - expected error: %s got: %v", contextual.NoSuchBindingError(), e)
+	}
+	if c.Err() != nil {
+		t.Fatalf("Err - unexpected transport error: %s", c.Err())
+	}
+
+	type unregistered struct{}
+	if e := c.Bind("other", unregistered{}); e == nil || !goerror.TypeOf(e).Is(contextual.IllegalArgumentError) {
+		t.Fatalf("Bind - expected error: %s got: %v", contextual.IllegalArgumentError(), e)
+	}
+
+	if _, e := Dial(addr.Network(), addr.String(), "no-such-export", nil); e == nil || !goerror.TypeOf(e).Is(contextual.NoSuchBindingError) {
+		t.Fatalf("Dial - expected error: %s got: %v", contextual.NoSuchBindingError(), e)
+	}
+	if e := s.Export("ctx", contextual.NewContext()); e == nil || !goerror.TypeOf(e).Is(contextual.AlreadyBoundError) {
+		t.Fatalf("Export - expected error: %s got: %v", contextual.AlreadyBoundError(), e)
+	}
+
+	c.Close()
+	if _, e := c.Lookup("name"); e == nil || !goerror.TypeOf(e).Is(contextual.IllegalStateError) {
+		t.Fatalf("Lookup - expected error: %s got: %v", contextual.IllegalStateError(), e)
+	}
+}
+
+func TestTransportErrors(t *testing.T) {
+	s, addr := serve(t, "tcp", "127.0.0.1:0")
+	s.Export("ctx", contextual.NewContext())
+	c := dial(t, addr, "ctx")
+	s.Close()
+
+	if _, e := c.Lookup("name"); e == nil || !goerror.TypeOf(e).Is(TransportError) {
+		t.Fatalf("Lookup - expected error: %s got: %v", TransportError(), e)
+	}
+	if n := c.Size(); n != 0 {
+		t.Fatalf("Size - expected:0 got:%d", n)
+	}
+	if e := c.Err(); e == nil || !goerror.TypeOf(e).Is(TransportError) {
+		t.Fatalf("Err - expected error: %s got: %v", TransportError(), e)
+	}
+}
+
+func TestConcurrentClients(t *testing.T) {
+	s, addr := serve(t, "tcp", "127.0.0.1:0")
+	s.Export("ctx", contextual.NewContext())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		c := dial(t, addr, "ctx")
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				name := fmt.Sprintf("c%d.%d", i, j)
+				if e := c.Bind(name, j); e != nil {
+					t.Errorf("Bind - unexpected error: %s", e)
+					return
+				}
+				if v, e := c.Lookup(name); e != nil || v != j {
+					t.Errorf("Lookup - expected:%d got:%v (error: %v)", j, v, e)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := dial(t, addr, "ctx").Size(); n != 8*50 {
+		t.Fatalf("Size - expected:%d got:%d", 8*50, n)
+	}
+}
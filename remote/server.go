@@ -0,0 +1,355 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package remote
+
+import (
+	"bufio"
+	"net"
+	"sync"
+
+	"github.com/alphazero/contextual"
+)
+
+// Server exposes contexts of a Context tree to remote clients.
+type Server interface {
+	// Export makes ctx available to clients under the given name.
+	// Exported contexts may be any contexts of a hierarchy, e.g. the root
+	// and some of its children.
+	//
+	// Errors:
+	//
+	//  NilNameError <= zero-value name
+	//  IllegalArgumentError <= ctx is nil
+	//  AlreadyBoundError <= name is already exported
+	Export(name string, ctx contextual.Context) error
+
+	// Serve accepts connections on the listener and serves each on its own
+	// goroutine. Serve blocks until the listener fails or the server is
+	// closed. The listener is closed on return.
+	//
+	// Errors:
+	//
+	//  IllegalStateError <= server is closed
+	Serve(l net.Listener) error
+
+	// Notify reports a change of the named binding to watching clients.
+	// Changes made via the server are reported automatically; Notify is for
+	// changes made by other means, e.g. in-process.
+	Notify(name string)
+
+	// Close closes all listeners and connections.
+	Close() error
+}
+
+// events buffered per watching connection. A watcher that falls behind is
+// disconnected, and is expected to resync.
+const watchBufferSize = 1024
+
+type server struct {
+	// all ops on the exported contexts are serialized by ctxlock: in-memory
+	// contexts are safe for concurrent use, but exported contexts may be of
+	// any Context implementation, which need not be.
+	ctxlock sync.RWMutex
+
+	sync.Mutex
+	exports   map[string]contextual.Context
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	watchers  map[chan encoder]net.Conn
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer returns a new server with no exported contexts.
+func NewServer() Server {
+	return &server{
+		exports:   make(map[string]contextual.Context),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+		watchers:  make(map[chan encoder]net.Conn),
+	}
+}
+
+func (s *server) Export(name string, ctx contextual.Context) error {
+	if name == "" {
+		return contextual.NilNameError()
+	}
+	if ctx == nil {
+		return contextual.IllegalArgumentError("ctx is nil")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.exports[name]; ok {
+		return contextual.AlreadyBoundError(name)
+	}
+	s.exports[name] = ctx
+	return nil
+}
+
+func (s *server) Serve(l net.Listener) error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		l.Close()
+		return contextual.IllegalStateError("server is closed")
+	}
+	s.listeners[l] = struct{}{}
+	s.Unlock()
+
+	defer func() {
+		s.Lock()
+		delete(s.listeners, l)
+		s.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, e := l.Accept()
+		if e != nil {
+			s.Lock()
+			closed := s.closed
+			s.Unlock()
+			if closed {
+				return nil
+			}
+			return TransportError("accept").WithCause(e)
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return nil
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *server) track(conn net.Conn) bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *server) untrack(conn net.Conn) {
+	s.Lock()
+	delete(s.conns, conn)
+	s.Unlock()
+	conn.Close()
+}
+
+func (s *server) Close() error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return contextual.IllegalStateError("server is closed")
+	}
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *server) export(name string) contextual.Context {
+	s.Lock()
+	defer s.Unlock()
+	return s.exports[name]
+}
+
+// serves requests of a connection until it fails or is closed.
+func (s *server) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	var ctx contextual.Context
+	for {
+		req, e := readFrame(r)
+		if e != nil {
+			return
+		}
+		var resp encoder
+		switch {
+		case len(req) > 0 && op(req[0]) == opWatch:
+			s.watch(conn, r, w)
+			return
+		case len(req) > 0 && op(req[0]) == opAttach:
+			d := &decoder{b: req[1:]}
+			name := d.string()
+			if ctx = s.export(name); ctx == nil {
+				resp = errorResponse(contextual.NoSuchBindingError("no such export:", name))
+			} else {
+				resp = encoder{statusOK}
+			}
+		case ctx == nil:
+			resp = errorResponse(ProtocolError("connection is not attached"))
+		default:
+			resp = s.exec(ctx, &decoder{b: req})
+		}
+		if e := writeFrame(w, resp); e != nil {
+			return
+		}
+	}
+}
+
+// acknowledges the watch, and streams events to the watching connection
+// until it fails or is closed. The watcher is registered before the ack, so
+// that no event after the ack is missed.
+func (s *server) watch(conn net.Conn, r *bufio.Reader, w *bufio.Writer) {
+	events := make(chan encoder, watchBufferSize)
+	s.Lock()
+	s.watchers[events] = conn
+	s.Unlock()
+	defer func() {
+		s.Lock()
+		delete(s.watchers, events)
+		s.Unlock()
+	}()
+	if e := writeFrame(w, encoder{statusOK}); e != nil {
+		return
+	}
+
+	// watchers do not send requests; reads only detect the closed connection.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, e := r.ReadByte(); e != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case event := <-events:
+			if e := writeFrame(w, event); e != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (s *server) Notify(name string) {
+	s.notify(opNotify, name)
+}
+
+// broadcasts the event to all watchers.
+func (s *server) notify(o op, name string) {
+	event := encoder{byte(o)}.string(name)
+
+	s.Lock()
+	defer s.Unlock()
+	for events, conn := range s.watchers {
+		select {
+		case events <- event:
+		default:
+			// fell behind; the client resyncs on disconnect
+			conn.Close()
+		}
+	}
+}
+
+func errorResponse(e error) encoder {
+	return encoder{statusError}.string(e.Error())
+}
+
+// executes the request on ctx and returns the response.
+func (s *server) exec(ctx contextual.Context, d *decoder) encoder {
+	var v interface{}
+	var n int
+	var e error
+	var name string
+
+	o := op(d.byte())
+	switch o {
+	case opLookup:
+		name = d.string()
+		if d.err != nil {
+			break
+		}
+		s.ctxlock.RLock()
+		v, e = ctx.Lookup(name)
+		s.ctxlock.RUnlock()
+	case opLookupN:
+		var steps int
+		name, steps = d.string(), d.int()
+		if d.err != nil {
+			break
+		}
+		s.ctxlock.RLock()
+		v, e = ctx.LookupN(name, steps)
+		s.ctxlock.RUnlock()
+	case opBind:
+		var value interface{}
+		name, value = d.string(), d.value()
+		if d.err != nil {
+			break
+		}
+		s.ctxlock.Lock()
+		e = ctx.Bind(name, value)
+		s.ctxlock.Unlock()
+	case opUnbind:
+		name = d.string()
+		if d.err != nil {
+			break
+		}
+		s.ctxlock.Lock()
+		v, e = ctx.Unbind(name)
+		s.ctxlock.Unlock()
+	case opRebind:
+		var value interface{}
+		name, value = d.string(), d.value()
+		if d.err != nil {
+			break
+		}
+		s.ctxlock.Lock()
+		v, e = ctx.Rebind(name, value)
+		s.ctxlock.Unlock()
+	case opSize:
+		s.ctxlock.RLock()
+		n = ctx.Size()
+		s.ctxlock.RUnlock()
+		return encoder{statusOK}.int(n)
+	case opDepth:
+		s.ctxlock.RLock()
+		n = ctx.Depth()
+		s.ctxlock.RUnlock()
+		return encoder{statusOK}.int(n)
+	default:
+		d.err = ProtocolError("unknown op")
+	}
+
+	if d.err != nil {
+		return errorResponse(d.err)
+	}
+	if e != nil {
+		return errorResponse(e)
+	}
+	switch o {
+	case opBind, opUnbind, opRebind:
+		s.notify(o, name)
+	}
+	resp, e := encoder{statusOK}.value(v)
+	if e != nil {
+		return errorResponse(e)
+	}
+	return resp
+}
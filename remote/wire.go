@@ -0,0 +1,190 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package remote
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/alphazero/contextual/codec"
+)
+
+// protocol:
+//
+// Each message is a frame: [payload-len uint32][payload]. Integers in the
+// frame header are big-endian; integers in payloads are (u)varints and
+// strings are uvarint length prefixed.
+//
+//	request:  [op byte][args...]
+//	response: [status byte][result... | error-message string]
+//
+// The first request of a connection must be opAttach, which selects the
+// exported context that subsequent requests of the connection operate on.
+//
+// A connection that sends opWatch is thereafter a push-only event stream
+// of changes made via the server, each event a frame:
+//
+//	event:    [op byte][name string]
+//
+// with op one of opBind, opUnbind, opRebind, or opNotify.
+//
+// Values are [tag string][value bytes] per package codec. A zero-value tag
+// denotes a nil value.
+
+type op byte
+
+const (
+	opAttach  op = iota + 1 // name               => -
+	opLookup                // name               => value
+	opLookupN               // name, n            => value
+	opBind                  // name, value        => -
+	opUnbind                // name               => value
+	opRebind                // name, value        => value
+	opSize                  // -                  => n
+	opDepth                 // -                  => n
+	opWatch                 // -                  => - (then events)
+	opNotify                // (event only)
+)
+
+const (
+	statusOK byte = iota
+	statusError
+)
+
+// upper bound on frame payload size.
+const maxFrameSize = 16 << 20
+
+func writeFrame(w *bufio.Writer, payload []byte) error {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+	if _, e := w.Write(header[:]); e != nil {
+		return e
+	}
+	if _, e := w.Write(payload); e != nil {
+		return e
+	}
+	return w.Flush()
+}
+
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, e := io.ReadFull(r, header[:]); e != nil {
+		return nil, e
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return nil, ProtocolError("frame size exceeds limit")
+	}
+	payload := make([]byte, size)
+	if _, e := io.ReadFull(r, payload); e != nil {
+		if e == io.EOF {
+			e = io.ErrUnexpectedEOF
+		}
+		return nil, e
+	}
+	return payload, nil
+}
+
+// ----------------------------------------------------------------------------
+// payload encoding
+// ----------------------------------------------------------------------------
+
+type encoder []byte
+
+func (b encoder) byte(v byte) encoder {
+	return append(b, v)
+}
+
+func (b encoder) string(s string) encoder {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func (b encoder) bytes(p []byte) encoder {
+	b = binary.AppendUvarint(b, uint64(len(p)))
+	return append(b, p...)
+}
+
+func (b encoder) int(n int) encoder {
+	return binary.AppendVarint(b, int64(n))
+}
+
+// value encodes v per package codec. nil values are encoded as a zero-value tag.
+func (b encoder) value(v interface{}) (encoder, error) {
+	if v == nil {
+		return b.string(""), nil
+	}
+	tag, p, e := codec.Encode(v)
+	if e != nil {
+		return b, e
+	}
+	return b.string(tag).bytes(p), nil
+}
+
+// decoder reads a payload. The first fault is sticky and reported by err.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = ProtocolError("malformed payload")
+	}
+	d.b = nil
+}
+
+func (d *decoder) byte() byte {
+	if len(d.b) < 1 {
+		d.fail()
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n, k := binary.Uvarint(d.b)
+	if k <= 0 || uint64(len(d.b)-k) < n {
+		d.fail()
+		return nil
+	}
+	p := d.b[k : k+int(n)]
+	d.b = d.b[k+int(n):]
+	return p
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) int() int {
+	n, k := binary.Varint(d.b)
+	if k <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[k:]
+	return int(n)
+}
+
+func (d *decoder) value() interface{} {
+	tag := d.string()
+	if d.err != nil || tag == "" {
+		return nil
+	}
+	p := d.bytes()
+	if d.err != nil {
+		return nil
+	}
+	v, e := codec.Decode(tag, p)
+	if e != nil {
+		d.err = e
+		return nil
+	}
+	return v
+}
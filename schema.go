@@ -0,0 +1,258 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"goerror"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Constraint checks the value of a binding; a violation is reported as the
+// error.
+type Constraint func(value interface{}) error
+
+// Field declares a binding of a schema.
+type Field struct {
+	Name        string
+	Type        reflect.Type // of the value; nil for any type
+	Required    bool
+	Default     interface{} // bound by ApplyDefaults; nil for none
+	Constraints []Constraint
+}
+
+// Schema declares the bindings that a context must contain.
+type Schema struct {
+	fields []Field
+	byName map[string]*Field
+}
+
+// NewSchema returns the schema of the fields.
+//
+// Errors:
+//
+//	NilNameError <= a field has no name
+//	AlreadyBoundError <= a field is declared twice
+//	SchemaError <= a default value violates its field
+func NewSchema(fields ...Field) (*Schema, error) {
+	s := &Schema{byName: make(map[string]*Field)}
+	s.fields = append(s.fields, fields...)
+	for i := range s.fields {
+		f := &s.fields[i]
+		if f.Name == "" {
+			return nil, NilNameError(fmt.Sprintf("field %d", i))
+		}
+		if _, ok := s.byName[f.Name]; ok {
+			return nil, AlreadyBoundError(fmt.Sprintf("field %s", f.Name))
+		}
+		if f.Default != nil {
+			if e := f.check(f.Default); e != nil {
+				return nil, SchemaError(f.Name + " default").WithCause(e)
+			}
+		}
+		s.byName[f.Name] = f
+	}
+	return s, nil
+}
+
+// Fields returns the fields of the schema.
+func (s *Schema) Fields() []Field {
+	return append([]Field(nil), s.fields...)
+}
+
+// Validate checks the bindings visible from the context against the schema.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx is nil
+//	SchemaError <= the context violates the schema (the cause is an
+//	  ErrorList of all violations)
+func (s *Schema) Validate(ctx Context) error {
+	if ctx == nil {
+		return IllegalArgumentError("context is nil")
+	}
+	var errors ErrorList
+	for i := range s.fields {
+		f := &s.fields[i]
+		v, e := lookup(ctx, f.Name)
+		switch {
+		case e != nil:
+			errors = append(errors, SchemaError(f.Name).WithCause(e))
+		case v == nil && f.Required:
+			errors = append(errors, SchemaError(f.Name).WithCause(NoSuchBindingError("required")))
+		case v != nil:
+			if e := f.check(v); e != nil {
+				errors = append(errors, SchemaError(f.Name).WithCause(e))
+			}
+		}
+	}
+	if len(errors) > 0 {
+		return SchemaError(fmt.Sprintf("%d violation(s)", len(errors))).WithCause(errors)
+	}
+	return nil
+}
+
+// ApplyDefaults binds, in the context, the defaults of the fields that are
+// not visible from the context.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx is nil
+//	(and those of the Lookup and Bind of ctx)
+func (s *Schema) ApplyDefaults(ctx Context) error {
+	if ctx == nil {
+		return IllegalArgumentError("context is nil")
+	}
+	for _, f := range s.fields {
+		if f.Default == nil {
+			continue
+		}
+		v, e := lookup(ctx, f.Name)
+		if e != nil {
+			return e
+		}
+		if v != nil {
+			continue
+		}
+		if e := ctx.Bind(f.Name, f.Default); e != nil {
+			return e
+		}
+		if d, ok := ctx.(Describable); ok {
+			d.SetSource(f.Name, "default")
+		}
+	}
+	return nil
+}
+
+// Enforce has the context, and its subsequently created children, check
+// the values of the fields of the schema on every Bind and Rebind, which
+// fail with a SchemaError on a violation. A nil schema enforces nothing.
+//
+// Errors:
+//
+//	IllegalArgumentError <= ctx is not an in-memory context
+func Enforce(ctx Context, s *Schema) error {
+	c, ok := ctx.(*context)
+	if !ok {
+		return IllegalArgumentError(fmt.Sprintf("context %T does not enforce schemas", ctx))
+	}
+	c.Lock()
+	c.schema = s
+	c.Unlock()
+	return nil
+}
+
+// returns the value visible from the context; masked names (see Maskable)
+// are not bound.
+func lookup(ctx Context, name string) (interface{}, error) {
+	v, e := ctx.Lookup(name)
+	if e != nil && goerror.TypeOf(e).Is(NoSuchBindingError) {
+		return nil, nil
+	}
+	return v, e
+}
+
+// checks the value of a binding of the name, if a field of the schema.
+func (s *Schema) check(name string, value interface{}) error {
+	if s == nil {
+		return nil
+	}
+	f, ok := s.byName[name]
+	if !ok {
+		return nil
+	}
+	if e := f.check(value); e != nil {
+		return SchemaError(name).WithCause(e)
+	}
+	return nil
+}
+
+// checks the type and constraints of the value; reports the first
+// violation.
+func (f *Field) check(value interface{}) error {
+	if f.Type != nil && value == nil {
+		return IllegalArgumentError(fmt.Sprintf("value is nil; expected %s", f.Type))
+	}
+	if f.Type != nil && !reflect.TypeOf(value).AssignableTo(f.Type) {
+		return IllegalArgumentError(fmt.Sprintf("value is %T; expected %s", value, f.Type))
+	}
+	for _, constraint := range f.Constraints {
+		if e := constraint(value); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// constraints
+// ----------------------------------------------------------------------------
+
+// Range constrains numeric values to the closed interval [min, max].
+func Range(min, max float64) Constraint {
+	return func(value interface{}) error {
+		n, ok := number(value)
+		if !ok {
+			return IllegalArgumentError(fmt.Sprintf("value is %T; expected a number", value))
+		}
+		if n < min || n > max {
+			return IllegalArgumentError(fmt.Sprintf("%v is not in [%v, %v]", value, min, max))
+		}
+		return nil
+	}
+}
+
+// Pattern constrains string values to those that match the regular
+// expression. The expression is compiled where the constraint is defined:
+// Pattern panics if it does not compile, as regexp.MustCompile.
+func Pattern(expr string) Constraint {
+	re := regexp.MustCompile(expr)
+	return func(value interface{}) error {
+		s, ok := value.(string)
+		if !ok {
+			return IllegalArgumentError(fmt.Sprintf("value is %T; expected a string", value))
+		}
+		if !re.MatchString(s) {
+			return IllegalArgumentError(fmt.Sprintf("%q does not match %q", s, expr))
+		}
+		return nil
+	}
+}
+
+// OneOf constrains values to the enumerated values. Values of types that
+// are not comparable (e.g. slices) are compared per reflect.DeepEqual.
+func OneOf(values ...interface{}) Constraint {
+	return func(value interface{}) error {
+		comparable := value == nil || reflect.TypeOf(value).Comparable()
+		for _, v := range values {
+			if comparable && v == value || !comparable && reflect.DeepEqual(v, value) {
+				return nil
+			}
+		}
+		s := make([]string, len(values))
+		for i, v := range values {
+			s[i] = fmt.Sprintf("%v", v)
+		}
+		sort.Strings(s)
+		return IllegalArgumentError(fmt.Sprintf("%v is not one of [%s]", value, strings.Join(s, ", ")))
+	}
+}
+
+// returns the value of numeric kinds as a float64.
+func number(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,144 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"goerror"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// testing: schemas
+// ============================================================================
+
+func newTestSchema(t *testing.T) *Schema {
+	s, e := NewSchema(
+		Field{Name: "port", Type: reflect.TypeOf(0), Required: true, Default: 8080, Constraints: []Constraint{Range(1, 65535)}},
+		Field{Name: "host", Type: reflect.TypeOf(""), Required: true, Constraints: []Constraint{Pattern(`^[a-z.]+$`)}},
+		Field{Name: "mode", Default: "dev", Constraints: []Constraint{OneOf("dev", "prod")}},
+		Field{Name: "ratio", Constraints: []Constraint{Range(0, 1)}},
+	)
+	if e != nil {
+		t.Fatalf("NewSchema - unexpected error: %s", e)
+	}
+	return s
+}
+
+func TestNewSchema(t *testing.T) {
+	_, e := NewSchema(Field{Name: ""})
+	assertError(t, "NewSchema(no name)", e, NilNameError)
+	_, e = NewSchema(Field{Name: "a"}, Field{Name: "a"})
+	assertError(t, "NewSchema(duplicate)", e, AlreadyBoundError)
+	_, e = NewSchema(Field{Name: "a", Default: 0, Constraints: []Constraint{Range(1, 2)}})
+	assertError(t, "NewSchema(bad default)", e, SchemaError)
+}
+
+func TestSchemaValidate(t *testing.T) {
+	s := newTestSchema(t)
+	root := NewContext()
+	child, _ := ChildContext(root)
+
+	root.Bind("port", 70000)
+	child.Bind("mode", "test")
+	child.Bind("ratio", "x")
+	e := s.Validate(child)
+	if e == nil || !goerror.TypeOf(e).Is(SchemaError) {
+		t.Fatalf("Validate - expected error: %s got: %v", SchemaError(), e)
+	}
+	for _, violation := range []string{
+		"port (cause: illegal argument - 70000 is not in [1, 65535])",
+		"host (cause: no such binding - required)",
+		"mode (cause: illegal argument - test is not one of [dev, prod])",
+		"ratio (cause: illegal argument - value is string; expected a number)",
+	} {
+		if !strings.Contains(e.Error(), violation) {
+			t.Fatalf("Validate - expected %q in error: %s", violation, e)
+		}
+	}
+	if !strings.HasPrefix(e.Error(), "schema error - 4 violation(s)") {
+		t.Fatalf("Validate - unexpected error: %s", e)
+	}
+
+	child.Bind("port", "80")
+	child.Bind("host", "Example.com")
+	child.Rebind("mode", "prod")
+	child.Rebind("ratio", 0.5)
+	if e = s.Validate(child); e == nil || strings.Count(e.Error(), "schema error - ") != 3 {
+		t.Fatalf("Validate - expected 2 violations, got: %v", e)
+	}
+	child.Rebind("port", 80)
+	child.Rebind("host", "example.com")
+	if e = s.Validate(child); e != nil {
+		t.Fatalf("Validate - unexpected error: %s", e)
+	}
+}
+
+func TestSchemaDefaultsAndEnforce(t *testing.T) {
+	s := newTestSchema(t)
+	root := NewContext()
+	root.Bind("mode", "prod")
+	child, _ := ChildContext(root)
+
+	if e := s.ApplyDefaults(child); e != nil {
+		t.Fatalf("ApplyDefaults - unexpected error: %s", e)
+	}
+	if v, _ := child.LookupN("port", 0); v != 8080 {
+		t.Fatalf("ApplyDefaults - expected port:8080 got:%v", v)
+	}
+	if v, _ := child.LookupN("mode", 0); v != nil {
+		t.Fatalf("ApplyDefaults - visible binding is overridden: %v", v)
+	}
+	if info, _ := child.(Describable).Describe("port"); info.Source != "default" {
+		t.Fatalf("ApplyDefaults - expected source:default got:%q", info.Source)
+	}
+
+	if e := Enforce(child, s); e != nil {
+		t.Fatalf("Enforce - unexpected error: %s", e)
+	}
+	assertError(t, "Bind(violation)", child.Bind("host", "::1"), SchemaError)
+	_, e := child.Rebind("port", 0)
+	assertError(t, "Rebind(violation)", e, SchemaError)
+	if v, _ := child.Lookup("port"); v != 8080 {
+		t.Fatalf("Rebind - faulted rebind changed the binding: %v", v)
+	}
+	assertError(t, "BindTTL(violation)", child.(Expiring).BindTTL("mode", "x", 1), SchemaError)
+	if e := child.Bind("host", "localhost"); e != nil {
+		t.Fatalf("Bind - unexpected error: %s", e)
+	}
+	if e := child.Bind("other", struct{}{}); e != nil {
+		t.Fatalf("Bind(undeclared) - unexpected error: %s", e)
+	}
+
+	// children inherit the schema of the context
+	grandchild, _ := ChildContext(child)
+	assertError(t, "Bind(child violation)", grandchild.Bind("ratio", 2), SchemaError)
+
+	Enforce(child, nil)
+	if e := child.Bind("ratio", 2); e != nil {
+		t.Fatalf("Bind(not enforced) - unexpected error: %s", e)
+	}
+	assertError(t, "Enforce(foreign)", Enforce(struct{ Context }{root}, s), IllegalArgumentError)
+}
+
+func TestConstraints(t *testing.T) {
+	// values that are not comparable are compared deeply
+	oneOf := OneOf([]string{"a"}, map[string]int{"b": 1}, "c")
+	for _, v := range []interface{}{[]string{"a"}, map[string]int{"b": 1}, "c"} {
+		if e := oneOf(v); e != nil {
+			t.Fatalf("OneOf(%v) - unexpected error: %s", v, e)
+		}
+	}
+	assertError(t, "OneOf(other slice)", oneOf([]string{"b"}), IllegalArgumentError)
+
+	// patterns are compiled where defined
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Pattern - invalid expression is accepted")
+		}
+	}()
+	Pattern(`[a-z`)
+}
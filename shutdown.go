@@ -0,0 +1,152 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	stdctx "context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Run starts the container tree, waits until ctx is done or the process
+// receives SIGINT or SIGTERM, and then shuts the tree down within the
+// deadline (see Shutdown). A container without a context is given a new
+// root context.
+//
+// Errors:
+//
+//	IllegalArgumentError <= c is nil, or not a container of this package
+//	LifecycleError, DependencyError <= the container failed to start
+//	ShutdownError <= see Shutdown
+func Run(ctx stdctx.Context, c Container, deadline time.Duration) error {
+	root := asContainer(c)
+	if root == nil {
+		return IllegalArgumentError(fmt.Sprintf("container is %T", c))
+	}
+	root.Lock()
+	if root.context == nil {
+		root.context = NewContext()
+	}
+	root.Unlock()
+
+	if err := c.Start(); err != nil {
+		Shutdown(c, deadline)
+		return err
+	}
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	<-ctx.Done()
+	return Shutdown(c, deadline)
+}
+
+// Shutdown stops the components of the container, in reverse dependency
+// order, within the deadline. Components that fail to stop in time,
+// including those whose turn to stop comes after the deadline, are
+// destroyed by force: they are marked destroyed, and their Destroy method
+// (if any) is called without waiting on it. The stopped components are then
+// destroyed, and the contexts of all components in the tree are closed.
+//
+// Errors:
+//
+//	IllegalArgumentError <= c is nil, or not a container of this package
+//	ShutdownError <= components failed to stop or be destroyed (the cause is
+//	  an ErrorList that names them)
+func Shutdown(c Container, deadline time.Duration) error {
+	root := asContainer(c)
+	if root == nil {
+		return IllegalArgumentError(fmt.Sprintf("container is %T", c))
+	}
+	if s, ok := c.(*supervisor); ok {
+		s.stopSupervising()
+	}
+	root.stopHealthChecks()
+
+	by := time.Now().Add(deadline)
+	var errors ErrorList
+	lv := root.startedLevels()
+	for i := len(lv) - 1; i >= 0; i-- {
+		timeout := time.Until(by)
+		if timeout <= 0 {
+			timeout = time.Nanosecond
+		}
+		_, errs := root.transitionLevel(reversed(lv[i]), StateStopped, timeout)
+		errors = append(errors, errs...)
+	}
+
+	for _, e := range root.entriesIn(StateStarted) {
+		errors = append(errors, stragglers(e, e.name)...)
+		root.force(e)
+	}
+	for _, e := range reversed(root.entriesIn(StateNew, StateInitialized, StateStopped)) {
+		if err := root.transition(e, StateDestroyed); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	root.closeContexts()
+
+	if len(errors) > 0 {
+		return ShutdownError(fmt.Sprintf("%d error(s)", len(errors))).WithCause(errors)
+	}
+	return nil
+}
+
+// returns the container of the component, or nil.
+func asContainer(comp Component) *container {
+	switch c := comp.(type) {
+	case *container:
+		return c
+	case *supervisor:
+		return c.container
+	}
+	return nil
+}
+
+// returns the errors naming the started components of the tree of the
+// entry, qualified by the path.
+func stragglers(e *entry, path string) ErrorList {
+	var errors ErrorList
+	if nested := asContainer(e.component); nested != nil {
+		for _, e := range nested.entriesIn(StateStarted) {
+			errors = append(errors, stragglers(e, path+"/"+e.name)...)
+		}
+	}
+	return append(errors, LifecycleError(fmt.Sprintf("%s: destroyed by force", path)))
+}
+
+// marks the entry destroyed, and destroys its component without waiting.
+func (c *container) force(e *entry) {
+	c.Lock()
+	from := e.state
+	e.state = StateDestroyed
+	e.forced = true
+	c.Unlock()
+	c.emit(Event{Kind: EventStateChanged, Path: "/" + e.name, Component: e.component, From: from, To: StateDestroyed})
+	e.bus.cancelAll()
+	if d, ok := e.component.(Destroyer); ok {
+		go d.Destroy()
+	}
+}
+
+// closes the contexts of the components of the tree.
+func (c *container) closeContexts() {
+	c.Lock()
+	entries := make([]*entry, 0, len(c.order))
+	for _, name := range c.order {
+		entries = append(entries, c.entries[name])
+	}
+	c.Unlock()
+	for _, e := range entries {
+		if nested := asContainer(e.component); nested != nil {
+			nested.closeContexts()
+		}
+		if e.unwatch != nil {
+			e.unwatch()
+		}
+		closeContext(e.context)
+	}
+}
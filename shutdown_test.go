@@ -0,0 +1,84 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	stdctx "context"
+	"goerror"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// testing: shutdown
+// ============================================================================
+
+func TestRun(t *testing.T) {
+	c := NewContainer()
+	log := &callLog{}
+	c.SetContext(NewContext())
+	a := newLifecycleComponent("a", log)
+	c.Add(a)
+	c.Add(newLifecycleComponent("b", log))
+
+	ctx, cancel := stdctx.WithCancel(stdctx.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, c, time.Second) }()
+
+	eventuallyCalls(t, log, "a.init", "b.init", "a.start", "b.start")
+	cancel()
+	select {
+	case e := <-done:
+		if e != nil {
+			t.Fatalf("Run - unexpected error: %s", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Run - did not return on cancellation")
+	}
+	assertCalls(t, log, "a.init", "b.init", "a.start", "b.start", "b.stop", "a.stop", "b.destroy", "a.destroy")
+	assertState(t, c, "a", StateDestroyed)
+	assertError(t, "Bind(closed component context)", a.context.Bind("x", 1), IllegalStateError)
+}
+
+func TestShutdownStragglers(t *testing.T) {
+	c, _ := newTestContainer(t)
+	log := &callLog{}
+	release := make(chan struct{})
+	defer close(release)
+
+	// stopped in reverse order: a, and then the nested container
+	nested := NewContainer()
+	c.AddNamed("nested", nested)
+	stuck := newDependentComponent("stuck", log, nil, nil)
+	stuck.onStop = func() { <-release }
+	nested.Add(stuck)
+	c.Add(newLifecycleComponent("a", log))
+	c.Start()
+	log.calls = nil
+
+	start := time.Now()
+	e := Shutdown(c, 50*time.Millisecond)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Shutdown - deadline is not enforced: %s", d)
+	}
+	if e == nil || !goerror.TypeOf(e).Is(ShutdownError) {
+		t.Fatalf("Shutdown - expected error: %s got: %v", ShutdownError(), e)
+	}
+	for _, s := range []string{"nested: stopped timed out", "nested/stuck: destroyed by force", "nested: destroyed by force"} {
+		if !strings.Contains(e.Error(), s) {
+			t.Fatalf("Shutdown - expected %q in error: %s", s, e)
+		}
+	}
+
+	assertState(t, c, "a", StateDestroyed)
+	assertState(t, c, "nested", StateDestroyed)
+	eventuallyCalls(t, log, "a.stop", "a.destroy")
+	assertError(t, "Bind(closed component context)", stuck.context.Bind("x", 1), IllegalStateError)
+
+	// the straggler does not revive once it stops
+	release <- struct{}{}
+	assertState(t, c, "nested", StateDestroyed)
+}
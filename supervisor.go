@@ -0,0 +1,362 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"fmt"
+	"goerror"
+	"time"
+)
+
+// Strategy is the restart strategy of a supervisor.
+type Strategy int
+
+const (
+	// OneForOne restarts the failed component.
+	OneForOne Strategy = iota
+	// OneForAll restarts all components.
+	OneForAll
+	// RestForOne restarts the failed component, and the components that
+	// were added after it.
+	RestForOne
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case OneForOne:
+		return "one-for-one"
+	case OneForAll:
+		return "one-for-all"
+	case RestForOne:
+		return "rest-for-one"
+	}
+	return fmt.Sprintf("strategy(%d)", int(s))
+}
+
+// FailureReporterBinding is the name of the FailureReporter binding in the
+// context of a supervised component.
+const FailureReporterBinding = "contextual.failure"
+
+// FailureReporter reports the failure of a started component to its
+// supervisor.
+type FailureReporter func(err error)
+
+// ReportFailure reports the failure via the FailureReporter bound in the
+// context.
+//
+// Errors:
+//
+//	NoSuchBindingError <= no reporter is bound in the context
+func ReportFailure(ctx Context, err error) error {
+	v, e := ctx.Lookup(FailureReporterBinding)
+	if e != nil {
+		return e
+	}
+	report, ok := v.(FailureReporter)
+	if !ok {
+		return NoSuchBindingError(FailureReporterBinding)
+	}
+	report(err)
+	return nil
+}
+
+// SupervisorOption configures a supervisor.
+type SupervisorOption func(*supervisor)
+
+// MaxRestarts sets the restart intensity limit of a supervisor: if more than
+// n restarts are required within the period, the supervisor stops all of its
+// components and escalates the failure. The default is 3 restarts in 5s.
+func MaxRestarts(n int, period time.Duration) SupervisorOption {
+	return func(s *supervisor) {
+		s.maxRestarts, s.period = n, period
+	}
+}
+
+// Backoff sets the delay before a restart. The delay doubles with each
+// restart within the intensity period, up to max. The default is 10ms, up
+// to 1s.
+func Backoff(initial, max time.Duration) SupervisorOption {
+	return func(s *supervisor) {
+		s.backoff, s.maxBackoff = initial, max
+	}
+}
+
+// ContainerOptions applies the container options (e.g. StopTimeout) to the
+// supervisor.
+func ContainerOptions(opts ...ContainerOption) SupervisorOption {
+	return func(s *supervisor) {
+		for _, opt := range opts {
+			opt(s.container)
+		}
+	}
+}
+
+// a failure report
+type failure struct {
+	entry  *entry
+	starts int // of the entry, when reported
+	err    error
+}
+
+// a supervisor is a container that restarts its failed components.
+type supervisor struct {
+	*container
+	strategy    Strategy
+	maxRestarts int
+	period      time.Duration
+	backoff     time.Duration
+	maxBackoff  time.Duration
+
+	failures []failure     // pending; guarded by the container lock
+	reported chan struct{} // signals pending failures
+	stop     chan struct{} // closed to stop the supervision loop
+	done     chan struct{} // closed when the supervision loop exits
+	restarts []time.Time   // within the intensity period
+}
+
+// NewSupervisor returns a new and empty supervising container.
+//
+// A FailureReporter is bound in the context of each component of the
+// supervisor. A started component that fails reports the failure (see
+// ReportFailure), and the supervisor restarts it per its strategy, subject
+// to the restart intensity limit (see MaxRestarts), after a backoff delay
+// (see Backoff). When the limit is exceeded, the supervisor stops all of its
+// components, and escalates a SupervisionError to the FailureReporter of its
+// own context. Only a supervisor parent binds a FailureReporter, and so only
+// a supervisor supervised by a supervisor is restarted by its parent; under
+// any other parent the escalation is not reported, and the supervisor stays
+// stopped. Either way the escalation, and a failure to report it, are
+// emitted as EventFailed events (see Subscribe).
+//
+// The supervisor is configured as a container by ContainerOptions.
+func NewSupervisor(strategy Strategy, opts ...SupervisorOption) Container {
+	s := &supervisor{
+		container:   NewContainer().(*container),
+		strategy:    strategy,
+		maxRestarts: 3,
+		period:      5 * time.Second,
+		backoff:     10 * time.Millisecond,
+		maxBackoff:  time.Second,
+		reported:    make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.container.added = s.added
+	return s
+}
+
+// binds the failure reporter of the entry in its context.
+func (s *supervisor) added(e *entry) error {
+	report := func(err error) {
+		s.Lock()
+		starts := e.starts
+		s.pend(failure{e, starts, err})
+		s.Unlock()
+		s.emit(Event{Kind: EventFailed, Path: "/" + e.name, Component: e.component, From: StateStarted, To: StateStarted, Err: err})
+		select {
+		case s.reported <- struct{}{}:
+		default: // already signaled
+		}
+	}
+	return e.context.Bind(FailureReporterBinding, FailureReporter(report))
+}
+
+// queues the failure, unless a failure of the same run of the component is
+// pending. The pending failures are thus bounded by the components, and none
+// are dropped however backlogged the supervisor is.
+// REVU: s must be locked.
+func (s *supervisor) pend(f failure) {
+	for _, p := range s.failures {
+		if p.entry == f.entry && p.starts == f.starts {
+			return
+		}
+	}
+	s.failures = append(s.failures, f)
+}
+
+// returns the next pending failure, if any.
+func (s *supervisor) next() (failure, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.failures) == 0 {
+		return failure{}, false
+	}
+	f := s.failures[0]
+	s.failures = s.failures[1:]
+	return f, true
+}
+
+// Start starts the components of the supervisor, and then supervises them.
+func (s *supervisor) Start() error {
+	if err := s.container.Start(); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.stop == nil {
+		s.stop, s.done = make(chan struct{}), make(chan struct{})
+		s.restarts = nil
+		go s.supervise(s.stop, s.done)
+	}
+	return nil
+}
+
+// Stop stops supervising, and then stops the components of the supervisor.
+func (s *supervisor) Stop() error {
+	s.stopSupervising()
+	return s.container.Stop()
+}
+
+// stops the supervision loop, if running.
+func (s *supervisor) stopSupervising() {
+	s.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Destroy stops and destroys the components of the supervisor.
+func (s *supervisor) Destroy() error {
+	err := s.Stop()
+	if e := s.container.Destroy(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// the supervision loop.
+func (s *supervisor) supervise(stop, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.reported:
+		}
+		for f, ok := s.next(); ok; f, ok = s.next() {
+			if !s.current(f) {
+				continue
+			}
+			if !s.recover(f, stop) {
+				return
+			}
+		}
+	}
+}
+
+// restarts the failed component per the strategy, until restarted. Returns
+// false if the supervisor is stopped or the failure is escalated.
+func (s *supervisor) recover(f failure, stop chan struct{}) bool {
+	for {
+		delay, ok := s.admit()
+		if !ok {
+			s.escalate(f)
+			return false
+		}
+		select {
+		case <-stop:
+			return false
+		case <-time.After(delay):
+		}
+		err := s.restart(f.entry)
+		if err == nil {
+			return true
+		}
+		f.err = err
+	}
+}
+
+// returns true if the failure is of the current run of a started component.
+func (s *supervisor) current(f failure) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.entries[f.entry.name] == f.entry && f.entry.state == StateStarted && f.entry.starts == f.starts
+}
+
+// admits a restart per the intensity limit, and returns its backoff delay.
+func (s *supervisor) admit() (time.Duration, bool) {
+	now := time.Now()
+	i := 0
+	for i < len(s.restarts) && now.Sub(s.restarts[i]) > s.period {
+		i++
+	}
+	s.restarts = s.restarts[i:]
+	n := len(s.restarts)
+	if n >= s.maxRestarts {
+		return 0, false
+	}
+	s.restarts = append(s.restarts, now)
+
+	delay := s.backoff
+	for ; n > 0 && delay < s.maxBackoff; n-- {
+		delay *= 2
+	}
+	if delay > s.maxBackoff {
+		delay = s.maxBackoff
+	}
+	return delay, true
+}
+
+// restarts the components per the strategy.
+func (s *supervisor) restart(failed *entry) error {
+	var entries []*entry
+	switch s.strategy {
+	case OneForOne:
+		entries = []*entry{failed}
+	case OneForAll:
+		entries = s.entriesIn(StateNew, StateInitialized, StateStarted, StateStopped)
+	case RestForOne:
+		all := s.entriesIn(StateNew, StateInitialized, StateStarted, StateStopped)
+		for i, e := range all {
+			if e == failed {
+				entries = all[i:]
+				break
+			}
+		}
+	}
+
+	for _, e := range reversed(append([]*entry(nil), entries...)) {
+		s.transition(e, StateStopped)
+	}
+	for _, e := range entries {
+		s.Lock()
+		state := e.state
+		s.Unlock()
+		if state == StateNew {
+			if err := s.transition(e, StateInitialized); err != nil {
+				return err
+			}
+		}
+		if err := s.transition(e, StateStarted); err != nil && !goerror.TypeOf(err).Is(IllegalStateError) {
+			return err
+		}
+	}
+	return nil
+}
+
+// stops the components, and escalates the failure. The escalation is
+// emitted as an EventFailed, and so is the error of an escalation that could
+// not be reported, e.g. if the supervisor is not supervised.
+func (s *supervisor) escalate(f failure) {
+	s.container.Stop()
+	s.Lock()
+	ctx := s.context
+	s.Unlock()
+	err := SupervisionError(fmt.Sprintf("%s: more than %d restarts in %s", f.entry.name, s.maxRestarts, s.period)).WithCause(f.err)
+	s.emit(Event{Kind: EventFailed, Path: "/" + f.entry.name, Component: f.entry.component, From: StateStarted, To: StateStopped, Err: err})
+	var e error = IllegalStateError("no context")
+	if ctx != nil {
+		e = ReportFailure(ctx, err)
+	}
+	if e != nil {
+		undelivered := SupervisionError(fmt.Sprintf("%s: escalation not reported", f.entry.name)).WithCause(e)
+		s.emit(Event{Kind: EventFailed, Path: "/" + f.entry.name, Component: f.entry.component, From: StateStopped, To: StateStopped, Err: undelivered})
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2011-2016 Joubin Houshyar.  All rights reserved.
+// Use of this source code is governed by a 2-clause BSD
+// license that can be found in the LICENSE file.
+
+package contextual
+
+import (
+	"errors"
+	"fmt"
+	"goerror"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// testing: supervision
+// ============================================================================
+
+// helper - polls the log until it has the expected calls.
+func eventuallyCalls(t *testing.T, log *callLog, expected ...string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !reflect.DeepEqual(log.get(), expected) {
+		if time.Now().After(deadline) {
+			t.Fatalf("lifecycle calls - expected:%v got:%v", expected, log.get())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newTestSupervisor(t *testing.T, strategy Strategy, log *callLog, names ...string) (Container, map[string]*lifecycleComponent) {
+	s := NewSupervisor(strategy, Backoff(time.Millisecond, 10*time.Millisecond), MaxRestarts(3, time.Minute))
+	s.SetContext(NewContext())
+	comps := make(map[string]*lifecycleComponent)
+	for _, name := range names {
+		comps[name] = newLifecycleComponent(name, log)
+		s.Add(comps[name])
+	}
+	if e := s.Start(); e != nil {
+		t.Fatalf("Start - unexpected error: %s", e)
+	}
+	log.calls = nil
+	return s, comps
+}
+
+func TestSupervisorStrategies(t *testing.T) {
+	failure := errors.New("connection lost")
+	for _, test := range []struct {
+		strategy Strategy
+		expected []string
+	}{
+		{OneForOne, []string{"b.stop", "b.start"}},
+		{OneForAll, []string{"c.stop", "b.stop", "a.stop", "a.start", "b.start", "c.start"}},
+		{RestForOne, []string{"c.stop", "b.stop", "b.start", "c.start"}},
+	} {
+		log := &callLog{}
+		s, comps := newTestSupervisor(t, test.strategy, log, "a", "b", "c")
+		if e := ReportFailure(comps["b"].context, failure); e != nil {
+			t.Fatalf("%s: ReportFailure - unexpected error: %s", test.strategy, e)
+		}
+		eventuallyCalls(t, log, test.expected...)
+		for _, name := range []string{"a", "b", "c"} {
+			assertState(t, s, name, StateStarted)
+		}
+		s.Destroy()
+	}
+}
+
+func TestSupervisorStaleFailures(t *testing.T) {
+	log := &callLog{}
+	s, comps := newTestSupervisor(t, OneForOne, log, "a")
+	defer s.Destroy()
+
+	// repeated reports of the same failure restart once
+	for i := 0; i < 3; i++ {
+		ReportFailure(comps["a"].context, errors.New("oops"))
+	}
+	eventuallyCalls(t, log, "a.stop", "a.start")
+	time.Sleep(20 * time.Millisecond)
+	assertCalls(t, log, "a.stop", "a.start")
+
+	// failures of stopped components are ignored
+	s.Transition("a", StateStopped)
+	log.calls = nil
+	ReportFailure(comps["a"].context, errors.New("oops"))
+	time.Sleep(20 * time.Millisecond)
+	assertCalls(t, log)
+}
+
+func TestSupervisorBacklog(t *testing.T) {
+	log := &callLog{}
+	s := NewSupervisor(OneForOne, Backoff(0, 0), MaxRestarts(200, time.Minute))
+	s.SetContext(NewContext())
+	gate := newDependentComponent("gate", log, nil, nil)
+	s.Add(gate)
+	var comps []*lifecycleComponent
+	for i := 0; i < 100; i++ {
+		comp := newLifecycleComponent(fmt.Sprintf("c%d", i), log)
+		comps = append(comps, comp)
+		s.Add(comp)
+	}
+	if e := s.Start(); e != nil {
+		t.Fatalf("Start - unexpected error: %s", e)
+	}
+	defer s.Destroy()
+	log.calls = nil
+
+	// the failures reported while the gate restarts are not dropped
+	release := make(chan struct{})
+	gate.onStart = func() { <-release }
+	ReportFailure(gate.context, errors.New("oops"))
+	for _, comp := range comps {
+		ReportFailure(comp.context, errors.New("oops"))
+	}
+	close(release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		starts := 0
+		for _, call := range log.get() {
+			if strings.HasSuffix(call, ".start") {
+				starts++
+			}
+		}
+		if starts == len(comps)+1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("restarts - expected:%d got:%d", len(comps)+1, starts)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSupervisorContainerOptions(t *testing.T) {
+	s := NewSupervisor(OneForOne, ContainerOptions(StopTimeout(time.Second)))
+	if d := s.(*supervisor).stopTimeout; d != time.Second {
+		t.Fatalf("StopTimeout - expected:%s got:%s", time.Second, d)
+	}
+}
+
+func TestSupervisorEscalation(t *testing.T) {
+	log := &callLog{}
+	s, comps := newTestSupervisor(t, OneForOne, log, "a", "b")
+	defer s.Destroy()
+
+	escalated := make(chan error, 1)
+	s.(*supervisor).context.Bind(FailureReporterBinding, FailureReporter(func(e error) { escalated <- e }))
+
+	for i := 0; i < 4; i++ {
+		log.calls = nil
+		ReportFailure(comps["a"].context, errors.New("oops"))
+		if i < 3 {
+			eventuallyCalls(t, log, "a.stop", "a.start")
+		}
+	}
+	select {
+	case e := <-escalated:
+		if !goerror.TypeOf(e).Is(SupervisionError) {
+			t.Fatalf("escalation - expected error: %s got: %v", SupervisionError(), e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("escalation - restart intensity is not limited")
+	}
+	assertState(t, s, "a", StateStopped)
+	assertState(t, s, "b", StateStopped)
+}
+
+func TestUnsupervisedEscalation(t *testing.T) {
+	log := &callLog{}
+	s, comps := newTestSupervisor(t, OneForOne, log, "a")
+	defer s.Destroy()
+
+	// no reporter is bound in the context of the supervisor
+	escalations := make(chan Event, 2)
+	s.Subscribe(func(ev Event) {
+		if ev.Kind == EventFailed && goerror.TypeOf(ev.Err).Is(SupervisionError) {
+			escalations <- ev
+		}
+	})
+	for i := 0; i < 4; i++ {
+		log.calls = nil
+		ReportFailure(comps["a"].context, errors.New("oops"))
+		if i < 3 {
+			eventuallyCalls(t, log, "a.stop", "a.start")
+		}
+	}
+	for _, expected := range []string{"more than 3 restarts", "not reported"} {
+		select {
+		case ev := <-escalations:
+			if !strings.Contains(ev.Err.Error(), expected) {
+				t.Fatalf("escalation - expected event: %s got: %v", expected, ev)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("escalation - expected event: %s", expected)
+		}
+	}
+	assertState(t, s, "a", StateStopped)
+}
+
+func TestNestedSupervisors(t *testing.T) {
+	log := &callLog{}
+	root := NewSupervisor(OneForOne, Backoff(0, 0))
+	root.SetContext(NewContext())
+	nested := NewSupervisor(OneForOne, Backoff(0, 0), MaxRestarts(0, time.Minute))
+	root.AddNamed("nested", nested)
+	comp := newLifecycleComponent("a", log)
+	nested.Add(comp)
+	if e := root.Start(); e != nil {
+		t.Fatalf("Start - unexpected error: %s", e)
+	}
+	defer root.Destroy()
+	log.calls = nil
+
+	// the nested supervisor escalates at once, and is restarted by the root
+	ReportFailure(comp.context, errors.New("oops"))
+	eventuallyCalls(t, log, "a.stop", "a.start")
+	assertState(t, root, "nested", StateStarted)
+	assertState(t, nested, "a", StateStarted)
+}